@@ -213,7 +213,7 @@ func (rbTree *RbTree) DeleteByKey(key RbTreeKeyType) {
 	if node.Left == sentinelNode || node.Right == sentinelNode {
 		willDeleteNode = node
 	} else {
-		willDeleteNode = node.FindMinNodeBy(sentinelNode)
+		willDeleteNode = node.Right.FindMinNodeBy(sentinelNode)
 	}
 
 	if willDeleteNode.Left != sentinelNode {
@@ -257,7 +257,7 @@ func (rbTree *RbTree) DeleteByNode(node *RbTreeNode) {
 	if node.Left == sentinelNode || node.Right == sentinelNode {
 		willDeleteNode = node
 	} else {
-		willDeleteNode = node.FindMinNodeBy(sentinelNode)
+		willDeleteNode = node.Right.FindMinNodeBy(sentinelNode)
 	}
 
 	if willDeleteNode.Left != sentinelNode {
@@ -349,10 +349,10 @@ func (rbTree *RbTree) DeleteFixUp(node *RbTreeNode) {
 func (rbTree *RbTree) GetNode(key RbTreeKeyType) *RbTreeNode {
 	node := rbTree.Root
 	for node != rbTree.Sentinel {
-		if node.Key > key {
-			node = node.Right
-		} else if node.Key < key {
+		if key < node.Key {
 			node = node.Left
+		} else if key > node.Key {
+			node = node.Right
 		} else {
 			return node
 		}
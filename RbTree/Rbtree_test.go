@@ -0,0 +1,101 @@
+package rbtree
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// checkTree verifies the search order, the parent links and the red-black
+// properties of the tree and returns its keys in order
+func checkTree(t *testing.T, tree *RbTree) []RbTreeKeyType {
+	var keys []RbTreeKeyType
+	var walk func(node *RbTreeNode) int
+	walk = func(node *RbTreeNode) int {
+		if node == tree.Sentinel {
+			return 1
+		}
+		if node.Color == RED {
+			assert.Equal(t, BLACK, node.Left.Color)
+			assert.Equal(t, BLACK, node.Right.Color)
+		}
+		if node.Left != tree.Sentinel {
+			assert.Equal(t, node, node.Left.Parent)
+		}
+		if node.Right != tree.Sentinel {
+			assert.Equal(t, node, node.Right.Parent)
+		}
+		left := walk(node.Left)
+		keys = append(keys, node.Key)
+		right := walk(node.Right)
+		assert.Equal(t, left, right, "black height differs below key %d", node.Key)
+		if node.Color == BLACK {
+			left++
+		}
+		return left
+	}
+	assert.Equal(t, BLACK, tree.Root.Color)
+	walk(tree.Root)
+	for i := 1; i < len(keys); i++ {
+		assert.Less(t, keys[i-1], keys[i])
+	}
+	assert.Equal(t, tree.NodeNum, len(keys))
+	return keys
+}
+
+func TestRbTree(t *testing.T) {
+	tree := NewRbTree()
+	rnd := rand.New(rand.NewSource(1))
+	present := make(map[RbTreeKeyType]bool)
+	for len(present) < 500 {
+		key := RbTreeKeyType(rnd.Uint32())
+		if !present[key] {
+			present[key] = true
+			tree.InsertNewNode(tree.NewRbTreeNode(key, int(key)))
+		}
+	}
+	checkTree(t, tree)
+
+	// every key is found in either subtree of the root
+	for key := range present {
+		node := tree.GetNode(key)
+		assert.NotEqual(t, tree.Sentinel, node, "key %d not found", key)
+		assert.Equal(t, int(key), node.Value)
+	}
+
+	// deleting nodes with two children replaces them with their successor
+	deleted := 0
+	for key := range present {
+		if deleted == 200 {
+			break
+		}
+		node := tree.GetNode(key)
+		if node.Left == tree.Sentinel || node.Right == tree.Sentinel {
+			continue
+		}
+		if deleted%2 == 0 {
+			tree.DeleteByKey(key)
+		} else {
+			tree.DeleteByNode(node)
+		}
+		delete(present, key)
+		deleted++
+		checkTree(t, tree)
+	}
+	assert.Equal(t, 200, deleted)
+	assert.Equal(t, len(present), tree.NodeNum)
+	for key := range present {
+		node := tree.GetNode(key)
+		assert.NotEqual(t, tree.Sentinel, node, "key %d lost after deletes", key)
+		assert.Equal(t, int(key), node.Value)
+	}
+
+	// the rest can be deleted in any order
+	for key := range present {
+		tree.DeleteByKey(key)
+		assert.Equal(t, tree.Sentinel, tree.GetNode(key))
+	}
+	assert.Equal(t, 0, tree.NodeNum)
+	assert.Equal(t, tree.Sentinel, tree.Root)
+}
@@ -0,0 +1,229 @@
+package acl
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Permission is the access level granted on a key prefix.
+// Each level includes the ones below it.
+type Permission int
+
+const (
+	None Permission = iota
+	Read
+	Write
+	Admin
+)
+
+var ErrUnauthenticated = errors.New("unknown or missing token")
+
+func (p Permission) String() string {
+	switch p {
+	case Read:
+		return "read"
+	case Write:
+		return "write"
+	case Admin:
+		return "admin"
+	default:
+		return "none"
+	}
+}
+
+func parsePermission(s string) (Permission, error) {
+	switch s {
+	case "read":
+		return Read, nil
+	case "write":
+		return Write, nil
+	case "admin":
+		return Admin, nil
+	default:
+		return None, fmt.Errorf("unknown permission %q", s)
+	}
+}
+
+// AllNamespaces grants a permission in every namespace.
+const AllNamespaces = "*"
+
+// Grant gives a permission on all the keys of Namespace starting with Prefix.
+// An empty namespace is the default namespace, and an empty prefix covers the
+// whole namespace.
+type Grant struct {
+	Namespace  string `json:"namespace"`
+	Prefix     string `json:"prefix"`
+	Permission string `json:"permission"`
+}
+
+// User is an entry of the policy file. Only the SHA-256 of the token is stored.
+type User struct {
+	Name        string  `json:"name"`
+	TokenSHA256 string  `json:"token_sha256"`
+	Grants      []Grant `json:"grants"`
+}
+
+type policyFile struct {
+	Users []User `json:"users"`
+}
+
+type grant struct {
+	namespace  string
+	prefix     string
+	permission Permission
+}
+
+// Principal is an authenticated user and its grants.
+type Principal struct {
+	Name   string
+	grants []grant
+}
+
+// Allowed reports whether the principal has at least perm on key in namespace.
+func (p *Principal) Allowed(namespace string, key string, perm Permission) bool {
+	for _, g := range p.grants {
+		if g.permission >= perm && (g.namespace == AllNamespaces || g.namespace == namespace) && strings.HasPrefix(key, g.prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAdmin reports whether the principal administers every namespace.
+func (p *Principal) IsAdmin() bool {
+	return p.Allowed(AllNamespaces, "", Admin)
+}
+
+// Policy maps tokens to principals.
+type Policy struct {
+	principals map[string]*Principal // by hex SHA-256 of the token
+}
+
+// ParsePolicy parses a JSON policy file.
+func ParsePolicy(content []byte) (*Policy, error) {
+	var file policyFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, err
+	}
+	policy := &Policy{principals: make(map[string]*Principal)}
+	for _, user := range file.Users {
+		hash := strings.ToLower(user.TokenSHA256)
+		if user.Name == "" || len(hash) != sha256.Size*2 {
+			return nil, fmt.Errorf("user %q needs a name and a token_sha256", user.Name)
+		}
+		if _, ok := policy.principals[hash]; ok {
+			return nil, fmt.Errorf("user %q shares its token with another user", user.Name)
+		}
+		principal := &Principal{Name: user.Name}
+		for _, g := range user.Grants {
+			perm, err := parsePermission(g.Permission)
+			if err != nil {
+				return nil, fmt.Errorf("user %q: %v", user.Name, err)
+			}
+			principal.grants = append(principal.grants, grant{namespace: g.Namespace, prefix: g.Prefix, permission: perm})
+		}
+		policy.principals[hash] = principal
+	}
+	return policy, nil
+}
+
+// Authenticate returns the principal owning token.
+func (p *Policy) Authenticate(token string) (*Principal, error) {
+	hash := sha256.Sum256([]byte(token))
+	principal, ok := p.principals[hex.EncodeToString(hash[:])]
+	if token == "" || !ok {
+		return nil, ErrUnauthenticated
+	}
+	return principal, nil
+}
+
+// Authorizer holds the policy loaded from a file and reloads it when the file changes.
+type Authorizer struct {
+	file string
+
+	mu      sync.RWMutex
+	policy  *Policy
+	modTime time.Time
+}
+
+func NewAuthorizer(file string) (*Authorizer, error) {
+	a := &Authorizer{file: file}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reload loads the policy file again. The previous policy stays in use on error.
+func (a *Authorizer) Reload() error {
+	info, err := os.Stat(a.file)
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(a.file)
+	if err != nil {
+		return err
+	}
+	policy, err := ParsePolicy(content)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.policy, a.modTime = policy, info.ModTime()
+	return nil
+}
+
+// Watch reloads the policy every interval when the file has changed, until ctx is done.
+func (a *Authorizer) Watch(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		info, err := os.Stat(a.file)
+		if err == nil {
+			a.mu.RLock()
+			changed := !info.ModTime().Equal(a.modTime)
+			a.mu.RUnlock()
+			if !changed {
+				continue
+			}
+			err = a.Reload()
+		}
+		if err != nil && onError != nil {
+			onError(err)
+		}
+	}
+}
+
+// Authenticate returns the principal owning token under the current policy.
+func (a *Authorizer) Authenticate(token string) (*Principal, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.policy.Authenticate(token)
+}
+
+type principalKey struct{}
+
+// NewContext returns a context carrying the authenticated principal.
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal authenticated for the request, if any.
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
@@ -0,0 +1,90 @@
+package acl
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func tokenHash(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func testPolicy(teamBPermission string) []byte {
+	return []byte(fmt.Sprintf(`{"users": [
+		{"name": "team-a", "token_sha256": %q, "grants": [{"namespace": "a", "permission": "write"}, {"prefix": "shared/", "permission": "read"}]},
+		{"name": "team-b", "token_sha256": %q, "grants": [{"prefix": "b/", "permission": %q}]},
+		{"name": "ops", "token_sha256": %q, "grants": [{"namespace": "*", "permission": "admin"}]}
+	]}`, tokenHash("token-a"), tokenHash("token-b"), teamBPermission, tokenHash("token-ops")))
+}
+
+func TestPolicy(t *testing.T) {
+	policy, err := ParsePolicy(testPolicy("read"))
+	assert.Nil(t, err)
+
+	_, err = policy.Authenticate("unknown")
+	assert.Equal(t, ErrUnauthenticated, err)
+	_, err = policy.Authenticate("")
+	assert.Equal(t, ErrUnauthenticated, err)
+
+	a, err := policy.Authenticate("token-a")
+	assert.Nil(t, err)
+	assert.Equal(t, "team-a", a.Name)
+	assert.True(t, a.Allowed("a", "key", Read))
+	assert.True(t, a.Allowed("a", "key", Write))
+	assert.False(t, a.Allowed("a", "key", Admin))
+	assert.False(t, a.Allowed("b", "key", Read))
+	assert.True(t, a.Allowed("", "shared/key", Read))
+	assert.False(t, a.Allowed("", "shared/key", Write))
+	assert.False(t, a.Allowed("", "b/key", Read))
+	assert.False(t, a.IsAdmin())
+
+	ops, err := policy.Authenticate("token-ops")
+	assert.Nil(t, err)
+	assert.True(t, ops.Allowed("", "b/key", Write))
+	assert.True(t, ops.Allowed("a", "key", Write))
+	assert.True(t, ops.IsAdmin())
+
+	_, err = ParsePolicy(testPolicy("owner"))
+	assert.NotNil(t, err)
+	_, err = ParsePolicy([]byte(`{"users": [{"name": "x", "token_sha256": "short"}]}`))
+	assert.NotNil(t, err)
+}
+
+func TestAuthorizer_Reload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "acl.json")
+	assert.Nil(t, os.WriteFile(file, testPolicy("read"), 0600))
+	authorizer, err := NewAuthorizer(file)
+	assert.Nil(t, err)
+	b, err := authorizer.Authenticate("token-b")
+	assert.Nil(t, err)
+	assert.False(t, b.Allowed("", "b/key", Write))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go authorizer.Watch(ctx, 10*time.Millisecond, nil)
+
+	// an invalid file keeps the previous policy
+	assert.Nil(t, os.WriteFile(file, []byte("{"), 0600))
+	future := time.Now().Add(time.Minute)
+	assert.Nil(t, os.Chtimes(file, future, future))
+	time.Sleep(50 * time.Millisecond)
+	_, err = authorizer.Authenticate("token-b")
+	assert.Nil(t, err)
+
+	assert.Nil(t, os.WriteFile(file, testPolicy("write"), 0600))
+	future = future.Add(time.Minute)
+	assert.Nil(t, os.Chtimes(file, future, future))
+	assert.Eventually(t, func() bool {
+		b, err := authorizer.Authenticate("token-b")
+		return err == nil && b.Allowed("", "b/key", Write)
+	}, 5*time.Second, 10*time.Millisecond)
+}
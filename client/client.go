@@ -0,0 +1,205 @@
+// Package client 是 NO-DB 的 Go 客户端：按一致性哈希将键路由到服务端，跟随集群拓扑、
+// 重定向和主节点切换，并在静态节点列表下支持增删节点时迁移数据。Client 可以并发使用。
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sidneychang/no-db/consistenthash"
+	pb "github.com/sidneychang/no-db/proto"
+	"github.com/sidneychang/no-db/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+)
+
+// Client 封装一致性哈希环和 gRPC 连接池
+type Client struct {
+	opts options
+
+	ringMu          sync.RWMutex
+	hashRing        consistenthash.Ring // 一致性哈希环
+	topologyVersion uint64              // 集群拓扑的版本，为 0 时哈希环由 New 的节点列表和 AddNode 维护
+	seeds           []string            // 用于查询集群拓扑的节点
+	replicas        int                 // 每个键保存在多少个分片上，即哈希环上键之后的几个分片
+
+	poolMu sync.Mutex
+	nodes  map[string]*poolNode // 连接池，客户端关闭后为 nil
+	stats  PoolStats
+	stop   chan struct{} // 关闭时停止健康检查
+
+	coordinatorConn *grpc.ClientConn
+	coordinator     pb.CoordinatorClient // 协调节点，用于在故障转移后查找分片的主节点
+	primaryMu       sync.RWMutex
+	primaries       map[string]string   // 分片名到当前主节点地址的缓存
+	followers       map[string][]string // 分片名到从主节点复制数据的副本地址
+}
+
+// New 创建客户端，nodes 为静态哈希环中的节点，集群拓扑由协调节点维护时作为查询拓扑的种子节点；
+// 使用集群拓扑前需要调用 RefreshTopology
+func New(nodes []string, opts ...Option) (*Client, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	hashFunc, err := consistenthash.ParseHashFunc(o.ringHash)
+	if err != nil {
+		return nil, err
+	}
+	var ring consistenthash.Ring
+	if o.ringType == SortedRing {
+		ring = consistenthash.NewHashRingWithHash(nodes, o.virtualNodes, hashFunc)
+	} else {
+		ring = consistenthash.NewRbHashRingWithHash(nodes, o.virtualNodes, hashFunc)
+	}
+	c := &Client{
+		opts:      o,
+		hashRing:  ring,
+		seeds:     append([]string(nil), nodes...),
+		nodes:     make(map[string]*poolNode),
+		stop:      make(chan struct{}),
+		primaries: make(map[string]string),
+	}
+	if o.coordinator != "" {
+		conn, err := grpc.Dial(o.coordinator, c.dialOptions()...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to coordinator: %v", err)
+		}
+		c.coordinatorConn = conn
+		c.coordinator = pb.NewCoordinatorClient(conn)
+	}
+	if o.healthInterval > 0 {
+		go c.checkHealth(o.healthInterval)
+	}
+	return c, nil
+}
+
+// Close 停止健康检查并关闭所有连接，之后的请求返回 ErrClosed
+func (c *Client) Close() error {
+	c.poolMu.Lock()
+	defer c.poolMu.Unlock()
+	if c.nodes == nil {
+		return nil
+	}
+	close(c.stop)
+	var errs []error
+	for _, n := range c.nodes {
+		if n.conn != nil {
+			errs = append(errs, n.conn.Close())
+		}
+	}
+	if c.coordinatorConn != nil {
+		errs = append(errs, c.coordinatorConn.Close())
+	}
+	c.nodes = nil
+	return errors.Join(errs...)
+}
+
+// Put 将键值对写入键所属的节点
+func (c *Client) Put(ctx context.Context, key, value string) error {
+	_, err := c.withFailover(ctx, "put", key, func(ctx context.Context, client pb.KVDBClient) (interface{}, error) {
+		return client.Put(ctx, &pb.PutRequest{Key: key, Value: value, Namespace: c.opts.namespace})
+	})
+	return err
+}
+
+// Get 返回键的值，键不存在时返回的错误满足 errors.Is(err, ErrNotFound)。
+// 主节点不可用或使用对冲读时，值可能来自尚未复制到最新写入的副本
+func (c *Client) Get(ctx context.Context, key string) (string, error) {
+	reply, err := c.withFailover(ctx, "get", key, func(ctx context.Context, client pb.KVDBClient) (interface{}, error) {
+		return client.Get(ctx, &pb.GetRequest{Key: key, Namespace: c.opts.namespace})
+	})
+	if err != nil {
+		return "", err
+	}
+	return reply.(*pb.GetResponse).Value, nil
+}
+
+// Delete 删除键，键不存在时不返回错误
+func (c *Client) Delete(ctx context.Context, key string) error {
+	_, err := c.withFailover(ctx, "delete", key, func(ctx context.Context, client pb.KVDBClient) (interface{}, error) {
+		return client.Delete(ctx, &pb.DeleteRequest{Key: key, Namespace: c.opts.namespace})
+	})
+	return err
+}
+
+// withFailover 执行请求并按重试策略重试：服务端返回重定向时刷新集群拓扑并立即向重定向的地址重试；
+// 节点不可用时先改用保存该键的其他副本，仍然失败时退避后刷新路由再重试
+func (c *Client) withFailover(ctx context.Context, op string, key string, call rpc) (reply interface{}, err error) {
+	// 每次请求节点的 span 由 tracing.DialOption 记录，是这个 span 的子 span
+	ctx, span := tracer.Start(ctx, "client."+op)
+	defer func() { endSpan(span, err) }()
+	read := op == "get"
+	var hedge time.Duration
+	if read {
+		hedge = c.opts.hedgeDelay
+	}
+	node, err := c.route(ctx, key)
+	if err != nil {
+		return nil, requestError(op, key, "", err)
+	}
+	policy := c.opts.retry
+	var r result
+	for retry := 0; ; retry++ {
+		r = c.attempt(ctx, node, c.fallbacks(key, node, read), hedge, call)
+		if r.err == nil || retry >= policy.Retries || ctx.Err() != nil {
+			break
+		}
+		if redirect := redirectOf(r.err); redirect != nil {
+			// 服务端的拓扑与本地不一致，刷新哈希环供之后的请求使用
+			span.AddEvent("redirect", trace.WithAttributes(attribute.String("node", redirect.Address)))
+			c.refreshTopologyTo(ctx, redirect.Version)
+			node = redirect.Address
+			continue
+		}
+		if !retryable(r.err) {
+			break
+		}
+		backoff := policy.backoff(retry)
+		span.AddEvent("retry", trace.WithAttributes(attribute.Int("retry", retry+1), attribute.String("backoff", backoff.String())))
+		if !sleep(ctx, backoff) {
+			break
+		}
+		c.refreshRoute(ctx, key)
+		if node, err = c.route(ctx, key); err != nil {
+			return nil, requestError(op, key, "", err)
+		}
+	}
+	return r.reply, requestError(op, key, r.node, r.err)
+}
+
+// route 返回键当前所属节点的地址
+func (c *Client) route(ctx context.Context, key string) (string, error) {
+	_, span := tracer.Start(ctx, "client.route")
+	defer span.End()
+	node := c.ring().Get(key)
+	if node == "" {
+		return "", ErrNoNodes
+	}
+	return c.resolvePrimary(node), nil
+}
+
+// tokenCredentials 在请求的 authorization 头中携带 token
+type tokenCredentials string
+
+func (t tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+// RequireTransportSecurity 要求 TLS 连接，避免 token 以明文发送
+func (t tokenCredentials) RequireTransportSecurity() bool {
+	return true
+}
+
+// dialOptions 返回连接服务端时使用的选项
+func (c *Client) dialOptions() []grpc.DialOption {
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(c.opts.creds), tracing.DialOption()}
+	if c.opts.token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(tokenCredentials(c.opts.token)))
+	}
+	return opts
+}
@@ -0,0 +1,263 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "github.com/sidneychang/no-db/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// BenchmarkClient measures a Put, a Get and a Delete against two in-memory servers
+func BenchmarkClient(b *testing.B) {
+	addr1, _ := startKVServer(b)
+	addr2, _ := startKVServer(b)
+	// no retries, the benchmark measures single requests
+	client, err := New([]string{addr1, addr2}, WithRetries(0))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer client.Close()
+	ctx := context.Background()
+
+	var workers atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(p *testing.PB) {
+		worker := workers.Add(1)
+		for i := 0; p.Next(); i++ {
+			key := fmt.Sprintf("key-%d-%d", worker, i)
+			if err := client.Put(ctx, key, "value"); err != nil {
+				b.Errorf("Put failed: %v", err)
+				return
+			}
+			if _, err := client.Get(ctx, key); err != nil {
+				b.Errorf("Get failed: %v", err)
+				return
+			}
+			if err := client.Delete(ctx, key); err != nil {
+				b.Errorf("Delete failed: %v", err)
+				return
+			}
+		}
+	})
+}
+
+// kvServer is an in-memory KVDB server
+type kvServer struct {
+	pb.UnimplementedKVDBServer
+	mu       sync.Mutex
+	data     map[string]string
+	delay    time.Duration // how long Get and Put wait before answering
+	failures int           // number of Get and Put requests still to fail with Unavailable
+	calls    int           // number of Get and Put requests received
+	health   *health.Server
+}
+
+// serve counts a Get or Put request, delays it and fails it while failures remain
+func (s *kvServer) serve() error {
+	s.mu.Lock()
+	s.calls++
+	delay := s.delay
+	fail := s.failures > 0
+	if fail {
+		s.failures--
+	}
+	s.mu.Unlock()
+	time.Sleep(delay)
+	if fail {
+		return status.Error(codes.Unavailable, "restarting")
+	}
+	return nil
+}
+
+func (s *kvServer) Put(ctx context.Context, req *pb.PutRequest) (*pb.Empty, error) {
+	if err := s.serve(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[req.Namespace+"/"+req.Key] = req.Value
+	return &pb.Empty{}, nil
+}
+
+func (s *kvServer) Get(ctx context.Context, req *pb.GetRequest) (*pb.GetResponse, error) {
+	if err := s.serve(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.data[req.Namespace+"/"+req.Key]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "key not found")
+	}
+	return &pb.GetResponse{Value: value}, nil
+}
+
+func (s *kvServer) Delete(ctx context.Context, req *pb.DeleteRequest) (*pb.Empty, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data, req.Namespace+"/"+req.Key)
+	return &pb.Empty{}, nil
+}
+
+func (s *kvServer) ListAllData(ctx context.Context, req *pb.ListAllDataRequest) (*pb.ListAllDataResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resp := &pb.ListAllDataResponse{}
+	for key, value := range s.data {
+		if namespace, key, _ := strings.Cut(key, "/"); namespace == req.Namespace {
+			resp.Keys = append(resp.Keys, key)
+			resp.Values = append(resp.Values, value)
+		}
+	}
+	return resp, nil
+}
+
+// update changes the behaviour of the server while requests may be in flight
+func (s *kvServer) update(f func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f()
+}
+
+func (s *kvServer) callCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls
+}
+
+func (s *kvServer) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.data)
+}
+
+// startKVServer serves an empty kvServer on a local port
+func startKVServer(t testing.TB) (string, *kvServer) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	kv := &kvServer{data: make(map[string]string), health: health.NewServer()}
+	grpcServer := grpc.NewServer()
+	pb.RegisterKVDBServer(grpcServer, kv)
+	healthpb.RegisterHealthServer(grpcServer, kv.health)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+	return listener.Addr().String(), kv
+}
+
+func TestClient_Operations(t *testing.T) {
+	addr1, kv1 := startKVServer(t)
+	addr2, kv2 := startKVServer(t)
+	client, err := New([]string{addr1, addr2}, WithNamespace("ns"), WithRing(SortedRing), WithRingHash("xxhash"))
+	assert.Nil(t, err)
+	defer client.Close()
+	ctx := context.Background()
+
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, client.Put(ctx, fmt.Sprintf("key-%d", i), fmt.Sprintf("value-%d", i)))
+	}
+	assert.Equal(t, 20, kv1.len()+kv2.len())
+	assert.NotZero(t, kv1.len())
+	assert.NotZero(t, kv2.len())
+	value, err := client.Get(ctx, "key-3")
+	assert.Nil(t, err)
+	assert.Equal(t, "value-3", value)
+
+	assert.Nil(t, client.Delete(ctx, "key-3"))
+	_, err = client.Get(ctx, "key-3")
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	var requestErr *Error
+	assert.True(t, errors.As(err, &requestErr))
+	assert.Equal(t, "get", requestErr.Op)
+	assert.Equal(t, "key-3", requestErr.Key)
+
+	// an unknown hash function is rejected up front
+	_, err = New([]string{addr1}, WithRingHash("md5"))
+	assert.NotNil(t, err)
+}
+
+func TestClient_Concurrent(t *testing.T) {
+	addr1, _ := startKVServer(t)
+	addr2, _ := startKVServer(t)
+	client, err := New([]string{addr1, addr2})
+	assert.Nil(t, err)
+	defer client.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx := context.Background()
+			for j := 0; j < 50; j++ {
+				key := fmt.Sprintf("key-%d-%d", i, j)
+				assert.Nil(t, client.Put(ctx, key, key))
+				value, err := client.Get(ctx, key)
+				assert.Nil(t, err)
+				assert.Equal(t, key, value)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Nil(t, client.Close())
+	err = client.Put(context.Background(), "key", "value")
+	assert.True(t, errors.Is(err, ErrClosed))
+}
+
+func TestClient_Timeout(t *testing.T) {
+	// nothing listens on the address, so every request waits for the connection until it times out
+	client, err := New([]string{"10.255.255.1:50051"}, WithTimeout(50*time.Millisecond), WithRetries(0))
+	assert.Nil(t, err)
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = client.Get(ctx, "key")
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Contains(t, []codes.Code{codes.DeadlineExceeded, codes.Unavailable}, status.Code(err))
+}
+
+func TestClient_AddAndDeleteNode(t *testing.T) {
+	addr1, kv1 := startKVServer(t)
+	addr2, kv2 := startKVServer(t)
+	client, err := New([]string{addr1}, WithVirtualNodes(50))
+	assert.Nil(t, err)
+	defer client.Close()
+	ctx := context.Background()
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, client.Put(ctx, fmt.Sprintf("key-%d", i), "value"))
+	}
+
+	migration, err := client.AddNode(ctx, addr2)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, migration.Failed)
+	assert.Equal(t, kv2.len(), migration.Moved)
+	assert.NotZero(t, migration.Moved)
+	assert.Equal(t, 100, kv1.len()+kv2.len())
+	for i := 0; i < 100; i++ {
+		_, err := client.Get(ctx, fmt.Sprintf("key-%d", i))
+		assert.Nil(t, err)
+	}
+
+	migration, err = client.DeleteNode(ctx, addr2)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, kv2.len())
+	assert.Equal(t, 100, kv1.len())
+
+	// decommissioning a shard goes through the coordinator
+	assert.Equal(t, ErrNoCoordinator, client.Decommission(ctx, "a"))
+}
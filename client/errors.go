@@ -0,0 +1,74 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	// ErrNotFound 键不存在
+	ErrNotFound = errors.New("key not found")
+	// ErrClusterManaged 集群拓扑由协调节点维护，不能在客户端增删节点
+	ErrClusterManaged = errors.New("cluster membership is managed by the coordinator")
+	// ErrNoCoordinator 操作需要协调节点，但创建客户端时没有使用 WithCoordinator
+	ErrNoCoordinator = errors.New("no coordinator configured")
+	// ErrNoNodes 哈希环上没有节点
+	ErrNoNodes = errors.New("no nodes on the hash ring")
+	// ErrClosed 客户端已经关闭
+	ErrClosed = errors.New("client is closed")
+	// ErrCircuitOpen 节点连续失败过多被熔断，请求没有发出；它的 gRPC 错误码为 Unavailable，
+	// 因此请求会像节点不可用时一样改用其他副本
+	ErrCircuitOpen error = circuitOpenError{}
+)
+
+type circuitOpenError struct{}
+
+func (circuitOpenError) Error() string {
+	return "circuit breaker is open"
+}
+
+func (circuitOpenError) GRPCStatus() *status.Status {
+	return status.New(codes.Unavailable, "circuit breaker is open")
+}
+
+// Error 是读写请求失败时返回的错误，Err 为服务端返回的 gRPC 错误，
+// 可以用 status.Code 取得错误码；键不存在时 Err 为 ErrNotFound
+type Error struct {
+	Op   string // put、get 或 delete
+	Key  string
+	Node string // 最后一次请求的节点地址，连接失败时可能为空
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Node == "" {
+		return fmt.Sprintf("%s %q: %v", e.Op, e.Key, e.Err)
+	}
+	return fmt.Sprintf("%s %q on %s: %v", e.Op, e.Key, e.Node, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// GRPCStatus 返回服务端的 gRPC 状态，使 status.Code 对 *Error 同样有效
+func (e *Error) GRPCStatus() *status.Status {
+	if errors.Is(e.Err, ErrNotFound) {
+		return status.New(codes.NotFound, e.Err.Error())
+	}
+	return status.Convert(e.Err)
+}
+
+// requestError 将请求的错误包装为 *Error，服务端返回 NotFound 时转换为 ErrNotFound
+func requestError(op string, key string, node string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if status.Code(err) == codes.NotFound {
+		err = ErrNotFound
+	}
+	return &Error{Op: op, Key: key, Node: node, Err: err}
+}
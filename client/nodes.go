@@ -0,0 +1,140 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/sidneychang/no-db/consistenthash"
+	pb "github.com/sidneychang/no-db/proto"
+)
+
+// Migration 是增删节点时迁移数据的结果
+type Migration struct {
+	Moved  int // 已写入新节点并从原节点删除的键数
+	Failed int // 迁移失败、仍保留在原节点上的键数
+}
+
+// AddNode 添加新节点到哈希环，并把新节点接管的键从原所属节点迁移过来
+func (c *Client) AddNode(ctx context.Context, address string) (Migration, error) {
+	return c.AddNodeWithWeight(ctx, address, 1)
+}
+
+// AddNodeWithWeight 按权重添加新节点，节点分到的键与权重成正比；
+// 集群拓扑由协调节点维护时返回 ErrClusterManaged，此时应以 -coordinator 和 -weight 启动服务端
+func (c *Client) AddNodeWithWeight(ctx context.Context, address string, weight int) (Migration, error) {
+	if c.ClusterManaged() {
+		return Migration{}, ErrClusterManaged
+	}
+	ring := c.ring()
+	old := ring.Points()
+	ring.AddNodeWithWeight(address, weight)
+	return c.migrate(ctx, ring, consistenthash.PlanMigration(old, ring.Points()))
+}
+
+// RemoveNode 从哈希环中删除节点，不迁移数据；集群拓扑由协调节点维护时通过协调节点下线分片
+func (c *Client) RemoveNode(ctx context.Context, address string) error {
+	if c.ClusterManaged() {
+		return c.Decommission(ctx, address)
+	}
+	c.ring().RemoveNode(address)
+	c.removeConn(address)
+	return nil
+}
+
+// DeleteNode 将节点移出哈希环，并把它的数据迁移到新的所属节点；
+// 只有成功写入新节点的键才会从原节点删除，迁移失败的键仍保留在原节点上。
+// 集群拓扑由协调节点维护时通过协调节点下线分片，数据由新的所属分片取走
+func (c *Client) DeleteNode(ctx context.Context, node string) (Migration, error) {
+	if c.ClusterManaged() {
+		return Migration{}, c.Decommission(ctx, node)
+	}
+	ring := c.ring()
+	old := ring.Points()
+	ring.RemoveNode(node)
+	return c.migrate(ctx, ring, consistenthash.PlanMigration(old, ring.Points()))
+}
+
+// ListAllData 返回节点上当前命名空间中的所有键值对
+func (c *Client) ListAllData(ctx context.Context, node string) ([]string, []string, error) {
+	client, err := c.conn(node)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.opts.timeout)
+	defer cancel()
+	resp, err := client.ListAllData(ctx, &pb.ListAllDataRequest{Namespace: c.opts.namespace})
+	if err != nil {
+		return nil, nil, fmt.Errorf("list data on %s: %w", node, err)
+	}
+	return resp.Keys, resp.Values, nil
+}
+
+// migrate 执行迁移计划：从原节点读取落在迁移区间中的键，写入新节点成功后才从原节点删除；
+// 无法读取的原节点上的键不计入结果，其错误合并后返回
+func (c *Client) migrate(ctx context.Context, ring consistenthash.Ring, moves []consistenthash.Move) (Migration, error) {
+	var result Migration
+	var errs []error
+	byNode := make(map[string][]consistenthash.Move)
+	for _, move := range moves {
+		byNode[move.From] = append(byNode[move.From], move)
+	}
+	for from, nodeMoves := range byNode {
+		fromAddr := c.resolvePrimary(from)
+		keys, values, err := c.ListAllData(ctx, fromAddr)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		fromClient, err := c.conn(fromAddr)
+		if err != nil {
+			result.Failed += len(keys)
+			continue
+		}
+		for i, key := range keys {
+			hash := ring.Hash(key)
+			for _, move := range nodeMoves {
+				if !move.Range.Contains(hash) {
+					continue
+				}
+				if err := c.moveKey(ctx, fromClient, move.To, key, values[i]); err != nil {
+					result.Failed++
+				} else {
+					result.Moved++
+				}
+				break
+			}
+		}
+	}
+	return result, errors.Join(errs...)
+}
+
+// moveKey 将键写入新节点，成功后从原节点删除
+func (c *Client) moveKey(ctx context.Context, from pb.KVDBClient, to string, key string, value string) error {
+	toClient, err := c.conn(c.resolvePrimary(to))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.opts.timeout)
+	defer cancel()
+	if _, err := toClient.Put(ctx, &pb.PutRequest{Key: key, Value: value, Namespace: c.opts.namespace}); err != nil {
+		return err
+	}
+	_, err = from.Delete(ctx, &pb.DeleteRequest{Key: key, Namespace: c.opts.namespace})
+	return err
+}
+
+// Decommission 通过协调节点将分片移出哈希环，分片的键由新的所属分片取走；
+// 分片的服务端在日志中报告所有键都已移交后才能停止
+func (c *Client) Decommission(ctx context.Context, shard string) error {
+	if c.coordinator == nil {
+		return ErrNoCoordinator
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.opts.timeout)
+	defer cancel()
+	if _, err := c.coordinator.Decommission(ctx, &pb.DecommissionRequest{Shard: shard}); err != nil {
+		return fmt.Errorf("failed to decommission shard %s: %w", shard, err)
+	}
+	c.RefreshTopology(ctx)
+	return nil
+}
@@ -0,0 +1,173 @@
+package client
+
+import (
+	"time"
+
+	"github.com/sidneychang/no-db/tlsutil"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// RingType 是静态哈希环的实现，集群拓扑由协调节点维护时不使用
+type RingType int
+
+const (
+	// RbTreeRing 使用 consistenthash.RbHashRing，增删节点的开销较小
+	RbTreeRing RingType = iota
+	// SortedRing 使用 consistenthash.HashRing，虚拟节点保存在有序数组中
+	SortedRing
+)
+
+const (
+	// DefaultTimeout 是每次请求的默认超时时间
+	DefaultTimeout = time.Second
+	// DefaultHealthCheckInterval 是默认的连接健康检查间隔
+	DefaultHealthCheckInterval = 5 * time.Second
+	// DefaultVirtualNodes 是静态哈希环中每个节点默认的虚拟节点数，使用相同节点列表的客户端需要一致
+	DefaultVirtualNodes = 3
+)
+
+type options struct {
+	timeout        time.Duration
+	retry          RetryPolicy
+	hedgeDelay     time.Duration
+	replicaReads   bool
+	breaker        CircuitBreaker
+	healthInterval time.Duration
+	ringType       RingType
+	ringHash       string
+	virtualNodes   int
+	creds          credentials.TransportCredentials
+	token          string
+	namespace      string
+	coordinator    string
+}
+
+func defaultOptions() options {
+	return options{
+		timeout:        DefaultTimeout,
+		retry:          DefaultRetryPolicy,
+		replicaReads:   true,
+		breaker:        DefaultCircuitBreaker,
+		healthInterval: DefaultHealthCheckInterval,
+		ringType:       RbTreeRing,
+		virtualNodes:   DefaultVirtualNodes,
+		creds:          insecure.NewCredentials(),
+	}
+}
+
+// Option 配置 New 创建的客户端
+type Option func(*options)
+
+// WithTimeout 设置每次请求的超时时间，ctx 的截止时间更早时以 ctx 为准
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// WithRetries 设置请求失败后重试的次数，0 表示只请求一次，退避时间使用 DefaultRetryPolicy
+func WithRetries(retries int) Option {
+	return func(o *options) {
+		o.retry.Retries = max(retries, 0)
+	}
+}
+
+// WithRetryPolicy 设置重试的次数和退避时间
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(o *options) {
+		o.retry = policy
+	}
+}
+
+// WithHedgedReads 在读请求 delay 之后仍未返回时同时请求保存该键的下一个副本，使用最先得到的结果，
+// 以额外的请求降低尾延迟；delay 一般设为读请求延迟的 P95，0 表示不使用对冲读
+func WithHedgedReads(delay time.Duration) Option {
+	return func(o *options) {
+		o.hedgeDelay = delay
+	}
+}
+
+// WithReplicaReads 设置主节点不可用时读请求是否改用从主节点复制数据的副本，默认开启；
+// 副本可能尚未复制到最新的写入，需要读到自己的写入时关闭
+func WithReplicaReads(enabled bool) Option {
+	return func(o *options) {
+		o.replicaReads = enabled
+	}
+}
+
+// CircuitBreaker 配置每个节点的熔断：节点连续 Threshold 个请求不可用或超时后熔断，
+// Cooldown 内发往它的请求直接返回 ErrCircuitOpen，之后放行一个探测请求，成功时恢复，失败时继续熔断
+type CircuitBreaker struct {
+	Threshold int // 为 0 时不熔断
+	Cooldown  time.Duration
+}
+
+// DefaultCircuitBreaker 在连续 5 次失败后熔断 2 秒
+var DefaultCircuitBreaker = CircuitBreaker{Threshold: 5, Cooldown: 2 * time.Second}
+
+// WithCircuitBreaker 设置每个节点的熔断
+func WithCircuitBreaker(breaker CircuitBreaker) Option {
+	return func(o *options) {
+		o.breaker = breaker
+	}
+}
+
+// WithHealthCheck 设置连接池健康检查的间隔：检查通过时恢复被熔断的节点，失败时让断开的连接立即重连，
+// 连续失败多次后关闭连接，下次请求时重新建立；0 表示不检查
+func WithHealthCheck(interval time.Duration) Option {
+	return func(o *options) {
+		o.healthInterval = interval
+	}
+}
+
+// WithRing 设置静态哈希环的实现
+func WithRing(ringType RingType) Option {
+	return func(o *options) {
+		o.ringType = ringType
+	}
+}
+
+// WithRingHash 设置静态哈希环的哈希函数：crc32、fnv、murmur3 或 xxhash，所有客户端需要一致；
+// 集群拓扑由协调节点维护时使用拓扑中的哈希函数
+func WithRingHash(name string) Option {
+	return func(o *options) {
+		o.ringHash = name
+	}
+}
+
+// WithVirtualNodes 设置静态哈希环中每个节点的虚拟节点数
+func WithVirtualNodes(n int) Option {
+	return func(o *options) {
+		o.virtualNodes = n
+	}
+}
+
+// WithTLS 使用 TLS 连接服务端，服务端要求时出示客户端证书；之后建立的连接使用最新加载的证书
+func WithTLS(reloader *tlsutil.Reloader, serverName string) Option {
+	return func(o *options) {
+		o.creds = reloader.ClientCredentials(serverName)
+	}
+}
+
+// WithToken 在每个请求中携带 token，用于服务端的认证和按键前缀的权限检查；
+// token 只通过 TLS 连接发送，需要同时使用 WithTLS
+func WithToken(token string) Option {
+	return func(o *options) {
+		o.token = token
+	}
+}
+
+// WithNamespace 在指定的命名空间中读写，为空时使用默认命名空间
+func WithNamespace(namespace string) Option {
+	return func(o *options) {
+		o.namespace = namespace
+	}
+}
+
+// WithCoordinator 使用协调节点获取集群拓扑和查找分片的主节点，此时哈希环中的节点为分片名
+func WithCoordinator(address string) Option {
+	return func(o *options) {
+		o.coordinator = address
+	}
+}
@@ -0,0 +1,264 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	pb "github.com/sidneychang/no-db/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// 连续这么多次健康检查失败后关闭节点的连接并重新建立
+const evictAfterChecks = 3
+
+// breakerState 是节点的熔断状态
+type breakerState int
+
+const (
+	breakerClosed   breakerState = iota // 正常请求
+	breakerOpen                         // 连续失败过多，请求直接返回 ErrCircuitOpen
+	breakerHalfOpen                     // 冷却结束，放行一个探测请求
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// poolNode 是连接池中的一个节点，连接被移除后熔断状态仍然保留
+type poolNode struct {
+	conn          *grpc.ClientConn // 为 nil 时下次请求建立连接
+	healthy       bool             // 最近一次健康检查是否通过
+	failures      int              // 连续失败的请求数
+	checkFailures int              // 连续失败的健康检查数
+	breaker       breakerState
+	openedAt      time.Time // 熔断或放行探测请求的时间
+}
+
+// PoolStats 是连接池的统计信息
+type PoolStats struct {
+	Conns               int    // 连接池中的连接数
+	Dials               uint64 // 建立的连接数
+	Evictions           uint64 // 因健康检查失败或连接关闭而移除的连接数
+	HealthChecks        uint64 // 健康检查次数
+	HealthCheckFailures uint64 // 失败的健康检查次数
+	BreakerTrips        uint64 // 节点被熔断的次数
+	Rejected            uint64 // 熔断期间直接返回 ErrCircuitOpen 的请求数
+	Nodes               map[string]NodeStats
+}
+
+// NodeStats 是连接池中一个节点的状态
+type NodeStats struct {
+	State    string // gRPC 连接状态，连接已被移除时为空
+	Healthy  bool   // 最近一次健康检查是否通过
+	Breaker  string // 熔断状态：closed、open 或 half-open
+	Failures int    // 连续失败的请求数
+}
+
+// PoolStats 返回连接池的统计信息
+func (c *Client) PoolStats() PoolStats {
+	c.poolMu.Lock()
+	defer c.poolMu.Unlock()
+	stats := c.stats
+	stats.Nodes = make(map[string]NodeStats, len(c.nodes))
+	for addr, n := range c.nodes {
+		node := NodeStats{Healthy: n.healthy, Breaker: n.breaker.String(), Failures: n.failures}
+		if n.conn != nil {
+			node.State = n.conn.GetState().String()
+			stats.Conns++
+		}
+		stats.Nodes[addr] = node
+	}
+	return stats
+}
+
+// conn 返回节点的连接，没有连接或连接已关闭时建立新连接；节点被熔断时返回 ErrCircuitOpen
+func (c *Client) conn(node string) (pb.KVDBClient, error) {
+	c.poolMu.Lock()
+	if c.nodes == nil {
+		c.poolMu.Unlock()
+		return nil, ErrClosed
+	}
+	n, ok := c.nodes[node]
+	if !ok {
+		n = &poolNode{healthy: true}
+		c.nodes[node] = n
+	}
+	if !c.allow(n) {
+		c.stats.Rejected++
+		c.poolMu.Unlock()
+		return nil, ErrCircuitOpen
+	}
+	if n.conn != nil && n.conn.GetState() == connectivity.Shutdown {
+		n.conn = nil
+		c.stats.Evictions++
+	}
+	if conn := n.conn; conn != nil {
+		c.poolMu.Unlock()
+		return pb.NewKVDBClient(conn), nil
+	}
+	c.poolMu.Unlock()
+
+	// 在锁外建立连接，不阻塞其他节点的请求和结果记录
+	conn, err := grpc.Dial(node, c.dialOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to server: %v", err)
+	}
+	c.poolMu.Lock()
+	defer c.poolMu.Unlock()
+	if conn = c.adopt(node, n, conn); conn == nil {
+		if c.nodes == nil {
+			return nil, ErrClosed
+		}
+		return nil, fmt.Errorf("node %s was removed from the pool", node)
+	}
+	return pb.NewKVDBClient(conn), nil
+}
+
+// adopt 将在锁外建立的连接放入连接池并返回节点使用的连接：
+// 节点已经有可用的连接时关闭新连接，节点已被移除或连接池已关闭时关闭新连接并返回 nil
+// hold c.poolMu before accessing this method
+func (c *Client) adopt(node string, n *poolNode, conn *grpc.ClientConn) *grpc.ClientConn {
+	if c.nodes == nil || c.nodes[node] != n {
+		conn.Close()
+		return nil
+	}
+	if n.conn != nil && n.conn.GetState() != connectivity.Shutdown {
+		conn.Close()
+		return n.conn
+	}
+	n.conn = conn
+	c.stats.Dials++
+	return conn
+}
+
+// allow 返回是否向节点发送请求，熔断的冷却时间结束后每个冷却时间放行一个探测请求
+// hold c.poolMu before accessing this method
+func (c *Client) allow(n *poolNode) bool {
+	if n.breaker == breakerClosed {
+		return true
+	}
+	if time.Since(n.openedAt) < c.opts.breaker.Cooldown {
+		return false
+	}
+	n.breaker, n.openedAt = breakerHalfOpen, time.Now()
+	return true
+}
+
+// record 记录请求的结果：节点不可用时累计失败次数，达到阈值或探测请求失败时熔断，
+// 得到服务端的响应（包括错误）时恢复
+func (c *Client) record(node string, err error) {
+	if status.Code(err) == codes.Canceled {
+		// 对冲读取消的请求不说明节点的状态
+		return
+	}
+	c.poolMu.Lock()
+	defer c.poolMu.Unlock()
+	n, ok := c.nodes[node]
+	if !ok {
+		return
+	}
+	if !unavailable(err) {
+		n.failures, n.breaker = 0, breakerClosed
+		return
+	}
+	n.failures++
+	threshold := c.opts.breaker.Threshold
+	if threshold > 0 && (n.breaker == breakerHalfOpen || (n.breaker == breakerClosed && n.failures >= threshold)) {
+		n.breaker, n.openedAt = breakerOpen, time.Now()
+		c.stats.BreakerTrips++
+	}
+}
+
+// removeConn 关闭并从连接池中删除节点
+func (c *Client) removeConn(node string) {
+	c.poolMu.Lock()
+	defer c.poolMu.Unlock()
+	if n, exists := c.nodes[node]; exists {
+		if n.conn != nil {
+			n.conn.Close()
+		}
+		delete(c.nodes, node)
+	}
+}
+
+// checkHealth 定期检查连接池中的连接，直到客户端关闭
+func (c *Client) checkHealth(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.checkNodes()
+		}
+	}
+}
+
+// checkNodes 使用 gRPC 健康检查协议检查每个连接：检查通过时恢复被熔断的节点；
+// 失败时让断开的连接立即重连，连续失败 evictAfterChecks 次后换成新建立的连接，
+// 之后继续检查新连接，节点恢复后即可解除熔断
+func (c *Client) checkNodes() {
+	c.poolMu.Lock()
+	conns := make(map[string]*grpc.ClientConn, len(c.nodes))
+	for addr, n := range c.nodes {
+		if n.conn != nil {
+			conns[addr] = n.conn
+		}
+	}
+	c.poolMu.Unlock()
+
+	for addr, conn := range conns {
+		ctx, cancel := context.WithTimeout(context.Background(), c.opts.timeout)
+		resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+		cancel()
+		// 没有注册健康检查服务的服务端只要能响应就认为是健康的
+		healthy := (err == nil && resp.Status == healthpb.HealthCheckResponse_SERVING) || status.Code(err) == codes.Unimplemented
+
+		c.poolMu.Lock()
+		n, ok := c.nodes[addr]
+		if !ok || n.conn != conn {
+			c.poolMu.Unlock()
+			continue
+		}
+		c.stats.HealthChecks++
+		n.healthy = healthy
+		if healthy {
+			n.checkFailures, n.failures, n.breaker = 0, 0, breakerClosed
+			c.poolMu.Unlock()
+			continue
+		}
+		c.stats.HealthCheckFailures++
+		n.checkFailures++
+		if n.checkFailures >= evictAfterChecks {
+			n.conn.Close()
+			n.conn, n.checkFailures = nil, 0
+			c.stats.Evictions++
+			c.poolMu.Unlock()
+			// 在锁外重新建立连接，再放回连接池，重连期间其他请求不会被阻塞
+			if conn, err := grpc.Dial(addr, c.dialOptions()...); err == nil {
+				c.poolMu.Lock()
+				c.adopt(addr, n, conn)
+				c.poolMu.Unlock()
+			}
+			continue
+		}
+		if conn.GetState() == connectivity.TransientFailure {
+			// 节点重启后不必等到 gRPC 的重连退避结束
+			conn.ResetConnectBackoff()
+		}
+		c.poolMu.Unlock()
+	}
+}
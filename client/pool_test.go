@@ -0,0 +1,154 @@
+package client
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/sidneychang/no-db/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestClient_CircuitBreaker(t *testing.T) {
+	addr, kv := startKVServer(t)
+	client, err := New([]string{addr}, WithRetries(0), WithHealthCheck(0),
+		WithCircuitBreaker(CircuitBreaker{Threshold: 2, Cooldown: 50 * time.Millisecond}))
+	assert.Nil(t, err)
+	defer client.Close()
+	ctx := context.Background()
+
+	kv.update(func() { kv.failures = 100 })
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, codes.Unavailable, status.Code(client.Put(ctx, "key", "value")))
+	}
+	// the node is no longer called while the breaker is open
+	err = client.Put(ctx, "key", "value")
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, 2, kv.callCount())
+	stats := client.PoolStats()
+	assert.Equal(t, uint64(1), stats.BreakerTrips)
+	assert.Equal(t, uint64(1), stats.Rejected)
+	assert.Equal(t, "open", stats.Nodes[addr].Breaker)
+
+	// after the cooldown a failing probe opens the breaker again
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, codes.Unavailable, status.Code(client.Put(ctx, "key", "value")))
+	assert.ErrorIs(t, client.Put(ctx, "key", "value"), ErrCircuitOpen)
+	assert.Equal(t, 3, kv.callCount())
+
+	// and a successful probe closes it
+	kv.update(func() { kv.failures = 0 })
+	time.Sleep(60 * time.Millisecond)
+	assert.Nil(t, client.Put(ctx, "key", "value"))
+	assert.Nil(t, client.Put(ctx, "key", "value"))
+	assert.Equal(t, "closed", client.PoolStats().Nodes[addr].Breaker)
+	assert.Equal(t, uint64(2), client.PoolStats().BreakerTrips)
+}
+
+func TestClient_HealthCheck(t *testing.T) {
+	addr, kv := startKVServer(t)
+	client, err := New([]string{addr}, WithRetries(0), WithHealthCheck(10*time.Millisecond),
+		WithCircuitBreaker(CircuitBreaker{Threshold: 1, Cooldown: time.Hour}))
+	assert.Nil(t, err)
+	defer client.Close()
+	ctx := context.Background()
+
+	// a passing health check closes the breaker long before the cooldown ends
+	kv.update(func() { kv.failures = 1 })
+	assert.NotNil(t, client.Put(ctx, "key", "value"))
+	assert.ErrorIs(t, client.Put(ctx, "key", "value"), ErrCircuitOpen)
+	assert.Eventually(t, func() bool {
+		return client.PoolStats().Nodes[addr].Breaker == "closed"
+	}, time.Second, 5*time.Millisecond)
+	assert.Nil(t, client.Put(ctx, "key", "value"))
+
+	// the connection of a node failing its health checks is replaced by a new one
+	kv.health.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	// the new connection is dialed after the eviction is counted
+	assert.Eventually(t, func() bool {
+		stats := client.PoolStats()
+		return stats.Evictions > 0 && stats.Conns == 1
+	}, time.Second, 5*time.Millisecond)
+	stats := client.PoolStats()
+	assert.GreaterOrEqual(t, stats.Dials, uint64(2))
+	assert.GreaterOrEqual(t, stats.HealthCheckFailures, uint64(evictAfterChecks))
+	assert.Equal(t, 1, stats.Conns)
+	assert.False(t, stats.Nodes[addr].Healthy)
+
+	kv.health.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	assert.Eventually(t, func() bool {
+		return client.PoolStats().Nodes[addr].Healthy
+	}, time.Second, 5*time.Millisecond)
+	assert.Nil(t, client.Put(ctx, "key", "value"))
+}
+
+func TestClient_NodeRestart(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	addr := listener.Addr().String()
+	serve := func(listener net.Listener) *grpc.Server {
+		grpcServer := grpc.NewServer()
+		pb.RegisterKVDBServer(grpcServer, &kvServer{data: map[string]string{"/key": "value"}})
+		go grpcServer.Serve(listener)
+		return grpcServer
+	}
+	grpcServer := serve(listener)
+	client, err := New([]string{addr}, WithHealthCheck(20*time.Millisecond))
+	assert.Nil(t, err)
+	defer client.Close()
+	ctx := context.Background()
+	_, err = client.Get(ctx, "key")
+	assert.Nil(t, err)
+
+	// while the node is down the connection backs off from reconnecting
+	grpcServer.Stop()
+	for i := 0; i < 3; i++ {
+		_, err = client.Get(ctx, "key")
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	listener, err = net.Listen("tcp", addr)
+	assert.Nil(t, err)
+	grpcServer = serve(listener)
+	defer grpcServer.Stop()
+	// the health check resets the backoff, so the restarted node is used again quickly
+	assert.Eventually(t, func() bool {
+		_, err := client.Get(ctx, "key")
+		return err == nil
+	}, 500*time.Millisecond, 20*time.Millisecond)
+}
+
+func TestClient_ConcurrentDials(t *testing.T) {
+	addr, _ := startKVServer(t)
+	client, err := New([]string{addr}, WithHealthCheck(0))
+	assert.Nil(t, err)
+	defer client.Close()
+	before := client.PoolStats()
+
+	// connections are dialed outside the pool lock, the pool keeps one of them
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.conn("127.0.0.1:1")
+			assert.Nil(t, err)
+		}()
+	}
+	wg.Wait()
+	stats := client.PoolStats()
+	assert.Equal(t, before.Dials+1, stats.Dials)
+	assert.Equal(t, before.Conns+1, stats.Conns)
+
+	assert.Nil(t, client.Close())
+	_, err = client.conn("127.0.0.1:2")
+	assert.ErrorIs(t, err, ErrClosed)
+}
@@ -0,0 +1,149 @@
+package client
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	pb "github.com/sidneychang/no-db/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RetryPolicy 决定请求失败后的重试：跟随重定向时立即重试，节点不可用、请求超时或主节点切换时
+// 按指数退避等待后刷新路由再重试
+type RetryPolicy struct {
+	Retries        int           // 失败后最多重试的次数，0 表示只请求一次
+	InitialBackoff time.Duration // 第一次退避的时间
+	MaxBackoff     time.Duration // 退避时间的上限
+	Multiplier     float64       // 每次重试后退避时间的倍数，小于 1 时按 1 计算
+	Jitter         float64       // 退避时间随机浮动的比例，0.2 表示在 ±20% 之间浮动
+}
+
+// DefaultRetryPolicy 重试两次，退避 50ms、100ms，足以跨过一次主节点切换
+var DefaultRetryPolicy = RetryPolicy{
+	Retries:        2,
+	InitialBackoff: 50 * time.Millisecond,
+	MaxBackoff:     time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+// backoff 返回第 retry 次重试（从 0 开始）前等待的时间
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	d := float64(p.InitialBackoff)
+	for i := 0; i < retry && (p.MaxBackoff <= 0 || d < float64(p.MaxBackoff)); i++ {
+		d *= max(p.Multiplier, 1)
+	}
+	if p.MaxBackoff > 0 {
+		d = min(d, float64(p.MaxBackoff))
+	}
+	if p.Jitter > 0 {
+		d *= 1 + p.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(d)
+}
+
+// retryable 返回请求失败后是否值得刷新路由重试
+func retryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.FailedPrecondition:
+		return true
+	default:
+		return false
+	}
+}
+
+// unavailable 返回节点是否不可用，此时可以改用其他副本
+func unavailable(err error) bool {
+	code := status.Code(err)
+	return code == codes.Unavailable || code == codes.DeadlineExceeded
+}
+
+// sleep 等待 d，ctx 先结束时返回 false
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// rpc 向一个节点发送请求并返回响应
+type rpc func(ctx context.Context, client pb.KVDBClient) (interface{}, error)
+
+// result 是一个节点对请求的响应
+type result struct {
+	node  string
+	reply interface{}
+	err   error
+}
+
+// attempt 向 node 发送请求，节点不可用时依次改用 fallbacks 中的节点。hedge 大于 0 时，
+// 请求在 hedge 之后仍未返回就同时向下一个节点发送，返回最先得到的响应，只用于读请求
+func (c *Client) attempt(ctx context.Context, node string, fallbacks []string, hedge time.Duration, call rpc) result {
+	if hedge <= 0 {
+		r := c.call(ctx, node, call)
+		for _, fallback := range fallbacks {
+			if !unavailable(r.err) {
+				break
+			}
+			r = c.call(ctx, fallback, call)
+		}
+		return r
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	targets := append([]string{node}, fallbacks...)
+	results := make(chan result, len(targets))
+	next, pending := 0, 0
+	launch := func() {
+		go func(node string) {
+			results <- c.call(ctx, node, call)
+		}(targets[next])
+		next++
+		pending++
+	}
+	launch()
+	timer := time.NewTimer(hedge)
+	defer timer.Stop()
+	var last result
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if !unavailable(r.err) {
+				return r
+			}
+			last = r
+			// 失败的节点不再等待对冲延迟，直接请求下一个节点
+			if next < len(targets) {
+				launch()
+				timer.Reset(hedge)
+			}
+		case <-timer.C:
+			if next < len(targets) {
+				launch()
+				timer.Reset(hedge)
+			}
+		}
+	}
+	return last
+}
+
+// call 在单次请求的超时时间内向节点发送请求
+func (c *Client) call(ctx context.Context, node string, call rpc) result {
+	client, err := c.conn(node)
+	if err != nil {
+		return result{node: node, err: err}
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.opts.timeout)
+	defer cancel()
+	reply, err := call(ctx, client)
+	c.record(node, err)
+	return result{node: node, reply: reply, err: err}
+}
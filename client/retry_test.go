@@ -0,0 +1,151 @@
+package client
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	pb "github.com/sidneychang/no-db/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond, Multiplier: 2}
+	assert.Equal(t, 10*time.Millisecond, policy.backoff(0))
+	assert.Equal(t, 20*time.Millisecond, policy.backoff(1))
+	assert.Equal(t, 40*time.Millisecond, policy.backoff(2))
+	assert.Equal(t, 50*time.Millisecond, policy.backoff(3))
+	assert.Equal(t, 50*time.Millisecond, policy.backoff(100))
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		backoff := policy.backoff(1)
+		assert.GreaterOrEqual(t, backoff, 10*time.Millisecond)
+		assert.LessOrEqual(t, backoff, 30*time.Millisecond)
+	}
+}
+
+func TestClient_Retries(t *testing.T) {
+	addr, kv := startKVServer(t)
+	policy := RetryPolicy{Retries: 2, InitialBackoff: 10 * time.Millisecond, Multiplier: 2}
+	client, err := New([]string{addr}, WithRetryPolicy(policy))
+	assert.Nil(t, err)
+	defer client.Close()
+	ctx := context.Background()
+
+	// a restarting node is retried after backing off
+	kv.update(func() { kv.failures = 2 })
+	start := time.Now()
+	assert.Nil(t, client.Put(ctx, "key", "value"))
+	assert.GreaterOrEqual(t, time.Since(start), 30*time.Millisecond)
+	assert.Equal(t, 3, kv.callCount())
+
+	kv.update(func() { kv.failures, kv.calls = 3, 0 })
+	_, err = client.Get(ctx, "key")
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, 3, kv.callCount())
+
+	// errors that a retry cannot fix are returned at once
+	kv.update(func() { kv.calls = 0 })
+	_, err = client.Get(ctx, "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, 1, kv.callCount())
+
+	// the context bounds the retries
+	kv.update(func() { kv.failures, kv.calls = 100, 0 })
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Millisecond)
+	defer cancel()
+	_, err = client.Get(ctx, "key")
+	assert.NotNil(t, err)
+	assert.Less(t, kv.calls, 3)
+}
+
+// startShard serves a topology with shard a: its primary is unreachable or a
+// kvServer, and its single replica is a kvServer holding key
+func startShard(t *testing.T, primary string) (seed string, follower *kvServer) {
+	followerAddr, follower := startKVServer(t)
+	follower.data["/key"] = "replica value"
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	grpcServer := grpc.NewServer()
+	pb.RegisterKVDBServer(grpcServer, &topologyServer{info: &pb.ClusterInfoResponse{
+		Version:      1,
+		VirtualNodes: 10,
+		Shards:       []*pb.Shard{{Name: "a", Primary: primary, Replicas: []string{followerAddr}}},
+	}})
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+	return listener.Addr().String(), follower
+}
+
+// deadAddress returns an address nothing listens on
+func deadAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	listener.Close()
+	return listener.Addr().String()
+}
+
+func TestClient_ReplicaReads(t *testing.T) {
+	seed, _ := startShard(t, deadAddress(t))
+	ctx := context.Background()
+	client, err := New([]string{seed}, WithRetries(0))
+	assert.Nil(t, err)
+	defer client.Close()
+	assert.Nil(t, client.RefreshTopology(ctx))
+
+	// reads fall back to the replica of the shard, writes need the primary
+	value, err := client.Get(ctx, "key")
+	assert.Nil(t, err)
+	assert.Equal(t, "replica value", value)
+	assert.Equal(t, codes.Unavailable, status.Code(client.Put(ctx, "key", "value")))
+
+	strict, err := New([]string{seed}, WithRetries(0), WithReplicaReads(false))
+	assert.Nil(t, err)
+	defer strict.Close()
+	assert.Nil(t, strict.RefreshTopology(ctx))
+	_, err = strict.Get(ctx, "key")
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestClient_HedgedReads(t *testing.T) {
+	primaryAddr, primary := startKVServer(t)
+	primary.data["/key"] = "primary value"
+	primary.update(func() { primary.delay = 300 * time.Millisecond })
+	seed, follower := startShard(t, primaryAddr)
+	ctx := context.Background()
+
+	hedged, err := New([]string{seed}, WithHedgedReads(20*time.Millisecond))
+	assert.Nil(t, err)
+	defer hedged.Close()
+	assert.Nil(t, hedged.RefreshTopology(ctx))
+	start := time.Now()
+	value, err := hedged.Get(ctx, "key")
+	assert.Nil(t, err)
+	assert.Equal(t, "replica value", value)
+	assert.Less(t, time.Since(start), 200*time.Millisecond)
+	assert.Equal(t, 1, follower.callCount())
+
+	// a fast primary answers before the hedge is sent
+	primary.update(func() { primary.delay = 0 })
+	value, err = hedged.Get(ctx, "key")
+	assert.Nil(t, err)
+	assert.Equal(t, "primary value", value)
+	assert.Equal(t, 1, follower.callCount())
+
+	// without hedging the read waits for the primary
+	primary.update(func() { primary.delay = 300 * time.Millisecond })
+	client, err := New([]string{seed})
+	assert.Nil(t, err)
+	defer client.Close()
+	assert.Nil(t, client.RefreshTopology(ctx))
+	start = time.Now()
+	value, err = client.Get(ctx, "key")
+	assert.Nil(t, err)
+	assert.Equal(t, "primary value", value)
+	assert.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond)
+}
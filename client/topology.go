@@ -0,0 +1,224 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sidneychang/no-db/consistenthash"
+	pb "github.com/sidneychang/no-db/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ring 返回当前的哈希环
+func (c *Client) ring() consistenthash.Ring {
+	c.ringMu.RLock()
+	defer c.ringMu.RUnlock()
+	return c.hashRing
+}
+
+// ClusterManaged 返回哈希环是否由集群拓扑维护
+func (c *Client) ClusterManaged() bool {
+	c.ringMu.RLock()
+	defer c.ringMu.RUnlock()
+	return c.topologyVersion != 0
+}
+
+// RefreshTopology 从协调节点或种子节点获取集群拓扑，拓扑发生变化时按分片重建哈希环，
+// 并更新各分片的主节点
+func (c *Client) RefreshTopology(ctx context.Context) error {
+	info, err := c.fetchClusterInfo(ctx)
+	if err != nil {
+		return err
+	}
+	if len(info.Shards) == 0 {
+		return fmt.Errorf("cluster has no members")
+	}
+	// 集群拓扑中的分片已经由服务端完成数据迁移，这里只需要路由
+	hashFunc, err := consistenthash.ParseHashFunc(info.Hash)
+	if err != nil {
+		return err
+	}
+
+	c.ringMu.Lock()
+	defer c.ringMu.Unlock()
+	if info.Version == c.topologyVersion {
+		return nil
+	}
+	ring := consistenthash.NewHashRingWithHash(nil, int(info.VirtualNodes), hashFunc)
+	primaries := make(map[string]string)
+	followers := make(map[string][]string)
+	seeds := append([]string(nil), c.seeds...)
+	for _, shard := range info.Shards {
+		// 已下线的分片不在哈希环上
+		if !shard.Leaving {
+			ring.AddNodeWithWeight(shard.Name, int(shard.Weight))
+		}
+		ring.SetZone(shard.Name, shard.Zone)
+		if shard.Primary != "" {
+			primaries[shard.Name] = shard.Primary
+			seeds = appendMissing(seeds, shard.Primary)
+		}
+		for _, replica := range shard.Replicas {
+			seeds = appendMissing(seeds, replica)
+		}
+		followers[shard.Name] = shard.Replicas
+	}
+	c.hashRing = ring
+	c.replicas = int(info.ReplicationFactor)
+	c.topologyVersion = info.Version
+	c.seeds = seeds
+
+	c.primaryMu.Lock()
+	c.primaries = primaries
+	c.followers = followers
+	c.primaryMu.Unlock()
+	return nil
+}
+
+// fetchClusterInfo 获取集群拓扑，依次尝试协调节点和各个种子节点
+func (c *Client) fetchClusterInfo(ctx context.Context) (*pb.ClusterInfoResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.opts.timeout)
+	defer cancel()
+	if c.coordinator != nil {
+		if info, err := c.coordinator.ClusterInfo(ctx, &pb.ClusterInfoRequest{}); err == nil {
+			return info, nil
+		}
+	}
+	c.ringMu.RLock()
+	seeds := c.seeds
+	c.ringMu.RUnlock()
+	err := fmt.Errorf("no seed nodes")
+	for _, seed := range seeds {
+		var client pb.KVDBClient
+		if client, err = c.conn(seed); err != nil {
+			continue
+		}
+		var info *pb.ClusterInfoResponse
+		if info, err = client.ClusterInfo(ctx, &pb.ClusterInfoRequest{}); err == nil {
+			return info, nil
+		}
+	}
+	return nil, fmt.Errorf("failed to get cluster topology: %w", err)
+}
+
+// WatchTopology 定期刷新集群拓扑，直到 ctx 结束
+func (c *Client) WatchTopology(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.RefreshTopology(ctx)
+		}
+	}
+}
+
+func appendMissing(list []string, item string) []string {
+	for _, v := range list {
+		if v == item {
+			return list
+		}
+	}
+	return append(list, item)
+}
+
+// fallbacks 返回 node 不可用时依次改用的节点：保存该键的其他分片的主节点，
+// 读请求还包括这些分片中从主节点复制数据的副本，它们可能尚未复制到最新的写入
+func (c *Client) fallbacks(key string, node string, read bool) []string {
+	shards := c.replicaShards(key)
+	var nodes []string
+	for _, shard := range shards {
+		nodes = appendMissing(nodes, c.resolvePrimary(shard))
+	}
+	if read && c.opts.replicaReads {
+		c.primaryMu.RLock()
+		for _, shard := range append([]string{c.ring().Get(key)}, shards...) {
+			for _, follower := range c.followers[shard] {
+				nodes = appendMissing(nodes, follower)
+			}
+		}
+		c.primaryMu.RUnlock()
+	}
+	// node 可能是重定向的地址，它也可能保存着键的副本
+	for i, n := range nodes {
+		if n == node {
+			return append(nodes[:i:i], nodes[i+1:]...)
+		}
+	}
+	return nodes
+}
+
+// replicaShards 返回保存键的其他分片，每个键只保存在一个分片上时返回 nil
+func (c *Client) replicaShards(key string) []string {
+	c.ringMu.RLock()
+	defer c.ringMu.RUnlock()
+	if c.replicas <= 1 {
+		return nil
+	}
+	if shards := c.hashRing.GetN(key, c.replicas); len(shards) > 1 {
+		return shards[1:]
+	}
+	return nil
+}
+
+// redirectOf 返回错误中携带的重定向信息
+func redirectOf(err error) *pb.Redirect {
+	if status.Code(err) != codes.FailedPrecondition {
+		return nil
+	}
+	for _, detail := range status.Convert(err).Details() {
+		if redirect, ok := detail.(*pb.Redirect); ok {
+			return redirect
+		}
+	}
+	return nil
+}
+
+// refreshTopologyTo 本地拓扑版本与服务端不同时刷新集群拓扑
+func (c *Client) refreshTopologyTo(ctx context.Context, version uint64) {
+	c.ringMu.RLock()
+	current := c.topologyVersion
+	c.ringMu.RUnlock()
+	if version != current {
+		c.RefreshTopology(ctx)
+	}
+}
+
+// refreshRoute 在请求失败后刷新集群拓扑和分片的主节点，键的路由发生变化时返回 true
+func (c *Client) refreshRoute(ctx context.Context, key string) bool {
+	old := c.resolvePrimary(c.ring().Get(key))
+	if c.ClusterManaged() {
+		c.RefreshTopology(ctx)
+	}
+	if c.coordinator != nil {
+		c.refreshPrimary(ctx, c.ring().Get(key))
+	}
+	return c.resolvePrimary(c.ring().Get(key)) != old
+}
+
+// resolvePrimary 将哈希环上的分片名解析为当前主节点地址
+func (c *Client) resolvePrimary(shard string) string {
+	c.primaryMu.RLock()
+	defer c.primaryMu.RUnlock()
+	if addr, ok := c.primaries[shard]; ok {
+		return addr
+	}
+	return shard
+}
+
+// refreshPrimary 向协调节点查询分片的主节点
+func (c *Client) refreshPrimary(ctx context.Context, shard string) {
+	ctx, cancel := context.WithTimeout(ctx, c.opts.timeout)
+	defer cancel()
+	lease, err := c.coordinator.GetLease(ctx, &pb.GetLeaseRequest{Shard: shard})
+	if err != nil || lease.Holder == "" {
+		return
+	}
+	c.primaryMu.Lock()
+	c.primaries[shard] = lease.Holder
+	c.primaryMu.Unlock()
+}
@@ -0,0 +1,95 @@
+package client
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+
+	pb "github.com/sidneychang/no-db/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// topologyServer serves a fixed cluster topology
+type topologyServer struct {
+	pb.UnimplementedKVDBServer
+	mu   sync.Mutex
+	info *pb.ClusterInfoResponse
+}
+
+func (s *topologyServer) ClusterInfo(ctx context.Context, req *pb.ClusterInfoRequest) (*pb.ClusterInfoResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.info, nil
+}
+
+func TestClient_RefreshTopology(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	seed := listener.Addr().String()
+	topology := &topologyServer{info: &pb.ClusterInfoResponse{
+		Version:      1,
+		VirtualNodes: 100,
+		Shards: []*pb.Shard{
+			{Name: "a", Primary: "a1", Replicas: []string{"a2"}},
+			{Name: "b", Primary: "b1"},
+		},
+	}}
+	grpcServer := grpc.NewServer()
+	pb.RegisterKVDBServer(grpcServer, topology)
+	go grpcServer.Serve(listener)
+	defer grpcServer.Stop()
+
+	client, err := New([]string{seed})
+	assert.Nil(t, err)
+	defer client.Close()
+	assert.False(t, client.ClusterManaged())
+	assert.Nil(t, client.RefreshTopology(context.Background()))
+	assert.True(t, client.ClusterManaged())
+	assert.Equal(t, []string{seed, "a1", "a2", "b1"}, client.seeds)
+
+	owners := make(map[string]int)
+	for _, key := range []string{"k1", "k2", "k3", "k4", "k5", "k6", "k7", "k8"} {
+		owners[client.resolvePrimary(client.ring().Get(key))]++
+		assert.Nil(t, client.replicaShards(key))
+	}
+	assert.Equal(t, 2, len(owners))
+	assert.Equal(t, 8, owners["a1"]+owners["b1"])
+
+	// with two replicas the other shard holds every key too
+	topology.mu.Lock()
+	topology.info.Version, topology.info.ReplicationFactor = 2, 2
+	topology.mu.Unlock()
+	assert.Nil(t, client.RefreshTopology(context.Background()))
+	for _, key := range []string{"k1", "k2", "k3", "k4"} {
+		replicas := client.replicaShards(key)
+		assert.Equal(t, 1, len(replicas))
+		assert.NotEqual(t, client.ring().Get(key), replicas[0])
+	}
+
+	// a new version replaces the ring and the primaries
+	topology.mu.Lock()
+	topology.info = &pb.ClusterInfoResponse{
+		Version:      3,
+		VirtualNodes: 100,
+		Shards:       []*pb.Shard{{Name: "a", Primary: "a2"}},
+	}
+	topology.mu.Unlock()
+	assert.Nil(t, client.RefreshTopology(context.Background()))
+	assert.Equal(t, "a2", client.resolvePrimary(client.ring().Get("k1")))
+	assert.Equal(t, uint64(3), client.topologyVersion)
+}
+
+func TestRedirectOf(t *testing.T) {
+	st, err := status.New(codes.FailedPrecondition, "moved").WithDetails(&pb.Redirect{Shard: "a", Address: "a1", Version: 2})
+	assert.Nil(t, err)
+	redirect := redirectOf(st.Err())
+	assert.Equal(t, "a1", redirect.Address)
+	assert.Equal(t, uint64(2), redirect.Version)
+
+	assert.Nil(t, redirectOf(status.Error(codes.FailedPrecondition, "not the Primary server")))
+	assert.Nil(t, redirectOf(status.Error(codes.Unavailable, "down")))
+}
@@ -0,0 +1,21 @@
+package client
+
+import (
+	"errors"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer 使用全局的 TracerProvider 记录 span，程序没有设置时不记录
+var tracer = otel.Tracer("github.com/sidneychang/no-db/client")
+
+// endSpan 结束 span，请求失败时将 span 标记为失败，键不存在不算失败
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -3,43 +3,88 @@ package main
 import (
 	"bufio"
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/sidneychang/no-db/consistenthash"
-	pb "github.com/sidneychang/no-db/proto"
-	"google.golang.org/grpc"
+	"github.com/sidneychang/no-db/client"
+	"github.com/sidneychang/no-db/tlsutil"
+	"github.com/sidneychang/no-db/tracing"
 )
 
-// Client 结构体，用于封装一致性哈希和 gRPC 连接池
-type Client struct {
-	hashRing consistenthash.HashRingInterface // 一致性哈希环
-	connPool map[string]*grpc.ClientConn      // 连接池
-}
+func main() {
+	nodes := flag.String("nodes", "0.0.0.0:50051", "Comma-separated list of seed servers, the topology is fetched from them when the cluster has a coordinator")
+	topologyRefresh := flag.Duration("topologyRefresh", 10*time.Second, "How often the cluster topology is fetched again")
+	coordinator := flag.String("coordinator", "", "Coordinator address used to follow primary failover")
+	tlsCert := flag.String("tlsCert", "", "Client certificate file presented to servers requiring mutual TLS")
+	tlsKey := flag.String("tlsKey", "", "Private key file of -tlsCert")
+	tlsCA := flag.String("tlsCA", "", "CA file used to verify servers, enables TLS")
+	tlsServerName := flag.String("tlsServerName", "", "Server name to verify instead of the host of each server address")
+	namespace := flag.String("namespace", "", "Namespace to read and write, empty for the default namespace")
+	ringHash := flag.String("ringHash", "crc32", "Hash function of the static ring built from -nodes: crc32, fnv, murmur3 or xxhash")
+	timeout := flag.Duration("timeout", client.DefaultTimeout, "Timeout of each request sent to a server")
+	retries := flag.Int("retries", client.DefaultRetryPolicy.Retries, "How often a failed request is retried with exponential backoff")
+	hedgeDelay := flag.Duration("hedgeDelay", 0, "Send a read to the next replica too when the first has not answered after this delay, 0 disables hedged reads")
+	token := flag.String("token", os.Getenv("NODB_TOKEN"), "Token sent to servers that require authentication (default $NODB_TOKEN)")
+	traceExporter := flag.String("traceExporter", "", "Where OpenTelemetry spans go: stdout or otlp; empty disables tracing")
+	traceEndpoint := flag.String("traceEndpoint", "", "OTLP collector address (host:port) with -traceExporter=otlp (default localhost:4317)")
+	traceSample := flag.Float64("traceSample", 1, "Fraction of the requests that are traced")
+	flag.Parse()
+
+	// 请求的 trace 通过 gRPC 元数据传给服务端
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{Service: "nodb-client", Exporter: *traceExporter, Endpoint: *traceEndpoint, Sample: *traceSample})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer shutdownTracing(context.Background())
 
-// NewClient 创建一个新的 Client 实例
-func NewClient(nodes []string, replicas int) *Client {
-	var useRb = true
-	var hashRing consistenthash.HashRingInterface
-	if useRb {
-		hashRing = consistenthash.NewRbHashRing(nodes, replicas)
-	} else {
-		hashRing = consistenthash.NewHashRing(nodes, replicas)
+	opts := []client.Option{
+		client.WithRingHash(*ringHash),
+		client.WithTimeout(*timeout),
+		client.WithNamespace(*namespace),
+		client.WithRetries(*retries),
+		client.WithHedgedReads(*hedgeDelay),
 	}
-	return &Client{
-		hashRing: hashRing,
-		connPool: make(map[string]*grpc.ClientConn),
+	if *tlsCert != "" || *tlsCA != "" {
+		reloader, err := tlsutil.NewReloader(*tlsCert, *tlsKey, *tlsCA)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		go reloader.Watch(context.Background(), time.Minute, func(err error) {
+			fmt.Printf("Failed to reload TLS files: %v\n", err)
+		})
+		opts = append(opts, client.WithTLS(reloader, *tlsServerName))
 	}
-}
+	if *token != "" {
+		opts = append(opts, client.WithToken(*token))
+	}
+	if *coordinator != "" {
+		opts = append(opts, client.WithCoordinator(*coordinator))
+	}
+	c, err := client.New(strings.Split(*nodes, ","), opts...)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer c.Close()
 
-func main() {
-	client := NewClient([]string{"0.0.0.0:50051"}, 3)
+	ctx := context.Background()
+	// 服务端配置了协调节点时使用集群拓扑，否则使用 -nodes 中的节点
+	if err := c.RefreshTopology(ctx); err != nil {
+		fmt.Printf("Using the static node list: %v\n", err)
+	}
+	go c.WatchTopology(ctx, *topologyRefresh)
 
 	scanner := bufio.NewScanner(os.Stdin)
 	fmt.Println("Welcome to the NO-DB CLI!")
-	fmt.Println("Available commands: put <key> <value>, get <key>, delete <key>, addnode <address>, deletenode <address>, exit")
+	fmt.Println("Available commands: put <key> <value>, get <key>, delete <key>, addnode <address> [weight], deletenode <address>, stats, exit")
 
 	for {
 		fmt.Print("Enter command: ")
@@ -61,7 +106,7 @@ func main() {
 				fmt.Println("Usage: put <key> <value>")
 				continue
 			}
-			if err := client.Put(parts[1], parts[2]); err != nil {
+			if err := c.Put(ctx, parts[1], parts[2]); err != nil {
 				fmt.Printf("Error in put: %v\n", err)
 			}
 		case "get":
@@ -69,35 +114,59 @@ func main() {
 				fmt.Println("Usage: get <key>")
 				continue
 			}
-			if err := client.Get(parts[1]); err != nil {
+			value, err := c.Get(ctx, parts[1])
+			switch {
+			case errors.Is(err, client.ErrNotFound):
+				fmt.Printf("Get: %s not found\n", parts[1])
+			case err != nil:
 				fmt.Printf("Error in get: %v\n", err)
+			default:
+				fmt.Printf("Get: %s = %s\n", parts[1], value)
 			}
 		case "delete":
 			if len(parts) != 2 {
 				fmt.Println("Usage: delete <key>")
 				continue
 			}
-			if err := client.Delete(parts[1]); err != nil {
+			if err := c.Delete(ctx, parts[1]); err != nil {
 				fmt.Printf("Error in delete: %v\n", err)
 			}
 		case "addnode":
-			if len(parts) != 2 {
-				fmt.Println("Usage: addnode <address>")
+			if len(parts) != 2 && len(parts) != 3 {
+				fmt.Println("Usage: addnode <address> [weight]")
 				continue
 			}
-			client.AddNode(parts[1])
+			weight := 1
+			if len(parts) == 3 {
+				var err error
+				if weight, err = strconv.Atoi(parts[2]); err != nil || weight < 1 {
+					fmt.Println("Usage: addnode <address> [weight], weight is a positive integer")
+					continue
+				}
+			}
+			addNode(ctx, c, parts[1], weight)
 		case "deletenode":
 			if len(parts) != 2 {
-				fmt.Println("Usage: addnode <address>")
+				fmt.Println("Usage: deletenode <address>")
 				continue
 			}
-			client.DeleteNode(parts[1])
+			deleteNode(ctx, c, parts[1])
+		case "stats":
+			printPoolStats(c.PoolStats())
 		case "removenode":
 			if len(parts) != 2 {
 				fmt.Println("Usage: removenode <address>")
 				continue
 			}
-			client.RemoveNode(parts[1])
+			if c.ClusterManaged() {
+				deleteNode(ctx, c, parts[1])
+				continue
+			}
+			if err := c.RemoveNode(ctx, parts[1]); err != nil {
+				fmt.Printf("Failed to remove node %s: %v\n", parts[1], err)
+				continue
+			}
+			fmt.Printf("Node %s removed from the hash ring.\n", parts[1])
 		default:
 			fmt.Println("Unknown command:", parts[0])
 		}
@@ -108,136 +177,51 @@ func splitCommand(command string) []string {
 	return strings.Fields(command)
 }
 
-// Put 将键值对存储到主节点和副本节点
-func (c *Client) Put(key, value string) error {
-	clientMain, err := c.getClientConnection(key)
-	if err != nil {
-		return err
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-	defer cancel()
-	_, err = clientMain.Put(ctx, &pb.PutRequest{Key: key, Value: value})
-	return err
-
-}
-
-// Get 获取指定键的值
-func (c *Client) Get(key string) error {
-	clientMain, err := c.getClientConnection(key)
-	if err != nil {
-		return err
+// printPoolStats 打印连接池的统计信息
+func printPoolStats(stats client.PoolStats) {
+	fmt.Printf("Connections: %d, dials: %d, evictions: %d, health checks: %d (%d failed), breaker trips: %d, rejected requests: %d\n",
+		stats.Conns, stats.Dials, stats.Evictions, stats.HealthChecks, stats.HealthCheckFailures, stats.BreakerTrips, stats.Rejected)
+	nodes := make([]string, 0, len(stats.Nodes))
+	for node := range stats.Nodes {
+		nodes = append(nodes, node)
 	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-	defer cancel()
-	resp, err := clientMain.Get(ctx, &pb.GetRequest{Key: key})
-	if err == nil {
-		fmt.Printf("Get: %s = %s\n", key, resp.Value)
-		return nil
+	sort.Strings(nodes)
+	for _, node := range nodes {
+		n := stats.Nodes[node]
+		fmt.Printf("  %s: state %s, healthy %t, breaker %s, consecutive failures %d\n", node, n.State, n.Healthy, n.Breaker, n.Failures)
 	}
-
-	return fmt.Errorf("Get failed: %v (and replica)", err)
 }
 
-// Delete 删除指定键的值
-func (c *Client) Delete(key string) error {
-	clientMain, err := c.getClientConnection(key)
-	if err != nil {
-		return err
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-	defer cancel()
-	_, err = clientMain.Delete(ctx, &pb.DeleteRequest{Key: key})
-
-	return err
-}
-
-// AddNode 添加新节点到哈希环
-func (c *Client) AddNode(address string) {
-	c.hashRing.AddNode(address)
-	fmt.Printf("Node %s added to the hash ring.\n", address)
-}
-
-// RemoveNode 从哈希环中删除节点
-func (c *Client) RemoveNode(address string) {
-	c.hashRing.RemoveNode(address)
-	c.removeClientConnection(address)
-	fmt.Printf("Node %s removed from the hash ring.\n", address)
-}
-
-// getClientConnection 获取指定键的 gRPC 连接
-func (c *Client) getClientConnection(key string) (pb.KVDBClient, error) {
-	nodeAddr := c.hashRing.Get(key)
-	// fmt.Println(nodeAddr)
-	if conn, exists := c.connPool[nodeAddr]; exists {
-		return pb.NewKVDBClient(conn), nil
-	}
-	conn, err := grpc.Dial(nodeAddr, grpc.WithInsecure())
-	if err != nil {
-		return nil, fmt.Errorf("Failed To Connect To Server: %v", err)
-	}
-	c.connPool[nodeAddr] = conn
-	return pb.NewKVDBClient(conn), nil
-}
-
-func (c *Client) getClientConnectionByNode(nodeAddr string) (pb.KVDBClient, error) {
-	if conn, exists := c.connPool[nodeAddr]; exists {
-		return pb.NewKVDBClient(conn), nil
-	}
-	conn, err := grpc.Dial(nodeAddr, grpc.WithInsecure())
-	if err != nil {
-		return nil, fmt.Errorf("Failed To Connect To Server: %v", err)
-	}
-	c.connPool[nodeAddr] = conn
-	return pb.NewKVDBClient(conn), nil
-}
-
-// removeClientConnection 从连接池中删除节点连接
-func (c *Client) removeClientConnection(address string) {
-	if conn, exists := c.connPool[address]; exists {
-		conn.Close()
-		delete(c.connPool, address)
-	}
-}
-
-func (c *Client) ListAllData(node string) ([]string, []string) {
-	clientMain, err := c.getClientConnectionByNode(node)
-	if err != nil {
-		fmt.Errorf("Connected failed: %v", err)
-		return nil, nil
+// addNode 添加节点并打印迁移结果
+func addNode(ctx context.Context, c *client.Client, address string, weight int) {
+	migration, err := c.AddNodeWithWeight(ctx, address, weight)
+	if errors.Is(err, client.ErrClusterManaged) {
+		fmt.Println("Cluster membership is managed by the coordinator, start the server with -coordinator and -weight instead.")
+		return
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-	defer cancel()
-
-	resp, err := clientMain.ListAllData(ctx, &pb.Empty{})
 	if err != nil {
-		fmt.Errorf("GetAllData failed: %v", err)
-		return nil, nil
+		fmt.Printf("Some nodes could not be read: %v\n", err)
 	}
-
-	return resp.Keys, resp.Values
+	fmt.Printf("Node %s added to the hash ring, %d keys moved, %d failed.\n", address, migration.Moved, migration.Failed)
 }
 
-func (c *Client) DeleteNode(node string) {
-	Keys, Values := c.ListAllData(node)
-	c.hashRing.RemoveNode(node)
-
-	clientMain, err := c.getClientConnectionByNode(node)
-	if err != nil {
-		fmt.Errorf("Connected failed: %v", err)
-		return
-	}
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-	defer cancel()
-
-	for i := 0; i < len(Keys); i++ {
-		clientMain.Delete(ctx, &pb.DeleteRequest{Key: Keys[i]})
-	}
-
-	for i := 0; i < len(Keys); i++ {
-		c.Put(Keys[i], Values[i])
+// deleteNode 删除节点并打印迁移结果，集群拓扑由协调节点维护时下线分片
+func deleteNode(ctx context.Context, c *client.Client, node string) {
+	managed := c.ClusterManaged()
+	migration, err := c.DeleteNode(ctx, node)
+	switch {
+	case errors.Is(err, client.ErrNoCoordinator):
+		fmt.Println("Decommissioning a shard requires -coordinator.")
+	case managed && err != nil:
+		fmt.Println(err)
+	case managed:
+		fmt.Printf("Shard %s is leaving the cluster, stop its servers once they log that all keys were handed off.\n", node)
+	case err != nil || migration.Failed > 0:
+		if err != nil {
+			fmt.Printf("Some nodes could not be read: %v\n", err)
+		}
+		fmt.Printf("Node %s removed from the hash ring, %d keys moved, %d could not be moved and remain on it.\n", node, migration.Moved, migration.Failed)
+	default:
+		fmt.Printf("Node %s removed from the hash ring, %d keys moved.\n", node, migration.Moved)
 	}
-	fmt.Printf("Node %s removed from the hash ring.\n", node)
 }
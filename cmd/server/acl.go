@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/sidneychang/no-db/acl"
+	pb "github.com/sidneychang/no-db/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// 客户端在该请求头中携带 "Bearer <token>"
+const authorizationHeader = "authorization"
+
+// keyRequest 是针对命名空间中单个键的请求
+type keyRequest interface {
+	GetKey() string
+	GetNamespace() string
+}
+
+func isKVDBMethod(method string) bool {
+	return strings.HasPrefix(method, "/"+pb.KVDB_ServiceDesc.ServiceName+"/")
+}
+
+// authenticateToken 根据请求头中的 token 查找用户
+func authenticateToken(ctx context.Context, authorizer *acl.Authorizer) (*acl.Principal, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var token string
+	if values := md.Get(authorizationHeader); len(values) == 1 {
+		token = strings.TrimPrefix(values[0], "Bearer ")
+	}
+	principal, err := authorizer.Authenticate(token)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "%v", err)
+	}
+	return principal, nil
+}
+
+// 健康检查不需要认证，客户端的连接池据此判断节点是否可用
+func isHealthMethod(method string) bool {
+	return strings.HasPrefix(method, "/grpc.health.v1.Health/")
+}
+
+// authorizeInternal 检查 KVDB 以外的调用：Replication、Coordinator 等集群内部的服务
+// 只接受通过复制认证的集群节点或者管理员
+func authorizeInternal(ctx context.Context, authorizer *acl.Authorizer) (context.Context, error) {
+	if isPeer(ctx) {
+		return ctx, nil
+	}
+	principal, err := authenticateToken(ctx, authorizer)
+	if err != nil {
+		return nil, err
+	}
+	if !principal.IsAdmin() {
+		return nil, status.Errorf(codes.PermissionDenied, "user %q is not an admin", principal.Name)
+	}
+	return acl.NewContext(ctx, principal), nil
+}
+
+// requiredPermission 返回 KVDB 方法对键需要的权限，集群拓扑对所有认证用户可见
+func requiredPermission(method string) acl.Permission {
+	switch strings.TrimPrefix(method, "/"+pb.KVDB_ServiceDesc.ServiceName+"/") {
+	case "ClusterInfo":
+		return acl.None
+	case "Get":
+		return acl.Read
+	case "Put", "Delete":
+		return acl.Write
+	default:
+		return acl.Admin
+	}
+}
+
+// aclUnaryInterceptor 认证 KVDB 服务的请求，并按键前缀检查权限；
+// ListAllData 只返回用户有读权限的键。其他服务只允许集群节点和管理员调用
+func aclUnaryInterceptor(authorizer *acl.Authorizer) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if isHealthMethod(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		if !isKVDBMethod(info.FullMethod) {
+			ctx, err := authorizeInternal(ctx, authorizer)
+			if err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}
+		principal, err := authenticateToken(ctx, authorizer)
+		if err != nil {
+			return nil, err
+		}
+		ctx = acl.NewContext(ctx, principal)
+
+		if info.FullMethod == pb.KVDB_ListAllData_FullMethodName {
+			resp, err := handler(ctx, req)
+			if err != nil || principal.IsAdmin() {
+				return resp, err
+			}
+			namespace := req.(*pb.ListAllDataRequest).Namespace
+			return filterReadable(principal, namespace, resp.(*pb.ListAllDataResponse)), nil
+		}
+		perm := requiredPermission(info.FullMethod)
+		if perm == acl.None {
+			return handler(ctx, req)
+		}
+		namespace, key := acl.AllNamespaces, ""
+		if r, ok := req.(keyRequest); ok && perm != acl.Admin {
+			namespace, key = r.GetNamespace(), r.GetKey()
+		}
+		if !principal.Allowed(namespace, key, perm) {
+			return nil, status.Errorf(codes.PermissionDenied, "user %q has no %s permission on key %q in namespace %q", principal.Name, perm, key, namespace)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// aclStreamInterceptor 要求 KVDB 服务的流式调用具有管理员权限，
+// 其他服务的流式调用只允许集群节点和管理员
+func aclStreamInterceptor(authorizer *acl.Authorizer) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if isHealthMethod(info.FullMethod) {
+			return handler(srv, ss)
+		}
+		if !isKVDBMethod(info.FullMethod) {
+			ctx, err := authorizeInternal(ss.Context(), authorizer)
+			if err != nil {
+				return err
+			}
+			return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+		}
+		principal, err := authenticateToken(ss.Context(), authorizer)
+		if err != nil {
+			return err
+		}
+		if !principal.IsAdmin() {
+			return status.Errorf(codes.PermissionDenied, "user %q is not an admin", principal.Name)
+		}
+		return handler(srv, &authenticatedStream{ServerStream: ss, ctx: acl.NewContext(ss.Context(), principal)})
+	}
+}
+
+// filterReadable 过滤掉用户没有读权限的键值对
+func filterReadable(principal *acl.Principal, namespace string, resp *pb.ListAllDataResponse) *pb.ListAllDataResponse {
+	filtered := &pb.ListAllDataResponse{}
+	for i, key := range resp.Keys {
+		if principal.Allowed(namespace, key, acl.Read) {
+			filtered.Keys = append(filtered.Keys, key)
+			filtered.Values = append(filtered.Values, resp.Values[i])
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sidneychang/no-db/acl"
+	pb "github.com/sidneychang/no-db/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestACLInterceptor(t *testing.T) {
+	hash := func(token string) string {
+		sum := sha256.Sum256([]byte(token))
+		return hex.EncodeToString(sum[:])
+	}
+	file := filepath.Join(t.TempDir(), "acl.json")
+	policy := fmt.Sprintf(`{"users": [
+		{"name": "team-a", "token_sha256": %q, "grants": [{"prefix": "a/", "permission": "write"}, {"namespace": "team-a", "permission": "write"}]},
+		{"name": "team-b", "token_sha256": %q, "grants": [{"prefix": "b/", "permission": "read"}]}
+	]}`, hash("token-a"), hash("token-b"))
+	assert.Nil(t, os.WriteFile(file, []byte(policy), 0600))
+	authorizer, err := acl.NewAuthorizer(file)
+	assert.Nil(t, err)
+
+	s, err := NewServer(t.TempDir(), "primary", true, "", nil)
+	assert.Nil(t, err)
+	assert.Nil(t, s.db.Put([]byte("b/key"), []byte("b")))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	grpcServer := grpc.NewServer(grpc.ChainUnaryInterceptor(aclUnaryInterceptor(authorizer)))
+	pb.RegisterKVDBServer(grpcServer, s)
+	go grpcServer.Serve(listener)
+	defer grpcServer.Stop()
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.Nil(t, err)
+	defer conn.Close()
+	client := pb.NewKVDBClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	as := func(token string) context.Context {
+		return metadata.AppendToOutgoingContext(ctx, authorizationHeader, "Bearer "+token)
+	}
+
+	_, err = client.Get(ctx, &pb.GetRequest{Key: "b/key"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = client.Get(as("wrong"), &pb.GetRequest{Key: "b/key"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// each team only reaches its own prefix
+	_, err = client.Put(as("token-a"), &pb.PutRequest{Key: "a/key", Value: "a"})
+	assert.Nil(t, err)
+	_, err = client.Put(as("token-a"), &pb.PutRequest{Key: "b/key", Value: "a"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = client.Get(as("token-a"), &pb.GetRequest{Key: "b/key"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	resp, err := client.Get(as("token-b"), &pb.GetRequest{Key: "b/key"})
+	assert.Nil(t, err)
+	assert.Equal(t, "b", resp.Value)
+	_, err = client.Delete(as("token-b"), &pb.DeleteRequest{Key: "b/key"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// namespaces are granted separately
+	_, err = client.Put(as("token-a"), &pb.PutRequest{Namespace: "team-a", Key: "b/key", Value: "a"})
+	assert.Nil(t, err)
+	_, err = client.Get(as("token-b"), &pb.GetRequest{Namespace: "team-a", Key: "b/key"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// listing only returns the readable keys
+	list, err := client.ListAllData(as("token-b"), &pb.ListAllDataRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"b/key"}, list.Keys)
+	list, err = client.ListAllData(as("token-a"), &pb.ListAllDataRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a/key"}, list.Keys)
+
+	// every authenticated user can read the topology
+	_, err = client.ClusterInfo(ctx, &pb.ClusterInfoRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = client.ClusterInfo(as("token-b"), &pb.ClusterInfoRequest{})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func TestACLInterceptor_InternalServices(t *testing.T) {
+	sum := sha256.Sum256([]byte("token-ops"))
+	readerSum := sha256.Sum256([]byte("token-reader"))
+	file := filepath.Join(t.TempDir(), "acl.json")
+	policy := fmt.Sprintf(`{"users": [
+		{"name": "ops", "token_sha256": %q, "grants": [{"namespace": "*", "permission": "admin"}]},
+		{"name": "reader", "token_sha256": %q, "grants": [{"permission": "read"}]}
+	]}`, hex.EncodeToString(sum[:]), hex.EncodeToString(readerSum[:]))
+	assert.Nil(t, os.WriteFile(file, []byte(policy), 0600))
+	authorizer, err := acl.NewAuthorizer(file)
+	assert.Nil(t, err)
+
+	s, err := NewServer(t.TempDir(), "primary", true, "", nil)
+	assert.Nil(t, err)
+	s.replAuth = newReplicationAuth(testReplicationKey, s.nodeID)
+	assert.Nil(t, s.db.Put([]byte("key"), []byte("value")))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	grpcServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(s.replAuth.UnaryServerInterceptor(), aclUnaryInterceptor(authorizer)),
+		grpc.ChainStreamInterceptor(s.replAuth.StreamServerInterceptor(), aclStreamInterceptor(authorizer)))
+	pb.RegisterKVDBServer(grpcServer, s)
+	pb.RegisterReplicationServer(grpcServer, &replicationServer{s: s})
+	pb.RegisterCoordinatorServer(grpcServer, newCoordinatorServer(time.Second))
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	go grpcServer.Serve(listener)
+	defer grpcServer.Stop()
+	addr := listener.Addr().String()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	as := func(token string) context.Context {
+		return metadata.AppendToOutgoingContext(ctx, authorizationHeader, "Bearer "+token)
+	}
+	pull := func(ctx context.Context, client pb.ReplicationClient) error {
+		stream, err := client.Pull(ctx, &pb.PullRequest{ReplicaId: "replica"})
+		if err != nil {
+			return err
+		}
+		_, err = stream.Recv()
+		return err
+	}
+
+	// callers without the replication key cannot read the replication log, even as admins
+	anonymous, err := NewServer(t.TempDir(), "anonymous", false, "", nil)
+	assert.Nil(t, err)
+	client := dialReplication(t, anonymous, addr)
+	_, err = client.MerkleTree(as("token-ops"), &pb.MerkleTreeRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assert.Equal(t, codes.Unauthenticated, status.Code(pull(as("token-ops"), client)))
+
+	// and need an admin token for the coordinator
+	conn, err := grpc.Dial(addr, anonymous.peerDialOptions()...)
+	assert.Nil(t, err)
+	defer conn.Close()
+	coordinator := pb.NewCoordinatorClient(conn)
+	_, err = coordinator.GetLease(ctx, &pb.GetLeaseRequest{Shard: "shard"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = coordinator.GetLease(as("token-reader"), &pb.GetLeaseRequest{Shard: "shard"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = coordinator.GetLease(as("token-ops"), &pb.GetLeaseRequest{Shard: "shard"})
+	assert.Nil(t, err)
+
+	// peers holding the key are accepted without a token
+	peer, err := NewServer(t.TempDir(), "replica", false, "", nil)
+	assert.Nil(t, err)
+	peer.replAuth = newReplicationAuth(testReplicationKey, peer.nodeID)
+	client = dialReplication(t, peer, addr)
+	_, err = client.MerkleTree(ctx, &pb.MerkleTreeRequest{})
+	assert.Nil(t, err)
+	assert.Nil(t, pull(ctx, client))
+	conn, err = grpc.Dial(addr, peer.peerDialOptions()...)
+	assert.Nil(t, err)
+	defer conn.Close()
+	_, err = pb.NewCoordinatorClient(conn).GetLease(ctx, &pb.GetLeaseRequest{Shard: "shard"})
+	assert.Nil(t, err)
+
+	// but their calls to the KVDB service are still checked against the forwarded token
+	_, err = pb.NewKVDBClient(conn).Get(ctx, &pb.GetRequest{Key: "key"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	resp, err := pb.NewKVDBClient(conn).Get(as("token-reader"), &pb.GetRequest{Key: "key"})
+	assert.Nil(t, err)
+	assert.Equal(t, "value", resp.Value)
+
+	// health checks need no authentication
+	_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.Nil(t, err)
+}
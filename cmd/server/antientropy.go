@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"time"
+
+	"github.com/sidneychang/no-db/config"
+	"github.com/sidneychang/no-db/db/engine"
+	"github.com/sidneychang/no-db/merkle"
+	pb "github.com/sidneychang/no-db/proto"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 反熵使用的 Merkle 树深度，键空间被划分为 2^depth 个哈希桶
+const antiEntropyDepth = 10
+
+// MerkleTree 方法：返回 Primary 键空间的 Merkle 树
+func (r *replicationServer) MerkleTree(ctx context.Context, req *pb.MerkleTreeRequest) (*pb.MerkleTreeResponse, error) {
+	if req.Depth > merkle.MaxDepth {
+		return nil, status.Errorf(codes.InvalidArgument, "merkle tree depth %d exceeds %d", req.Depth, merkle.MaxDepth)
+	}
+	tree, err := buildMerkleTree(r.s.db, req.Depth)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.MerkleTreeResponse{Depth: tree.Depth(), Nodes: tree.Nodes()}, nil
+}
+
+// ScanBuckets 方法：返回落在指定哈希桶中的全部键值对
+func (r *replicationServer) ScanBuckets(req *pb.ScanBucketsRequest, stream pb.Replication_ScanBucketsServer) error {
+	if req.Depth > merkle.MaxDepth {
+		return status.Errorf(codes.InvalidArgument, "merkle tree depth %d exceeds %d", req.Depth, merkle.MaxDepth)
+	}
+	return scanBuckets(r.s.db, req.Depth, req.Buckets, func(namespace string, key []byte, value []byte) error {
+		return stream.Send(&pb.KeyValue{Key: key, Value: value, Namespace: namespace})
+	})
+}
+
+// buildMerkleTree 通过存储引擎的迭代器按键的顺序计算 Merkle 树
+func buildMerkleTree(db *engine.DB, depth uint32) (*merkle.Tree, error) {
+	builder := merkle.NewBuilder(depth)
+	err := scanBuckets(db, depth, nil, func(namespace string, key []byte, value []byte) error {
+		builder.Add(merkleKey(namespace, key), value)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return builder.Build(), nil
+}
+
+// merkleKey 将命名空间和键编码为 Merkle 树中的键，使不同命名空间中的相同键互不影响
+func merkleKey(namespace string, key []byte) []byte {
+	buf := make([]byte, binary.MaxVarintLen32+len(namespace)+len(key))
+	n := binary.PutUvarint(buf, uint64(len(namespace)))
+	n += copy(buf[n:], namespace)
+	n += copy(buf[n:], key)
+	return buf[:n]
+}
+
+// scanBuckets 按命名空间和键的顺序遍历落在指定哈希桶中的键值对，buckets 为 nil 时遍历全部键。
+// 迭代器遍历索引的快照并在关闭前固定数据文件，因此扫描不需要持有 s.mu，写入可以同时进行
+func scanBuckets(db *engine.DB, depth uint32, buckets []uint32, fn func(namespace string, key []byte, value []byte) error) error {
+	var wanted map[uint32]bool
+	if buckets != nil {
+		wanted = make(map[uint32]bool, len(buckets))
+		for _, bucket := range buckets {
+			wanted[bucket] = true
+		}
+	}
+	for _, namespace := range db.Namespaces() {
+		if err := scanNamespace(db.Namespace(namespace), depth, wanted, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scanNamespace(ns *engine.Namespace, depth uint32, wanted map[uint32]bool, fn func(namespace string, key []byte, value []byte) error) error {
+	it := ns.NewIterator(config.DefaultIteratorOptions)
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		key := it.Key()
+		if wanted != nil && !wanted[merkle.Bucket(merkleKey(ns.Name(), key), depth)] {
+			continue
+		}
+		value, err := it.Value()
+		if err != nil {
+			return err
+		}
+		if err := fn(ns.Name(), key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runAntiEntropy 定期与主节点比较 Merkle 树，修复复制遗漏的数据
+func (s *server) runAntiEntropy(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		isPrimary, primaryAddr := s.primary()
+		if isPrimary || primaryAddr == "" {
+			continue
+		}
+		repaired, err := s.repairFromPrimary(ctx, primaryAddr)
+		if err != nil {
+			s.logger().Warn("Anti-entropy failed", zap.String("primary", primaryAddr), zap.Error(err))
+		} else if repaired > 0 {
+			s.logger().Info("Anti-entropy repaired keys", zap.Int("keys", repaired), zap.String("primary", primaryAddr))
+		}
+	}
+}
+
+// repairFromPrimary 比较本地与主节点的 Merkle 树，只同步不一致的哈希桶，返回修复的键数
+func (s *server) repairFromPrimary(ctx context.Context, primaryAddr string) (int, error) {
+	conn, err := grpc.Dial(primaryAddr, s.peerDialOptions()...)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	client := pb.NewReplicationClient(conn)
+
+	resp, err := client.MerkleTree(ctx, &pb.MerkleTreeRequest{Depth: antiEntropyDepth})
+	if err != nil {
+		return 0, err
+	}
+	remote, err := merkle.FromNodes(resp.Depth, resp.Nodes)
+	if err != nil {
+		return 0, err
+	}
+	local, err := buildMerkleTree(s.db, resp.Depth)
+	if err != nil {
+		return 0, err
+	}
+	buckets, err := merkle.Diff(local, remote)
+	if err != nil || len(buckets) == 0 {
+		return 0, err
+	}
+
+	// 修复期间复制日志继续应用，只在写入每个键时持有 s.mu。
+	// 修复开始后被复制日志写入的键不再修复：从主节点读到的值可能比复制日志中的记录更旧，
+	// 这些键由之后的复制或下一轮反熵处理
+	s.mu.Lock()
+	s.repairWritten = make(map[string]struct{})
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.repairWritten = nil
+		s.mu.Unlock()
+	}()
+	stream, err := client.ScanBuckets(ctx, &pb.ScanBucketsRequest{Depth: resp.Depth, Buckets: buckets})
+	if err != nil {
+		return 0, err
+	}
+	primaryKeys := make(map[string]bool)
+	repaired := 0
+	for {
+		kv, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return repaired, err
+		}
+		primaryKeys[string(merkleKey(kv.Namespace, kv.Key))] = true
+		changed, err := s.repairKey(kv.Namespace, kv.Key, kv.Value, false)
+		if err != nil {
+			return repaired, err
+		}
+		if changed {
+			repaired++
+		}
+	}
+
+	// 删除主节点上已不存在的键
+	var stale []*pb.KeyValue
+	err = scanBuckets(s.db, resp.Depth, buckets, func(namespace string, key []byte, value []byte) error {
+		if !primaryKeys[string(merkleKey(namespace, key))] {
+			stale = append(stale, &pb.KeyValue{Key: key, Namespace: namespace})
+		}
+		return nil
+	})
+	if err != nil {
+		return repaired, err
+	}
+	for _, kv := range stale {
+		changed, err := s.repairKey(kv.Namespace, kv.Key, nil, true)
+		if err != nil {
+			return repaired, err
+		}
+		if changed {
+			repaired++
+		}
+	}
+	return repaired, nil
+}
+
+// repairKey 将本地的键修复为主节点上的值，deleted 为 true 时删除该键，返回是否修改了本地数据
+func (s *server) repairKey(namespace string, key []byte, value []byte, deleted bool) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, written := s.repairWritten[string(merkleKey(namespace, key))]; written {
+		return false, nil
+	}
+	ns := s.db.Namespace(namespace)
+	if deleted {
+		return true, ns.Delete(key)
+	}
+	if local, err := ns.Get(key); err == nil && bytes.Equal(local, value) {
+		return false, nil
+	}
+	return true, ns.Put(key, value)
+}
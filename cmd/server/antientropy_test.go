@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sidneychang/no-db/db/data"
+	pb "github.com/sidneychang/no-db/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+func TestRepairFromPrimary(t *testing.T) {
+	primary, err := NewServer(t.TempDir(), "primary", true, "", nil)
+	assert.Nil(t, err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	grpcServer := grpc.NewServer()
+	pb.RegisterReplicationServer(grpcServer, &replicationServer{s: primary})
+	go grpcServer.Serve(listener)
+	defer grpcServer.Stop()
+
+	replica, err := NewServer(t.TempDir(), "replica", false, listener.Addr().String(), nil)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		key, value := []byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("value-%d", i))
+		assert.Nil(t, primary.db.Put(key, value))
+		assert.Nil(t, replica.db.Put(key, value))
+	}
+	// the replica missed a write, a delete, and has a diverged value
+	assert.Nil(t, primary.db.Put([]byte("missing"), []byte("value")))
+	assert.Nil(t, primary.db.Delete([]byte("key-1")))
+	assert.Nil(t, replica.db.Put([]byte("key-2"), []byte("diverged")))
+	// the same key in another namespace is repaired on its own
+	assert.Nil(t, primary.db.Namespace("users").Put([]byte("key-3"), []byte("user")))
+
+	repaired, err := replica.repairFromPrimary(context.Background(), listener.Addr().String())
+	assert.Nil(t, err)
+	assert.Equal(t, 4, repaired)
+
+	value, err := replica.db.Get([]byte("missing"))
+	assert.Nil(t, err)
+	assert.Equal(t, "value", string(value))
+	_, err = replica.db.Get([]byte("key-1"))
+	assert.NotNil(t, err)
+	value, err = replica.db.Get([]byte("key-2"))
+	assert.Nil(t, err)
+	assert.Equal(t, "value-2", string(value))
+	value, err = replica.db.Namespace("users").Get([]byte("key-3"))
+	assert.Nil(t, err)
+	assert.Equal(t, "user", string(value))
+
+	// once converged nothing is left to repair
+	repaired, err = replica.repairFromPrimary(context.Background(), listener.Addr().String())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, repaired)
+}
+
+// blockingScan holds ScanBuckets until resume is closed
+type blockingScan struct {
+	*replicationServer
+	started chan struct{}
+	resume  chan struct{}
+}
+
+func (b *blockingScan) ScanBuckets(req *pb.ScanBucketsRequest, stream pb.Replication_ScanBucketsServer) error {
+	close(b.started)
+	<-b.resume
+	return b.replicationServer.ScanBuckets(req, stream)
+}
+
+func TestRepairFromPrimary_ConcurrentReplication(t *testing.T) {
+	primary, err := NewServer(t.TempDir(), "primary", true, "", nil)
+	assert.Nil(t, err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	scan := &blockingScan{replicationServer: &replicationServer{s: primary}, started: make(chan struct{}), resume: make(chan struct{})}
+	grpcServer := grpc.NewServer()
+	pb.RegisterReplicationServer(grpcServer, scan)
+	go grpcServer.Serve(listener)
+	defer grpcServer.Stop()
+
+	replica, err := NewServer(t.TempDir(), "replica", false, listener.Addr().String(), nil)
+	assert.Nil(t, err)
+	assert.Nil(t, primary.db.Put([]byte("a"), []byte("1")))
+	assert.Nil(t, primary.db.Put([]byte("b"), []byte("2")))
+
+	type result struct {
+		repaired int
+		err      error
+	}
+	done := make(chan result)
+	go func() {
+		repaired, err := replica.repairFromPrimary(context.Background(), listener.Addr().String())
+		done <- result{repaired, err}
+	}()
+
+	// replication goes on while the primary streams the buckets, and the key
+	// it writes is left to replication
+	<-scan.started
+	assert.Nil(t, replica.applyLogRecord(&pb.LogRecord{Key: []byte("b"), Value: []byte("3"), Type: uint32(data.Normal)}))
+	close(scan.resume)
+	res := <-done
+	assert.Nil(t, res.err)
+	assert.Equal(t, 1, res.repaired)
+
+	value, err := replica.db.Get([]byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, "1", string(value))
+	value, err = replica.db.Get([]byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, "3", string(value))
+}
+
+func TestBuildMerkleTree_ConcurrentWrites(t *testing.T) {
+	s, err := NewServer(t.TempDir(), "primary", true, "", nil)
+	assert.Nil(t, err)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 2000; i++ {
+			assert.Nil(t, s.db.Namespace("users").Put([]byte(fmt.Sprintf("key-%d", i)), []byte("value")))
+		}
+	}()
+
+	// the primary keeps taking writes while replicas compare their trees with it
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			_, err := buildMerkleTree(s.db, 4)
+			assert.Nil(t, err)
+		}
+	}()
+	select {
+	case <-finished:
+	case <-time.After(10 * time.Second):
+		t.Fatal("building the merkle tree under concurrent writes deadlocked")
+	}
+}
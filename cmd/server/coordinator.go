@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sidneychang/no-db/consistenthash"
+	"github.com/sidneychang/no-db/db/data"
+	pb "github.com/sidneychang/no-db/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// candidate 是申请已过期租约的副本
+type candidate struct {
+	applied *pb.LogPosition // 副本已应用的日志位置
+	seen    time.Time       // 最近一次申请的时间
+}
+
+// coordinatorServer 保存每个分片的主节点租约和集群成员，这些信息只保存在内存中
+type coordinatorServer struct {
+	pb.UnimplementedCoordinatorServer
+	mu      sync.Mutex
+	leases  map[string]*pb.Lease
+	members map[string]*member      // 节点地址到成员信息
+	leaving map[string]bool         // 已下线、正在移交数据的分片
+	view    *pb.ClusterInfoResponse // 最近一次计算的集群拓扑
+	// 租约过期后各分片申请租约的副本，选举结束时租约交给复制进度最新的副本
+	candidates map[string]map[string]*candidate
+	elections  map[string]time.Time // 各分片选举结束的时间
+	// 协调节点重启后的一个租约周期内只允许原主节点续约，避免副本抢占
+	graceUntil time.Time
+	now        func() time.Time
+}
+
+func newCoordinatorServer(leaseTTL time.Duration) *coordinatorServer {
+	return &coordinatorServer{
+		leases:     make(map[string]*pb.Lease),
+		members:    make(map[string]*member),
+		leaving:    make(map[string]bool),
+		candidates: make(map[string]map[string]*candidate),
+		elections:  make(map[string]time.Time),
+		view:       &pb.ClusterInfoResponse{VirtualNodes: consistenthash.DefaultVirtualNodes},
+		graceUntil: time.Now().Add(leaseTTL),
+		now:        time.Now,
+	}
+}
+
+// AcquireLease 方法：获取或续约分片的主节点租约
+func (c *coordinatorServer) AcquireLease(ctx context.Context, req *pb.LeaseRequest) (*pb.Lease, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	lease := c.validLease(req.Shard, now)
+	if lease.Holder != "" && lease.Holder != req.Holder {
+		// 租约由其他节点持有
+		return lease, nil
+	}
+	if lease.Holder == "" && !req.Renew && (now.Before(c.graceUntil) || !c.electLocked(req, now)) {
+		return lease, nil
+	}
+	delete(c.candidates, req.Shard)
+	delete(c.elections, req.Shard)
+
+	if lease.Holder != req.Holder {
+		lease.Epoch++
+		lease.Holder = req.Holder
+	}
+	lease.ExpiresUnixMs = now.Add(time.Duration(req.TtlMs) * time.Millisecond).UnixMilli()
+	c.leases[req.Shard] = lease
+
+	granted := proto.Clone(lease).(*pb.Lease)
+	granted.Granted = true
+	return granted, nil
+}
+
+// electLocked 登记申请租约的副本，返回是否将租约交给它。
+// 第一个副本申请后的一个租约周期内收集其他副本的复制进度，之后只有复制进度最新的副本能获得租约，
+// 避免落后的副本成为 Primary 后丢失已经确认的写入。超过一个租约周期没有再申请的副本不再参与选举
+// hold c.mu before accessing this method
+func (c *coordinatorServer) electLocked(req *pb.LeaseRequest, now time.Time) bool {
+	ttl := time.Duration(req.TtlMs) * time.Millisecond
+	candidates, ok := c.candidates[req.Shard]
+	if !ok {
+		candidates = make(map[string]*candidate)
+		c.candidates[req.Shard] = candidates
+	}
+	candidates[req.Holder] = &candidate{applied: req.Applied, seen: now}
+	closes, ok := c.elections[req.Shard]
+	if !ok {
+		closes = now.Add(ttl)
+		c.elections[req.Shard] = closes
+	}
+	if now.Before(closes) {
+		return false
+	}
+	for holder, other := range candidates {
+		if now.Sub(other.seen) > ttl {
+			delete(candidates, holder)
+			continue
+		}
+		if data.ComparePst(fromLogPosition(other.applied), fromLogPosition(req.Applied)) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// GetLease 方法：查询分片当前的主节点
+func (c *coordinatorServer) GetLease(ctx context.Context, req *pb.GetLeaseRequest) (*pb.Lease, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.validLease(req.Shard, c.now()), nil
+}
+
+// validLease 返回分片租约的副本，租约过期时 holder 为空
+func (c *coordinatorServer) validLease(shard string, now time.Time) *pb.Lease {
+	lease, ok := c.leases[shard]
+	if !ok {
+		return &pb.Lease{Shard: shard}
+	}
+	lease = proto.Clone(lease).(*pb.Lease)
+	if now.UnixMilli() >= lease.ExpiresUnixMs {
+		lease.Holder = ""
+	}
+	return lease
+}
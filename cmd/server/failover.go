@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	pb "github.com/sidneychang/no-db/proto"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+// 默认的主节点租约时长
+const defaultLeaseTTL = 3 * time.Second
+
+// runFailover 通过协调节点上的租约检测主节点故障：
+// Primary 定期续约作为心跳，租约过期后由复制进度最新的副本提升为 Primary，
+// 其余副本改为跟随新的 Primary。
+func (s *server) runFailover(ctx context.Context, coordinatorAddr string, shard string) {
+	conn, err := grpc.Dial(coordinatorAddr, s.peerDialOptions()...)
+	if err != nil {
+		s.log.Fatal("Failed to connect to coordinator", zap.Error(err))
+	}
+	defer conn.Close()
+	client := pb.NewCoordinatorClient(conn)
+
+	ticker := time.NewTicker(s.leaseTTL / 3)
+	defer ticker.Stop()
+	for {
+		s.checkLease(ctx, client, shard)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkLease 执行一次续约或故障检测
+func (s *server) checkLease(ctx context.Context, client pb.CoordinatorClient, shard string) {
+	ctx, cancel := context.WithTimeout(ctx, s.leaseTTL/3)
+	defer cancel()
+	// 租约从发出请求时开始计算，避免依赖协调节点的时钟
+	start := time.Now()
+	request := &pb.LeaseRequest{Shard: shard, Holder: s.nodeID, TtlMs: uint32(s.leaseTTL.Milliseconds())}
+
+	isPrimary, primaryAddr := s.primary()
+	if isPrimary {
+		request.Renew = true
+		lease, err := client.AcquireLease(ctx, request)
+		if err != nil {
+			s.logger().Warn("Failed to renew lease", zap.String("shard", shard), zap.Error(err))
+			return
+		}
+		if lease.Granted {
+			s.extendLease(start)
+			return
+		}
+		// 租约已被新的 Primary 持有，降级为副本。本节点可能有尚未复制到新 Primary 的写入，
+		// 清空本地数据后从新 Primary 完整复制，避免两个节点的数据不一致
+		s.logger().Warn("Lost lease, demoting to replica", zap.String("shard", shard), zap.String("holder", lease.Holder))
+		if err := s.resetReplication(lease.Holder); err != nil {
+			s.logger().Error("Failed to reset replication", zap.String("primary", lease.Holder), zap.Error(err))
+			return
+		}
+		if err := s.becomeReplica(lease.Holder); err != nil {
+			s.logger().Error("Failed to follow the primary", zap.String("primary", lease.Holder), zap.Error(err))
+		}
+		return
+	}
+
+	lease, err := client.GetLease(ctx, &pb.GetLeaseRequest{Shard: shard})
+	if err != nil {
+		s.logger().Warn("Failed to get lease", zap.String("shard", shard), zap.Error(err))
+		return
+	}
+	if lease.Holder == "" {
+		// 主节点租约已过期，带上复制进度尝试提升为 Primary
+		request.Applied = toLogPosition(s.getApplied())
+		lease, err = client.AcquireLease(ctx, request)
+		if err != nil {
+			s.logger().Warn("Failed to acquire lease", zap.String("shard", shard), zap.Error(err))
+			return
+		}
+		if lease.Granted {
+			s.becomePrimary()
+			s.extendLease(start)
+			s.logger().Info("Promoted to primary", zap.String("shard", shard), zap.Uint64("epoch", lease.Epoch))
+			return
+		}
+	}
+	if lease.Holder != "" && lease.Holder != primaryAddr {
+		s.logger().Info("Primary moved", zap.String("shard", shard), zap.String("primary", lease.Holder))
+		if err := s.becomeReplica(lease.Holder); err != nil {
+			s.logger().Error("Failed to follow the primary", zap.String("primary", lease.Holder), zap.Error(err))
+		}
+	}
+}
+
+// extendLease 在成功续约后延长本地记录的租约
+func (s *server) extendLease(start time.Time) {
+	s.roleMu.Lock()
+	defer s.roleMu.Unlock()
+	s.leaseExpiry = start.Add(s.leaseTTL)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sidneychang/no-db/db/engine"
+	pb "github.com/sidneychang/no-db/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestCoordinatorLease(t *testing.T) {
+	c := newCoordinatorServer(time.Second)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	ctx := context.Background()
+	acquire := func(holder string, renew bool) *pb.Lease {
+		lease, err := c.AcquireLease(ctx, &pb.LeaseRequest{Shard: "s1", Holder: holder, TtlMs: 1000, Renew: renew})
+		assert.Nil(t, err)
+		return lease
+	}
+
+	// during the grace period after a restart only a primary may take the lease
+	assert.False(t, acquire("replica", false).Granted)
+	lease := acquire("primary", true)
+	assert.True(t, lease.Granted)
+	assert.Equal(t, uint64(1), lease.Epoch)
+
+	// the lease is held until it expires
+	now = now.Add(500 * time.Millisecond)
+	assert.False(t, acquire("replica", false).Granted)
+	assert.True(t, acquire("primary", true).Granted)
+	now = now.Add(900 * time.Millisecond)
+	lease, _ = c.GetLease(ctx, &pb.GetLeaseRequest{Shard: "s1"})
+	assert.Equal(t, "primary", lease.Holder)
+
+	// a replica takes over the expired lease once the other replicas had a
+	// lease period to ask, and the old primary is told who holds it
+	now = now.Add(200 * time.Millisecond)
+	lease, _ = c.GetLease(ctx, &pb.GetLeaseRequest{Shard: "s1"})
+	assert.Equal(t, "", lease.Holder)
+	assert.False(t, acquire("replica", false).Granted)
+	now = now.Add(time.Second)
+	lease = acquire("replica", false)
+	assert.True(t, lease.Granted)
+	assert.Equal(t, uint64(2), lease.Epoch)
+	lease = acquire("primary", true)
+	assert.False(t, lease.Granted)
+	assert.Equal(t, "replica", lease.Holder)
+}
+
+func TestCoordinatorLease_MostCaughtUpReplica(t *testing.T) {
+	c := newCoordinatorServer(0)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	ctx := context.Background()
+	acquire := func(holder string, applied int64) bool {
+		lease, err := c.AcquireLease(ctx, &pb.LeaseRequest{Shard: "s1", Holder: holder, TtlMs: 1000, Applied: &pb.LogPosition{Offset: applied}})
+		assert.Nil(t, err)
+		return lease.Granted
+	}
+
+	// the lagging replica asks first, but the other replica applied more
+	assert.False(t, acquire("lagging", 100))
+	now = now.Add(300 * time.Millisecond)
+	assert.False(t, acquire("ahead", 200))
+	now = now.Add(time.Second)
+	assert.False(t, acquire("lagging", 100))
+	assert.True(t, acquire("ahead", 200))
+
+	// a replica that stopped asking no longer holds back the election
+	now = now.Add(2 * time.Second)
+	assert.False(t, acquire("ahead", 300))
+	now = now.Add(500 * time.Millisecond)
+	assert.False(t, acquire("lagging", 100))
+	now = now.Add(500 * time.Millisecond)
+	assert.False(t, acquire("lagging", 100))
+	now = now.Add(100 * time.Millisecond)
+	assert.True(t, acquire("lagging", 100))
+}
+
+func TestCheckWritable(t *testing.T) {
+	s, err := NewServer(t.TempDir(), "primary", true, "", nil)
+	assert.Nil(t, err)
+	assert.Nil(t, s.checkWritable())
+
+	// with failover enabled, writes need a valid lease
+	s.leaseTTL = time.Second
+	assert.NotNil(t, s.checkWritable())
+	s.extendLease(time.Now())
+	assert.Nil(t, s.checkWritable())
+
+	s.becomePrimary()
+	assert.Nil(t, s.becomeReplica("localhost:50052"))
+	defer s.becomePrimary()
+	isPrimary, primaryAddr := s.primary()
+	assert.False(t, isPrimary)
+	assert.Equal(t, "localhost:50052", primaryAddr)
+	assert.NotNil(t, s.checkWritable())
+}
+
+func TestCheckLease_Demotion(t *testing.T) {
+	c := newCoordinatorServer(0)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	grpcServer := grpc.NewServer()
+	pb.RegisterCoordinatorServer(grpcServer, c)
+	go grpcServer.Serve(listener)
+	defer grpcServer.Stop()
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.Nil(t, err)
+	defer conn.Close()
+	client := pb.NewCoordinatorClient(conn)
+
+	s, err := NewServer(t.TempDir(), "primary", true, "", nil)
+	assert.Nil(t, err)
+	defer s.close()
+	s.leaseTTL = time.Second
+	ctx := context.Background()
+	s.checkLease(ctx, client, "s1")
+	assert.Nil(t, s.checkWritable())
+	// a write that never reached the replicas
+	assert.Nil(t, s.db.Put([]byte("unreplicated"), []byte("value")))
+
+	// the lease expires and a replica takes it over
+	now := time.Now().Add(2 * time.Second)
+	c.now = func() time.Time { return now }
+	replica := &pb.LeaseRequest{Shard: "s1", Holder: "127.0.0.1:1", TtlMs: 1000}
+	_, err = c.AcquireLease(ctx, replica)
+	assert.Nil(t, err)
+	now = now.Add(time.Second)
+	lease, err := c.AcquireLease(ctx, replica)
+	assert.Nil(t, err)
+	assert.True(t, lease.Granted)
+
+	// the old primary follows the new one and drops what it did not replicate
+	s.checkLease(ctx, client, "s1")
+	isPrimary, primaryAddr := s.primary()
+	assert.False(t, isPrimary)
+	assert.Equal(t, "127.0.0.1:1", primaryAddr)
+	_, err = s.db.Get([]byte("unreplicated"))
+	assert.Equal(t, engine.ErrKeyNotFound, err)
+}
@@ -2,223 +2,456 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net"
 	"os"
-	"strings"
+	"os/signal"
 	"sync"
+	"syscall"
+	"time"
 
+	"github.com/sidneychang/no-db/acl"
 	"github.com/sidneychang/no-db/config"
+	"github.com/sidneychang/no-db/consistenthash"
+	"github.com/sidneychang/no-db/db/data"
 	"github.com/sidneychang/no-db/db/engine"
+	"github.com/sidneychang/no-db/logging"
 	pb "github.com/sidneychang/no-db/proto" // 替换为你的 protobuf 路径
+	"github.com/sidneychang/no-db/raftgroup"
+	"github.com/sidneychang/no-db/tlsutil"
+	"github.com/sidneychang/no-db/tracing"
 
+	"go.uber.org/zap"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
 )
 
 type server struct {
 	pb.UnimplementedKVDBServer
-	mu             sync.Mutex
-	db             *engine.DB
-	primaryAddr    string          // 主节点地址（仅副本节点使用）
-	isPrimary      bool            // 是否是 Primary
-	replicaClients []pb.KVDBClient // 仅 Primary 节点使用
+	mu      sync.Mutex
+	db      *engine.DB
+	pathdir string // 数据存储目录
+	nodeID  string // 节点标识，副本拉取日志时用于区分不同副本
+
+	roleMu      sync.RWMutex       // 保护主从角色，故障转移时角色会发生变化
+	primaryAddr string             // 主节点地址（仅副本节点使用）
+	isPrimary   bool               // 是否是 Primary
+	stopReplica context.CancelFunc // 停止从主节点拉取日志（仅副本节点使用）
+	leaseTTL    time.Duration      // 主节点租约时长，为 0 时不进行故障转移
+	leaseExpiry time.Time          // 主节点租约到期时间，到期后拒绝写操作
+
+	replicaMu    sync.Mutex
+	replicas     map[string]*replicaProgress // 各副本的复制进度（仅 Primary 节点使用）
+	ackNotify    chan struct{}               // 副本确认新位置时关闭并替换
+	applied      *data.RecordPst             // 已应用的主节点日志位置（仅副本节点使用）
+	writeConcern *pb.WriteConcern            // 请求未指定时使用的写入确认级别
+
+	raftNode *raftgroup.Node   // 使用 raft 复制时的组成员，此时忽略主从配置
+	replAuth *replicationAuth  // 复制流量的认证，为 nil 时不进行认证
+	tls      *tlsutil.Reloader // TLS 证书，为 nil 时使用明文连接
+
+	shard     string // 本节点所在的分片，向协调节点注册时使用
+	clusterMu sync.RWMutex
+	cluster   *pb.ClusterInfoResponse  // 从协调节点获取的集群拓扑，未配置协调节点时为 nil
+	ring      *consistenthash.HashRing // 按集群拓扑中的分片构建的哈希环
+
+	peerMu sync.Mutex
+	peers  map[string]*grpc.ClientConn // 转发请求时到其他服务端的连接
+
+	handoffMu sync.Mutex
+	handoff   *handoff // 正在进行的再平衡，没有时为 nil
+
+	repairWritten map[string]struct{} // 反熵修复期间被复制日志写入的键，由 s.mu 保护，不在修复时为 nil
+
+	log       *zap.Logger
+	logValues bool // 是否在日志中记录值，默认只记录值的大小
 }
 
 // Put 方法：客户端写请求
 func (s *server) Put(ctx context.Context, req *pb.PutRequest) (*pb.Empty, error) {
-	if !s.isPrimary && !s.isRequestFromPrimary(ctx) {
-		return nil, fmt.Errorf("Write operations are only allowed from the Primary server")
+	if s.raftNode != nil {
+		record := &pb.LogRecord{Key: []byte(req.Key), Value: []byte(req.Value), Type: uint32(data.Normal), Namespace: req.Namespace}
+		if err := s.proposeToRaft(ctx, record); err != nil {
+			return nil, err
+		}
+		s.log.Debug("Put", zap.String("key", req.Key), logging.Value(req.Value, !s.logValues))
+		return &pb.Empty{}, nil
 	}
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
+	if err := s.checkWritable(); err != nil {
+		return nil, err
+	}
+	s.lockDB(ctx)
 	// 1. 将数据写入本地存储
-	err := s.db.Put([]byte(req.Key), []byte(req.Value))
+	err := s.db.Namespace(req.Namespace).PutContext(ctx, []byte(req.Key), []byte(req.Value))
+	s.noteWrite(req.Namespace, req.Key)
+	target := s.db.LogPosition()
+	s.mu.Unlock()
 	if err != nil {
 		return nil, err
 	}
-	log.Printf("[%s] Put %s = %s\n", s.getRole(), req.Key, req.Value)
+	s.log.Debug("Put", zap.String("key", req.Key), logging.Value(req.Value, !s.logValues))
 
-	// 2. 如果是 Primary，则将写操作复制到副本
-	if s.isPrimary {
-		go s.replicateToReplicas(req)
+	// 2. 副本通过 Replication 服务拉取该写操作，按写入确认级别等待副本确认
+	if err := s.waitForReplicas(ctx, target, req.Concern); err != nil {
+		return nil, err
 	}
-
 	return &pb.Empty{}, nil
 }
 
 // Get 方法：客户端读请求
 func (s *server) Get(ctx context.Context, req *pb.GetRequest) (*pb.GetResponse, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	value, err := s.db.Get([]byte(req.Key))
+	s.lockDB(ctx)
+	value, err := s.db.Namespace(req.Namespace).GetContext(ctx, []byte(req.Key))
+	s.mu.Unlock()
 	if err != nil {
-		log.Printf("Get failed for key: %s, error: %v\n", req.Key, err)
+		// 再平衡期间键可能还没有从原所属分片移交过来
+		if resp, ok := s.readThrough(ctx, req); ok {
+			return resp, nil
+		}
+		s.log.Debug("Get failed", zap.String("key", req.Key), zap.Error(err))
+		if errors.Is(err, engine.ErrKeyNotFound) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
 		return nil, err
 	}
 
-	log.Printf("[%s] Get %s = %s\n", s.getRole(), req.Key, string(value))
+	s.log.Debug("Get", zap.String("key", req.Key), logging.Value(string(value), !s.logValues))
 	return &pb.GetResponse{Value: string(value)}, nil
 }
 
-func (s *server) ListAllData(ctx context.Context, req *pb.Empty) (*pb.ListAllDataResponse, error) {
+func (s *server) ListAllData(ctx context.Context, req *pb.ListAllDataRequest) (*pb.ListAllDataResponse, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	keys, values := s.db.ListAllData()
+	keys, values := s.db.Namespace(req.Namespace).ListAllData()
 	var Keys []string
 	var Values []string
 
 	for i := 0; i < len(keys); i++ {
 		Keys = append(Keys, string(keys[i]))
 		Values = append(Values, string(values[i]))
-		// log.Printf("[%s] - [%s] - [%d] \n", Keys[i], Values[i], i)
 	}
 	return &pb.ListAllDataResponse{Keys: Keys, Values: Values}, nil
 }
 
 // Delete 方法：客户端删除请求
 func (s *server) Delete(ctx context.Context, req *pb.DeleteRequest) (*pb.Empty, error) {
-	if !s.isPrimary && !s.isRequestFromPrimary(ctx) {
-		return nil, fmt.Errorf("Delete operations are only allowed from the Primary server")
+	if s.raftNode != nil {
+		record := &pb.LogRecord{Key: []byte(req.Key), Type: uint32(data.Deleted), Namespace: req.Namespace}
+		if err := s.proposeToRaft(ctx, record); err != nil {
+			return nil, err
+		}
+		s.log.Debug("Delete", zap.String("key", req.Key))
+		return &pb.Empty{}, nil
 	}
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	err := s.db.Delete([]byte(req.Key))
-	if err != nil {
+	if err := s.checkWritable(); err != nil {
 		return nil, err
 	}
-	log.Printf("[%s] Deleted key: %s\n", s.getRole(), req.Key)
-	// 如果是 Primary，处理删除并同步到副本
-	// 如果是 Primary，则将删除操作复制到副本
-	if s.isPrimary {
-		go s.replicateDeleteToReplicas(req)
+	s.lockDB(ctx)
+	err := s.db.Namespace(req.Namespace).DeleteContext(ctx, []byte(req.Key))
+	s.noteWrite(req.Namespace, req.Key)
+	target := s.db.LogPosition()
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
 	}
+	s.log.Debug("Delete", zap.String("key", req.Key))
 
+	if err := s.waitForReplicas(ctx, target, req.Concern); err != nil {
+		return nil, err
+	}
 	return &pb.Empty{}, nil
 }
 
-// 将删除操作复制到所有副本
-func (s *server) replicateDeleteToReplicas(req *pb.DeleteRequest) {
-	var wg sync.WaitGroup
-	for _, replica := range s.replicaClients {
-		wg.Add(1)
-		go func(replica pb.KVDBClient) {
-			defer wg.Done()
-			_, err := replica.Delete(context.Background(), req)
-			if err != nil {
-				log.Printf("Error replicating delete to replica: %v\n", err)
-			}
-		}(replica)
+// 获取当前节点的角色
+func (s *server) getRole() string {
+	if s.raftNode != nil {
+		return s.raftNode.State()
 	}
-	wg.Wait()
+	if isPrimary, _ := s.primary(); isPrimary {
+		return "Primary"
+	}
+	return "Replica"
 }
 
-// 将写操作复制到所有副本
-func (s *server) replicateToReplicas(req *pb.PutRequest) {
-	var wg sync.WaitGroup
-	for _, replica := range s.replicaClients {
-		wg.Add(1)
-		go func(replica pb.KVDBClient) {
-			defer wg.Done()
-			_, err := replica.Put(context.Background(), req)
-			if err != nil {
-				log.Printf("Error replicating to replica: %v\n", err)
-			}
-		}(replica)
-	}
-	wg.Wait()
+// logger 返回记录当前角色的日志记录器
+func (s *server) logger() *zap.Logger {
+	return s.log.With(zap.String("role", s.getRole()))
 }
 
-// 检查请求是否来自 Primary 节点
-func (s *server) isRequestFromPrimary(ctx context.Context) bool {
-	// 获取 gRPC 请求的 Peer 信息
-	p, ok := peer.FromContext(ctx)
-	if !ok {
-		log.Printf("[%s] Failed to get peer info", s.getRole())
-		return false
-	}
+// primary 返回当前节点是否为 Primary，以及副本所跟随的主节点地址
+func (s *server) primary() (bool, string) {
+	s.roleMu.RLock()
+	defer s.roleMu.RUnlock()
+	return s.isPrimary, s.primaryAddr
+}
 
-	// 解析出请求来源的 IP 地址
-	peerAddr, _, err := net.SplitHostPort(p.Addr.String())
-	if err != nil {
-		log.Printf("[%s] Failed to parse peer address: %v", s.getRole(), err)
-		return false
+// checkWritable 检查当前节点能否接受写操作，非 Primary 时返回当前主节点地址
+func (s *server) checkWritable() error {
+	s.roleMu.RLock()
+	defer s.roleMu.RUnlock()
+	if !s.isPrimary {
+		return status.Errorf(codes.FailedPrecondition, "not the Primary server, current primary is %q", s.primaryAddr)
 	}
-
-	// 检查请求来源是否为 Primary 地址
-	if peerAddr == s.primaryAddr {
-		return true
+	if s.leaseTTL > 0 && time.Now().After(s.leaseExpiry) {
+		return status.Errorf(codes.Unavailable, "primary lease has expired")
 	}
-	log.Printf("[%s] Unauthorized request from %s, expect form %s", s.getRole(), peerAddr, s.primaryAddr)
-	return false
+	return nil
 }
 
-// 获取当前节点的角色
-func (s *server) getRole() string {
-	if s.isPrimary {
-		return "Primary"
+// transportCredentials 返回连接其他节点时使用的传输层凭证，配置了 CA 时同时出示本节点的证书
+func (s *server) transportCredentials() credentials.TransportCredentials {
+	if s.tls == nil {
+		return insecure.NewCredentials()
 	}
-	return "Replica"
+	return s.tls.ClientCredentials("")
 }
 
 func main() {
 	// 解析启动参数 --role 和 --replicas
 	isPrimary := flag.Bool("primary", false, "Run as primary server")
-	replicas := flag.String("replicas", "", "Comma-separated list of replica addresses")
+	replicas := flag.String("replicas", "", "Comma-separated list of replica ids expected to pull from the primary")
 	pathdir := flag.String("pathdir", os.TempDir(), "The directory for data storage")
-	primaryAddr := flag.String("primaryAddr", "", "Primary server address")
+	primaryAddr := flag.String("primaryAddr", "", "Primary server address (host:port) to replicate from")
 	port := flag.Int("port", 50051, "Server port")
+	addr := flag.String("addr", "", "Advertised address of this server, used as its replica id (default 0.0.0.0:<port>)")
+	ack := flag.String("ack", "local", "Default write acknowledgement level: local, replicas or all")
+	ackReplicas := flag.Uint("ackReplicas", 1, "Number of replicas to wait for with -ack=replicas")
+	ackTimeout := flag.Duration("ackTimeout", defaultAckTimeout, "How long a write waits for replica acknowledgements")
+	useRaft := flag.Bool("raft", false, "Replicate writes through a raft group instead of -primary/-primaryAddr")
+	raftAddr := flag.String("raftAddr", "0.0.0.0:60051", "Address the raft transport listens on")
+	raftAdvertise := flag.String("raftAdvertise", "", "Raft address announced to the other members (default -raftAddr)")
+	raftPeers := flag.String("raftPeers", "", "Comma-separated id=raftAddr list of the group members, where id is the member's -addr")
+	raftBootstrap := flag.Bool("raftBootstrap", false, "Bootstrap the raft group with -raftPeers, on a single member")
+	coordinator := flag.String("coordinator", "", "Coordinator address holding the primary lease, enables automatic failover")
+	serveCoordinator := flag.Bool("serveCoordinator", false, "Also serve the coordinator service on this server")
+	shard := flag.String("shard", "", "Shard name shared by a primary and its replicas (default the primary's address)")
+	zone := flag.String("zone", "", "Availability zone or rack of this server, the replicas of a key are spread over zones")
+	weight := flag.Uint("weight", 1, "Relative capacity of this server, a shard gets a share of the keys proportional to its weight")
+	ringHash := flag.String("ringHash", "crc32", "Hash function placing shards and keys on the ring: crc32, fnv, murmur3 or xxhash; set on the coordinator")
+	replicationFactor := flag.Uint("replicationFactor", 1, "Number of shards storing each key, the key's successors on the ring; set on the coordinator")
+	leaseTTL := flag.Duration("leaseTTL", defaultLeaseTTL, "Primary lease duration, a replica is promoted once it expires")
+	replicationKeyFile := flag.String("replicationKeyFile", "", "File holding the key shared by the primary and its replicas to authenticate replication traffic")
+	tlsCert := flag.String("tlsCert", "", "Certificate file, enables TLS")
+	tlsKey := flag.String("tlsKey", "", "Private key file of -tlsCert")
+	tlsCA := flag.String("tlsCA", "", "CA file used to verify peers, enables mutual TLS")
+	tlsReload := flag.Duration("tlsReload", time.Minute, "How often the TLS files are checked for changes")
+	aclFile := flag.String("aclFile", "", "JSON file of tokens and key prefix grants, enables authentication of clients")
+	aclReload := flag.Duration("aclReload", 10*time.Second, "How often -aclFile is checked for changes")
+	routing := flag.String("routing", routeForward, "How a request for a key owned by another shard is handled: forward or redirect")
+	antiEntropy := flag.Duration("antiEntropyInterval", time.Minute, "How often a replica compares its data with the primary and repairs differences, 0 disables")
+	traceExporter := flag.String("traceExporter", "", "Where OpenTelemetry spans go: stdout or otlp; empty disables tracing")
+	traceEndpoint := flag.String("traceEndpoint", "", "OTLP collector address (host:port) with -traceExporter=otlp (default localhost:4317)")
+	traceSample := flag.Float64("traceSample", 1, "Fraction of the traces started by this server that are recorded, requests follow the client's decision")
+	metricsAddr := flag.String("metricsAddr", "", "HTTP address serving Prometheus metrics on /metrics, e.g. :9100; empty disables")
+	shutdownDelay := flag.Duration("shutdownDelay", 0, "How long the server keeps serving after reporting NOT_SERVING on SIGINT/SIGTERM, so that clients stop sending requests first")
+	shutdownTimeout := flag.Duration("shutdownTimeout", 30*time.Second, "How long in-flight requests may run during shutdown before connections are closed")
+	logLevel := flag.String("logLevel", "info", "Minimum level of the logs: debug, info, warn or error; every request is logged at debug")
+	logFormat := flag.String("logFormat", logging.FormatJSON, "Format of the logs: json or console")
+	logSampling := flag.Int("logSampling", 100, "Log the first N entries with the same level and message every second and every Nth after that, 0 logs all")
+	logValues := flag.Bool("logValues", false, "Log the values of debug-level request logs instead of only their size")
 	flag.Parse()
+	if *addr == "" {
+		*addr = fmt.Sprintf("0.0.0.0:%d", *port)
+	}
+
+	logger, err := logging.New(logging.Config{Level: *logLevel, Format: *logFormat, Sampling: *logSampling})
+	if err != nil {
+		log.Fatalf("Invalid log configuration: %v", err)
+	}
+	defer logger.Sync()
+	// 日志记录器显式传给服务端和存储引擎，其他使用标准库 log 的代码同样输出到这里
+	zap.RedirectStdLog(logger)
 	// 初始化 Server
-	s, err := NewServer(*pathdir, *isPrimary, *primaryAddr)
+	s, err := NewServer(*pathdir, *addr, *isPrimary, *primaryAddr, logger)
+	if err != nil {
+		logger.Fatal("Failed to initialize server", zap.Error(err))
+	}
+	s.logValues = *logValues
+	if s.writeConcern, err = parseWriteConcern(*ack, *ackReplicas, *ackTimeout); err != nil {
+		logger.Fatal("Invalid write acknowledgement level", zap.Error(err))
+	}
+	if *routing, err = parseRouting(*routing); err != nil {
+		logger.Fatal("Invalid routing mode", zap.Error(err))
+	}
+	// 退出时停止所有后台任务
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	shutdownTracing, err := tracing.Setup(ctx, tracing.Config{Service: "nodb-server", Exporter: *traceExporter, Endpoint: *traceEndpoint, Sample: *traceSample})
 	if err != nil {
-		log.Fatalf("Failed to initialize server: %v", err)
+		logger.Fatal("Failed to set up tracing", zap.Error(err))
+	}
+	// 退出前导出尚未发送的 span
+	defer shutdownTracing(context.Background())
+	if _, err := consistenthash.ParseHashFunc(*ringHash); err != nil {
+		logger.Fatal("Invalid ring hash", zap.Error(err))
+	}
+
+	if *replicationKeyFile != "" {
+		if s.replAuth, err = loadReplicationAuth(*replicationKeyFile, s.nodeID); err != nil {
+			logger.Fatal("Failed to load replication key", zap.Error(err))
+		}
+	} else if *aclFile != "" {
+		// 节点之间的调用不携带客户端的 token，没有密钥时无法区分集群节点和未认证的调用者
+		logger.Fatal("-aclFile requires -replicationKeyFile to authenticate the servers to each other")
+	} else if !*useRaft {
+		s.logger().Warn("No -replicationKeyFile given, replication traffic is not authenticated")
+	}
+
+	if *tlsCert != "" || *tlsCA != "" {
+		if s.tls, err = tlsutil.NewReloader(*tlsCert, *tlsKey, *tlsCA); err != nil {
+			logger.Fatal("Failed to load TLS files", zap.Error(err))
+		}
+		// 证书文件更新后自动重新加载，无需重启
+		go s.tls.Watch(ctx, *tlsReload, func(err error) {
+			s.logger().Error("Failed to reload TLS files", zap.Error(err))
+		})
+	}
+
+	if s.replAuth != nil && *tlsCert == "" {
+		s.logger().Warn("Replication traffic is authenticated but not encrypted, replicated records can be tampered with without TLS")
+	}
+
+	if *useRaft {
+		// 使用 raft 组复制写操作，由选举产生 Leader
+		s.isPrimary, s.primaryAddr = false, ""
+		if err := s.startRaft(*raftAddr, *raftAdvertise, *raftPeers, *raftBootstrap); err != nil {
+			logger.Fatal("Failed to start raft", zap.Error(err))
+		}
 	}
 
-	// 如果是 Primary，则初始化副本客户端连接
+	// 如果是 Primary，则登记需要跟踪复制进度的副本
 	if s.isPrimary && *replicas != "" {
-		s.initReplicaClients(*replicas)
+		s.initReplicas(*replicas)
+	}
+	// 如果是副本，则从主节点拉取日志
+	if !s.isPrimary && s.primaryAddr != "" {
+		if err := s.becomeReplica(s.primaryAddr); err != nil {
+			logger.Fatal("Failed to start replication", zap.Error(err))
+		}
+	}
+	// 配置了协调节点时，通过租约进行故障检测和自动切换，并向协调节点注册集群成员
+	if *coordinator != "" && s.raftNode == nil {
+		if *shard == "" {
+			*shard = s.nodeID
+			if !s.isPrimary {
+				*shard = s.primaryAddr
+			}
+		}
+		s.leaseTTL = *leaseTTL
+		s.shard = *shard
+		go s.runFailover(ctx, *coordinator, *shard)
+		go s.runMembership(ctx, *coordinator, *shard, *zone, uint32(*weight))
+	}
+	// 副本定期与主节点进行反熵修复
+	if *antiEntropy > 0 && s.raftNode == nil {
+		go s.runAntiEntropy(ctx, *antiEntropy)
 	}
 
 	// 启动 gRPC Server
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", *port))
 	if err != nil {
-		log.Fatalf("Failed to listen on port %d: %v", *port, err)
+		logger.Fatal("Failed to listen", zap.Int("port", *port), zap.Error(err))
+	}
+	// 指标拦截器放在最前面，统计所有请求
+	m := newMetrics(s)
+	serverOpts := []grpc.ServerOption{
+		tracing.ServerOption(),
+		grpc.ChainUnaryInterceptor(m.unaryInterceptor()),
+		grpc.ChainStreamInterceptor(m.streamInterceptor()),
+	}
+	if s.tls != nil {
+		serverOpts = append(serverOpts, grpc.Creds(s.tls.ServerCredentials()))
+	}
+	if s.replAuth != nil {
+		serverOpts = append(serverOpts,
+			grpc.ChainUnaryInterceptor(s.replAuth.UnaryServerInterceptor()),
+			grpc.ChainStreamInterceptor(s.replAuth.StreamServerInterceptor()))
 	}
-	grpcServer := grpc.NewServer()
+	if *aclFile != "" {
+		authorizer, err := acl.NewAuthorizer(*aclFile)
+		if err != nil {
+			logger.Fatal("Failed to load ACL file", zap.Error(err))
+		}
+		go authorizer.Watch(ctx, *aclReload, func(err error) {
+			s.logger().Error("Failed to reload ACL file", zap.Error(err))
+		})
+		serverOpts = append(serverOpts,
+			grpc.ChainUnaryInterceptor(aclUnaryInterceptor(authorizer)),
+			grpc.ChainStreamInterceptor(aclStreamInterceptor(authorizer)))
+	}
+	// 最后检查键的归属，转发前已完成认证
+	serverOpts = append(serverOpts, grpc.ChainUnaryInterceptor(s.routingUnaryInterceptor(*routing)))
+	grpcServer := grpc.NewServer(serverOpts...)
 	pb.RegisterKVDBServer(grpcServer, s)
-
-	log.Printf("[%s] Server listening on port %d", s.getRole(), *port)
-	if err := grpcServer.Serve(listener); err != nil {
-		log.Fatalf("Failed to serve: %v", err)
+	pb.RegisterReplicationServer(grpcServer, &replicationServer{s: s})
+	// 标准的 gRPC 健康检查服务，客户端的连接池据此判断连接是否可用
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(pb.KVDB_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	if *serveCoordinator {
+		coordinatorServer := newCoordinatorServer(*leaseTTL)
+		coordinatorServer.view.ReplicationFactor = uint32(*replicationFactor)
+		coordinatorServer.view.Hash = *ringHash
+		pb.RegisterCoordinatorServer(grpcServer, coordinatorServer)
 	}
-}
 
-// 初始化副本连接
-func (s *server) initReplicaClients(replicaAddrs string) {
-	addrs := strings.Split(replicaAddrs, ",")
-	for _, addr := range addrs {
-		conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	// 收到 SIGINT 或 SIGTERM 时优雅关闭
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	if *metricsAddr != "" {
+		metricsServer, err := serveMetrics(*metricsAddr, m, logger)
 		if err != nil {
-			log.Fatalf("Failed to connect to replica: %v", err)
+			logger.Fatal("Failed to listen on metrics address", zap.String("addr", *metricsAddr), zap.Error(err))
 		}
-		log.Println("Connected to replica:", addr)
-		client := pb.NewKVDBClient(conn)
+		defer metricsServer.Close()
+		s.logger().Info("Serving metrics", zap.String("addr", *metricsAddr))
+	}
 
-		s.replicaClients = append(s.replicaClients, client)
+	s.logger().Info("Server listening", zap.Int("port", *port))
+	opts := shutdownOptions{delay: *shutdownDelay, timeout: *shutdownTimeout, stopBackground: cancel}
+	if err := s.serve(grpcServer, listener, healthServer, signals, opts); err != nil {
+		logger.Fatal("Failed to serve", zap.Error(err))
 	}
+	s.logger().Info("Server stopped")
 }
-func NewServer(pathdir string, isPrimary bool, primaryAddr string) (*server, error) {
+
+// NewServer 创建服务端，服务端和存储引擎的日志写入 logger，logger 为 nil 时丢弃日志
+func NewServer(pathdir string, nodeID string, isPrimary bool, primaryAddr string, logger *zap.Logger) (*server, error) {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
 	// 使用指定的 pathdir 作为数据存储目录
 	options := config.NewOptions(1, 1024, pathdir)
+	options.Logger = logger
 	db, err := engine.NewDB(*options)
 	if err != nil {
 		return nil, err
 	}
-	return &server{db: db, isPrimary: isPrimary, primaryAddr: primaryAddr}, nil
+	s := &server{
+		db:          db,
+		pathdir:     pathdir,
+		nodeID:      nodeID,
+		isPrimary:   isPrimary,
+		primaryAddr: primaryAddr,
+		replicas:    make(map[string]*replicaProgress),
+		ackNotify:   make(chan struct{}),
+		peers:       make(map[string]*grpc.ClientConn),
+		log:         logger,
+		writeConcern: &pb.WriteConcern{
+			Level:     pb.AckLevel_ACK_LOCAL,
+			TimeoutMs: uint32(defaultAckTimeout.Milliseconds()),
+		},
+	}
+	return s, nil
 }
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	pb "github.com/sidneychang/no-db/proto"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// 节点向协调节点注册的间隔，注册信息在三个间隔内没有更新时节点被移出集群
+const membershipInterval = time.Second
+
+// member 是协调节点记录的集群成员
+type member struct {
+	shard   string
+	primary bool
+	zone    string
+	weight  uint32
+	expires time.Time
+}
+
+// Register 方法：节点注册或续期自己的成员信息，返回当前的集群拓扑
+func (c *coordinatorServer) Register(ctx context.Context, req *pb.RegisterRequest) (*pb.ClusterInfoResponse, error) {
+	if req.Address == "" || req.Shard == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "address and shard are required")
+	}
+	ttl := time.Duration(req.TtlMs) * time.Millisecond
+	if ttl <= 0 {
+		ttl = 3 * membershipInterval
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	// 先移除过期的成员，使节点全部停止后重新启动的分片不再处于退出状态
+	c.topology(now)
+	c.members[req.Address] = &member{shard: req.Shard, primary: req.Primary, zone: req.Zone, weight: req.Weight, expires: now.Add(ttl)}
+	return c.topology(now), nil
+}
+
+// ClusterInfo 方法：查询当前的集群拓扑
+func (c *coordinatorServer) ClusterInfo(ctx context.Context, req *pb.ClusterInfoRequest) (*pb.ClusterInfoResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.topology(c.now()), nil
+}
+
+// Decommission 方法：将分片移出哈希环，分片的键由新的所属分片取走后即可停止该分片的节点
+func (c *coordinatorServer) Decommission(ctx context.Context, req *pb.DecommissionRequest) (*pb.ClusterInfoResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	found := false
+	for _, m := range c.members {
+		if m.shard == req.Shard && !now.After(m.expires) {
+			found = true
+		}
+	}
+	if !found {
+		return nil, status.Errorf(codes.NotFound, "shard %q has no members", req.Shard)
+	}
+	c.leaving[req.Shard] = true
+	return c.topology(now), nil
+}
+
+// topology 移除过期的成员并返回集群拓扑的副本，拓扑发生变化时递增版本号。
+// 分片的主节点优先取租约的持有者，没有有效租约时取声明自己为 Primary 的成员。
+// hold a mutex before accessing this method
+func (c *coordinatorServer) topology(now time.Time) *pb.ClusterInfoResponse {
+	addrs := make([]string, 0, len(c.members))
+	for addr, m := range c.members {
+		if now.After(m.expires) {
+			delete(c.members, addr)
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	primaries := make(map[string]string)
+	for _, addr := range addrs {
+		m := c.members[addr]
+		if m.primary && primaries[m.shard] == "" {
+			primaries[m.shard] = addr
+		} else if _, ok := primaries[m.shard]; !ok {
+			primaries[m.shard] = ""
+		}
+	}
+	for shard := range primaries {
+		holder := c.validLease(shard, now).Holder
+		if m, ok := c.members[holder]; ok && m.shard == shard {
+			primaries[shard] = holder
+		}
+	}
+
+	// 节点全部停止后，分片不再处于退出状态，之后以相同名称启动的节点重新加入集群
+	for shard := range c.leaving {
+		if _, ok := primaries[shard]; !ok {
+			delete(c.leaving, shard)
+		}
+	}
+
+	shards := make(map[string]*pb.Shard)
+	var names []string
+	for shard, primary := range primaries {
+		shards[shard] = &pb.Shard{Name: shard, Primary: primary, Leaving: c.leaving[shard]}
+		names = append(names, shard)
+	}
+	// 分片的可用区和权重取主节点的，没有主节点时取成员中第一个设置了可用区的成员的
+	for _, addr := range addrs {
+		m := c.members[addr]
+		shard := shards[m.shard]
+		if addr != shard.Primary {
+			shard.Replicas = append(shard.Replicas, addr)
+		}
+		if addr == shard.Primary || (shard.Primary == "" && shard.Zone == "") {
+			shard.Zone, shard.Weight = m.zone, m.weight
+		}
+	}
+	sort.Strings(names)
+
+	view := &pb.ClusterInfoResponse{Version: c.view.Version, VirtualNodes: c.view.VirtualNodes, ReplicationFactor: c.view.ReplicationFactor, Hash: c.view.Hash}
+	for _, name := range names {
+		view.Shards = append(view.Shards, shards[name])
+	}
+	if !proto.Equal(view, c.view) {
+		view.Version++
+		c.view = view
+	}
+	return proto.Clone(c.view).(*pb.ClusterInfoResponse)
+}
+
+// runMembership 定期向协调节点注册本节点，并缓存返回的集群拓扑供客户端查询
+func (s *server) runMembership(ctx context.Context, coordinatorAddr string, shard string, zone string, weight uint32) {
+	conn, err := grpc.Dial(coordinatorAddr, s.peerDialOptions()...)
+	if err != nil {
+		s.log.Fatal("Failed to connect to coordinator", zap.Error(err))
+	}
+	defer conn.Close()
+	client := pb.NewCoordinatorClient(conn)
+
+	ticker := time.NewTicker(membershipInterval)
+	defer ticker.Stop()
+	for {
+		s.register(ctx, client, shard, zone, weight)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// register 执行一次注册
+func (s *server) register(ctx context.Context, client pb.CoordinatorClient, shard string, zone string, weight uint32) {
+	ctx, cancel := context.WithTimeout(ctx, membershipInterval)
+	defer cancel()
+	isPrimary, _ := s.primary()
+	view, err := client.Register(ctx, &pb.RegisterRequest{
+		Address: s.nodeID,
+		Shard:   shard,
+		Primary: isPrimary,
+		TtlMs:   uint32(3 * membershipInterval.Milliseconds()),
+		Zone:    zone,
+		Weight:  weight,
+	})
+	if err != nil {
+		s.logger().Warn("Failed to register with coordinator", zap.Error(err))
+		return
+	}
+	if prevRing, changed := s.setClusterInfo(view); changed {
+		s.startRebalance(prevRing, view)
+	}
+}
+
+// ClusterInfo 方法：返回本节点从协调节点获取的集群拓扑
+func (s *server) ClusterInfo(ctx context.Context, req *pb.ClusterInfoRequest) (*pb.ClusterInfoResponse, error) {
+	s.clusterMu.RLock()
+	defer s.clusterMu.RUnlock()
+	if s.cluster == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "cluster membership is not configured, start the server with -coordinator")
+	}
+	return s.cluster, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	pb "github.com/sidneychang/no-db/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestCoordinatorMembership(t *testing.T) {
+	c := newCoordinatorServer(time.Second)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	ctx := context.Background()
+	register := func(address, shard string, primary bool) *pb.ClusterInfoResponse {
+		view, err := c.Register(ctx, &pb.RegisterRequest{Address: address, Shard: shard, Primary: primary, TtlMs: 3000})
+		assert.Nil(t, err)
+		return view
+	}
+
+	_, err := c.Register(ctx, &pb.RegisterRequest{Address: "a1"})
+	assert.NotNil(t, err)
+
+	register("a1", "a", true)
+	register("a2", "a", false)
+	view := register("b1", "b", true)
+	assert.Equal(t, uint64(3), view.Version)
+	assert.Equal(t, uint32(100), view.VirtualNodes)
+	assert.Equal(t, 2, len(view.Shards))
+	assert.Equal(t, "a", view.Shards[0].Name)
+	assert.Equal(t, "a1", view.Shards[0].Primary)
+	assert.Equal(t, []string{"a2"}, view.Shards[0].Replicas)
+	assert.Equal(t, "b1", view.Shards[1].Primary)
+
+	// heartbeats that change nothing keep the version
+	now = now.Add(2 * time.Second)
+	register("a1", "a", true)
+	view = register("a2", "a", false)
+	assert.Equal(t, uint64(3), view.Version)
+
+	// b1 stops registering and is dropped once its ttl expires
+	now = now.Add(2 * time.Second)
+	view, _ = c.ClusterInfo(ctx, &pb.ClusterInfoRequest{})
+	assert.Equal(t, uint64(4), view.Version)
+	assert.Equal(t, 1, len(view.Shards))
+
+	// the lease holder is the primary even before it registers as one
+	now = now.Add(time.Second)
+	lease, _ := c.AcquireLease(ctx, &pb.LeaseRequest{Shard: "a", Holder: "a2", TtlMs: 1000, Renew: true})
+	assert.True(t, lease.Granted)
+	view, _ = c.ClusterInfo(ctx, &pb.ClusterInfoRequest{})
+	assert.Equal(t, "a2", view.Shards[0].Primary)
+	assert.Equal(t, []string{"a1"}, view.Shards[0].Replicas)
+}
+
+func TestServerClusterInfo(t *testing.T) {
+	c := newCoordinatorServer(time.Second)
+	s, err := NewServer(t.TempDir(), "a1", true, "", nil)
+	assert.Nil(t, err)
+	ctx := context.Background()
+
+	_, err = s.ClusterInfo(ctx, &pb.ClusterInfoRequest{})
+	assert.NotNil(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	grpcServer := grpc.NewServer()
+	pb.RegisterCoordinatorServer(grpcServer, c)
+	go grpcServer.Serve(listener)
+	defer grpcServer.Stop()
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	s.register(ctx, pb.NewCoordinatorClient(conn), "a", "", 1)
+	view, err := s.ClusterInfo(ctx, &pb.ClusterInfoRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(view.Shards))
+	assert.Equal(t, "a1", view.Shards[0].Primary)
+}
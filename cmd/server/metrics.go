@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// metrics 是服务端通过 /metrics 导出的 Prometheus 指标
+type metrics struct {
+	registry *prometheus.Registry
+	handled  *prometheus.CounterVec   // 按方法和 gRPC 错误码统计的请求数
+	latency  *prometheus.HistogramVec // 按方法统计的请求耗时
+}
+
+// newMetrics 创建服务端的指标，包括 gRPC 请求、存储引擎、复制延迟以及 Go 运行时和进程的指标
+func newMetrics(s *server) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		handled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "nodb_grpc_server_handled_total",
+			Help: "Number of gRPC requests completed by the server, by method and status code.",
+		}, []string{"method", "code"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "nodb_grpc_server_handling_seconds",
+			Help:    "Time taken by the server to complete gRPC requests, by method. Streams are observed when they end.",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		}, []string{"method"}),
+	}
+	m.registry.MustRegister(
+		m.handled,
+		m.latency,
+		&serverCollector{s: s},
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// observe 记录一个请求的耗时和结果
+func (m *metrics) observe(method string, start time.Time, err error) {
+	m.latency.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	m.handled.WithLabelValues(method, status.Code(err).String()).Inc()
+}
+
+// unaryInterceptor 统计一元请求，放在拦截器链的最前面以便统计被认证或路由拒绝的请求
+func (m *metrics) unaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		m.observe(info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+// streamInterceptor 统计流式请求，例如副本拉取日志
+func (m *metrics) streamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		m.observe(info.FullMethod, start, err)
+		return err
+	}
+}
+
+var (
+	enginePutsDesc          = prometheus.NewDesc("nodb_engine_puts_total", "Number of puts to the storage engine.", nil, nil)
+	engineGetsDesc          = prometheus.NewDesc("nodb_engine_gets_total", "Number of gets from the storage engine.", nil, nil)
+	engineDeletesDesc       = prometheus.NewDesc("nodb_engine_deletes_total", "Number of deletes from the storage engine.", nil, nil)
+	engineBytesWrittenDesc  = prometheus.NewDesc("nodb_engine_written_bytes_total", "Bytes of records appended to the log.", nil, nil)
+	engineRotationsDesc     = prometheus.NewDesc("nodb_engine_file_rotations_total", "Number of times the active data file was full and a new one was opened.", nil, nil)
+	engineMergesDesc        = prometheus.NewDesc("nodb_engine_merges_total", "Number of merges of the data files.", nil, nil)
+	engineMergeSecondsDesc  = prometheus.NewDesc("nodb_engine_merge_seconds_total", "Time spent merging the data files.", nil, nil)
+	engineKeysDesc          = prometheus.NewDesc("nodb_engine_keys", "Number of keys in the indexes of all the namespaces.", nil, nil)
+	engineDataFilesDesc     = prometheus.NewDesc("nodb_engine_data_files", "Number of data files, including the active one.", nil, nil)
+	primaryDesc             = prometheus.NewDesc("nodb_server_primary", "1 if the server accepts writes as the primary or raft leader, 0 otherwise.", nil, nil)
+	replicationLagBytesDesc = prometheus.NewDesc("nodb_replication_lag_bytes", "Bytes of log the replica has not acknowledged yet, reported by the primary.", []string{"replica"}, nil)
+)
+
+// serverCollector 在每次抓取时读取存储引擎的统计信息和各副本的复制进度
+type serverCollector struct {
+	s *server
+}
+
+func (c *serverCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- enginePutsDesc
+	ch <- engineGetsDesc
+	ch <- engineDeletesDesc
+	ch <- engineBytesWrittenDesc
+	ch <- engineRotationsDesc
+	ch <- engineMergesDesc
+	ch <- engineMergeSecondsDesc
+	ch <- engineKeysDesc
+	ch <- engineDataFilesDesc
+	ch <- primaryDesc
+	ch <- replicationLagBytesDesc
+}
+
+func (c *serverCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.s
+	st := s.db.Stats()
+	ch <- prometheus.MustNewConstMetric(enginePutsDesc, prometheus.CounterValue, float64(st.Puts))
+	ch <- prometheus.MustNewConstMetric(engineGetsDesc, prometheus.CounterValue, float64(st.Gets))
+	ch <- prometheus.MustNewConstMetric(engineDeletesDesc, prometheus.CounterValue, float64(st.Deletes))
+	ch <- prometheus.MustNewConstMetric(engineBytesWrittenDesc, prometheus.CounterValue, float64(st.BytesWritten))
+	ch <- prometheus.MustNewConstMetric(engineRotationsDesc, prometheus.CounterValue, float64(st.FileRotations))
+	ch <- prometheus.MustNewConstMetric(engineMergesDesc, prometheus.CounterValue, float64(st.Merges))
+	ch <- prometheus.MustNewConstMetric(engineMergeSecondsDesc, prometheus.CounterValue, st.MergeDuration.Seconds())
+	ch <- prometheus.MustNewConstMetric(engineKeysDesc, prometheus.GaugeValue, float64(st.Keys))
+	ch <- prometheus.MustNewConstMetric(engineDataFilesDesc, prometheus.GaugeValue, float64(st.DataFiles))
+
+	isPrimary, _ := s.primary()
+	if s.raftNode != nil {
+		isPrimary = s.raftNode.IsLeader()
+	}
+	primary := 0.0
+	if isPrimary {
+		primary = 1
+	}
+	ch <- prometheus.MustNewConstMetric(primaryDesc, prometheus.GaugeValue, primary)
+
+	// 只有主节点跟踪副本的复制进度，尚未确认过的副本从日志开头计算
+	if s.raftNode != nil || !isPrimary {
+		return
+	}
+	s.replicaMu.Lock()
+	s.expireReplicasLocked(time.Now())
+	lags := make(map[string]float64, len(s.replicas))
+	for id, progress := range s.replicas {
+		lags[id] = float64(s.db.LogLag(progress.applied))
+	}
+	s.replicaMu.Unlock()
+	for id, lag := range lags {
+		ch <- prometheus.MustNewConstMetric(replicationLagBytesDesc, prometheus.GaugeValue, lag, id)
+	}
+}
+
+// serveMetrics 在 addr 上通过 HTTP 导出 /metrics，返回的 http.Server 的 Addr 为实际监听的地址
+func serveMetrics(addr string, m *metrics, logger *zap.Logger) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry}))
+	srv := &http.Server{Addr: listener.Addr().String(), Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Failed to serve metrics", zap.Error(err))
+		}
+	}()
+	return srv, nil
+}
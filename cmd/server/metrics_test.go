@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	pb "github.com/sidneychang/no-db/proto"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestMetrics(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	s, err := NewServer(t.TempDir(), listener.Addr().String(), true, "", nil)
+	assert.Nil(t, err)
+	s.initReplicas("r1,r2")
+	m := newMetrics(s)
+	grpcServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(m.unaryInterceptor()),
+		grpc.ChainStreamInterceptor(m.streamInterceptor()))
+	pb.RegisterKVDBServer(grpcServer, s)
+	pb.RegisterReplicationServer(grpcServer, &replicationServer{s: s})
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.Nil(t, err)
+	t.Cleanup(func() { conn.Close() })
+	client := pb.NewKVDBClient(conn)
+	ctx := context.Background()
+
+	_, err = client.Put(ctx, &pb.PutRequest{Key: "a", Value: "1"})
+	assert.Nil(t, err)
+	// r1 has applied the first write, r2 nothing
+	first := s.db.LogPosition()
+	_, err = pb.NewReplicationClient(conn).Ack(ctx, &pb.AckRequest{ReplicaId: "r1", Applied: toLogPosition(first)})
+	assert.Nil(t, err)
+	_, err = client.Put(ctx, &pb.PutRequest{Key: "b", Value: "2"})
+	assert.Nil(t, err)
+	_, err = client.Get(ctx, &pb.GetRequest{Key: "a"})
+	assert.Nil(t, err)
+	_, err = client.Get(ctx, &pb.GetRequest{Key: "missing"})
+	assert.NotNil(t, err)
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.handled.WithLabelValues("/proto.KVDB/Put", "OK")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.handled.WithLabelValues("/proto.KVDB/Get", "OK")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.handled.WithLabelValues("/proto.KVDB/Get", "NotFound")))
+
+	metricsServer, err := serveMetrics("127.0.0.1:0", m, zap.NewNop())
+	assert.Nil(t, err)
+	t.Cleanup(func() { metricsServer.Close() })
+	resp, err := http.Get(fmt.Sprintf("http://%s/metrics", metricsServer.Addr))
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	text := string(body)
+
+	st := s.db.Stats()
+	secondPut := s.db.LogLag(first)
+	assert.Greater(t, secondPut, int64(0))
+	assert.Contains(t, text, `nodb_grpc_server_handling_seconds_count{method="/proto.KVDB/Put"} 2`)
+	assert.Contains(t, text, "nodb_engine_puts_total 2\n")
+	assert.Contains(t, text, "nodb_engine_gets_total 2\n")
+	assert.Contains(t, text, fmt.Sprintf("nodb_engine_written_bytes_total %d\n", st.BytesWritten))
+	assert.Contains(t, text, "nodb_engine_keys 2\n")
+	assert.Contains(t, text, "nodb_server_primary 1\n")
+	assert.Contains(t, text, fmt.Sprintf(`nodb_replication_lag_bytes{replica="r1"} %d`+"\n", secondPut))
+	assert.Contains(t, text, fmt.Sprintf(`nodb_replication_lag_bytes{replica="r2"} %d`+"\n", st.BytesWritten))
+	assert.Contains(t, text, "go_goroutines")
+}
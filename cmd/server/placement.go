@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	pb "github.com/sidneychang/no-db/proto"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// 写入键的其他副本时携带该请求头，收到的分片只在本地写入，不再写入其他副本
+const replicaWriteHeader = "x-kvdb-replica-write"
+
+// 达到多数后，仍在进行的副本写入最多再等待的时间
+const replicaWriteTimeout = 5 * time.Second
+
+// replicateWrite 在本地执行写请求，并写入保存该键的其他分片。
+// 包括本地在内多数分片写入成功后返回，其余分片的写入在后台继续进行
+func (s *server) replicateWrite(ctx context.Context, md metadata.MD, method string, req interface{}, owners []*pb.Shard, handler grpc.UnaryHandler) (interface{}, error) {
+	resp, err := handler(ctx, req)
+	if err != nil || len(owners) == 1 {
+		return resp, err
+	}
+
+	outgoing := metadata.Pairs(replicaWriteHeader, s.nodeID)
+	if token := md.Get(authorizationHeader); len(token) > 0 {
+		outgoing.Set(authorizationHeader, token...)
+	}
+	// 返回后请求的 ctx 会被取消，副本写入使用单独的超时
+	peerCtx, cancel := context.WithTimeout(metadata.NewOutgoingContext(context.WithoutCancel(ctx), outgoing), replicaWriteTimeout)
+	results := make(chan error, len(owners))
+	pending := 0
+	for _, shard := range owners {
+		if shard.Name == s.shard {
+			continue
+		}
+		pending++
+		go func(shard *pb.Shard) {
+			results <- s.writeReplica(peerCtx, shard, method, req)
+		}(shard)
+	}
+
+	stored, quorum := 1, len(owners)/2+1
+	var lastErr error
+	for ; pending > 0 && stored < quorum; pending-- {
+		select {
+		case err := <-results:
+			if err != nil {
+				s.logger().Warn("Failed to write the replica on shard", zap.Error(err))
+				lastErr = err
+				continue
+			}
+			stored++
+		case <-ctx.Done():
+			cancel()
+			return nil, ctx.Err()
+		}
+	}
+	go func() {
+		defer cancel()
+		for ; pending > 0; pending-- {
+			if err := <-results; err != nil {
+				s.logger().Warn("Failed to write the replica on shard", zap.Error(err))
+			}
+		}
+	}()
+	if stored < quorum {
+		return nil, status.Errorf(codes.Unavailable, "write stored on %d of %d shards, %d required: %v", stored, len(owners), quorum, lastErr)
+	}
+	return resp, nil
+}
+
+// writeReplica 将写请求发送给分片的主节点
+func (s *server) writeReplica(ctx context.Context, shard *pb.Shard, method string, req interface{}) error {
+	if shard.Primary == "" {
+		return status.Errorf(codes.Unavailable, "shard %s has no primary", shard.Name)
+	}
+	conn, err := s.peerConn(shard.Primary)
+	if err != nil {
+		return err
+	}
+	if err := conn.Invoke(ctx, method, req, &pb.Empty{}); err != nil {
+		return status.Errorf(status.Code(err), "shard %s: %v", shard.Name, err)
+	}
+	return nil
+}
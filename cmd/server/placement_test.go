@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	pb "github.com/sidneychang/no-db/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestReplicatedWrites(t *testing.T) {
+	a, clientA := startRoutingServer(t, "a", routeForward)
+	b, clientB := startRoutingServer(t, "b", routeForward)
+	// c is on the ring but cannot be reached
+	view := &pb.ClusterInfoResponse{Version: 1, VirtualNodes: 100, ReplicationFactor: 2, Shards: []*pb.Shard{
+		{Name: "a", Primary: a.nodeID},
+		{Name: "b", Primary: b.nodeID},
+		{Name: "c", Primary: "127.0.0.1:1"},
+	}}
+	a.setClusterInfo(view)
+	b.setClusterInfo(view)
+
+	// find a key stored on a and b, and one stored on a and c
+	var keyAB, keyAC string
+	for i := 0; keyAB == "" || keyAC == ""; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		owners := a.ring.GetN(key, 2)
+		if contains(owners, "a") && contains(owners, "b") {
+			keyAB = key
+		} else if contains(owners, "a") && contains(owners, "c") {
+			keyAC = key
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the shard receiving the write stores it on the other owner too
+	_, err := clientB.Put(ctx, &pb.PutRequest{Key: keyAB, Value: "v"})
+	assert.Nil(t, err)
+	for _, s := range []*server{a, b} {
+		value, err := s.db.Get([]byte(keyAB))
+		assert.Nil(t, err)
+		assert.Equal(t, "v", string(value))
+	}
+	resp, err := clientA.Get(ctx, &pb.GetRequest{Key: keyAB})
+	assert.Nil(t, err)
+	assert.Equal(t, "v", resp.Value)
+	_, err = clientA.Delete(ctx, &pb.DeleteRequest{Key: keyAB})
+	assert.Nil(t, err)
+	for _, s := range []*server{a, b} {
+		_, err := s.db.Get([]byte(keyAB))
+		assert.NotNil(t, err)
+	}
+
+	// without c the write misses the majority, it is not rolled back on a
+	_, err = clientA.Put(ctx, &pb.PutRequest{Key: keyAC, Value: "v"})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	_, err = a.db.Get([]byte(keyAC))
+	assert.Nil(t, err)
+}
+
+func TestReplicatedRebalance(t *testing.T) {
+	a, _ := startRoutingServer(t, "a", routeForward)
+	b, _ := startRoutingServer(t, "b", routeForward)
+	for i := 0; i < 300; i++ {
+		assert.Nil(t, a.db.Put([]byte(fmt.Sprintf("key-%d", i)), []byte("old")))
+	}
+	assert.Nil(t, b.db.Put([]byte("key-0"), []byte("new")))
+	a.setClusterInfo(&pb.ClusterInfoResponse{Version: 1, VirtualNodes: 100, ReplicationFactor: 2, Shards: []*pb.Shard{{Name: "a", Primary: a.nodeID}}})
+
+	// with two shards and two replicas b stores every key, and a keeps them
+	view := &pb.ClusterInfoResponse{Version: 2, VirtualNodes: 100, ReplicationFactor: 2, Shards: []*pb.Shard{
+		{Name: "a", Primary: a.nodeID},
+		{Name: "b", Primary: b.nodeID},
+	}}
+	a.setClusterInfo(view)
+	prevRing, _ := b.setClusterInfo(view)
+	b.startRebalance(prevRing, view)
+	assert.Eventually(t, func() bool {
+		b.handoffMu.Lock()
+		defer b.handoffMu.Unlock()
+		return b.handoff == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, 300, keyCount(t, a))
+	assert.Equal(t, 300, keyCount(t, b))
+	// a key b already had is kept
+	value, err := b.db.Get([]byte("key-0"))
+	assert.Nil(t, err)
+	assert.Equal(t, "new", string(value))
+}
+
+func TestCoordinatorZonesAndWeights(t *testing.T) {
+	c := newCoordinatorServer(time.Second)
+	c.view.ReplicationFactor = 2
+	ctx := context.Background()
+	for _, req := range []*pb.RegisterRequest{
+		{Address: "a1", Shard: "a", Primary: true, Zone: "east", Weight: 4},
+		{Address: "a2", Shard: "a", Zone: "west"},
+		{Address: "b2", Shard: "b", Zone: "west"},
+	} {
+		req.TtlMs = 3000
+		_, err := c.Register(ctx, req)
+		assert.Nil(t, err)
+	}
+	view, _ := c.ClusterInfo(ctx, &pb.ClusterInfoRequest{})
+	assert.Equal(t, uint32(2), view.ReplicationFactor)
+	assert.Equal(t, "east", view.Shards[0].Zone)
+	// a shard without a primary takes the zone of a member
+	assert.Equal(t, "west", view.Shards[1].Zone)
+
+	// a shard gets virtual nodes in proportion to the weight of its primary
+	assert.Equal(t, uint32(4), view.Shards[0].Weight)
+	points := buildRing(view, "").Points()
+	assert.Equal(t, 500, len(points))
+	count := 0
+	for _, p := range points {
+		if p.Node == "a" {
+			count++
+		}
+	}
+	assert.Equal(t, 400, count)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	pb "github.com/sidneychang/no-db/proto"
+	"github.com/sidneychang/no-db/raftgroup"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// startRaft 启动 raft 组成员，写操作通过 raft 日志复制到组内所有节点
+func (s *server) startRaft(raftAddr string, raftAdvertise string, peers string, bootstrap bool) error {
+	node, err := raftgroup.NewNode(raftgroup.Config{
+		ID:        s.nodeID,
+		BindAddr:  raftAddr,
+		Advertise: raftAdvertise,
+		Dir:       raftgroup.DefaultDir(s.pathdir),
+		Logger:    s.log,
+	}, s.db)
+	if err != nil {
+		return err
+	}
+	if bootstrap {
+		members, err := raftgroup.ParsePeers(peers)
+		if err != nil {
+			return err
+		}
+		if len(members) == 0 {
+			members = []raftgroup.Peer{{ID: s.nodeID, Addr: node.Addr()}}
+		}
+		if err := node.Bootstrap(members); err != nil {
+			return err
+		}
+	}
+	s.raftNode = node
+	s.logger().Info("Raft member listening", zap.String("id", s.nodeID), zap.String("addr", node.Addr()))
+	return nil
+}
+
+// proposeToRaft 通过 raft 提交写操作，非 Leader 节点返回当前 Leader 的地址
+func (s *server) proposeToRaft(ctx context.Context, record *pb.LogRecord) (err error) {
+	_, span := tracer.Start(ctx, "raft.apply")
+	defer func() { endSpan(span, err) }()
+	err = s.raftNode.Apply(record)
+	if errors.Is(err, raftgroup.ErrNotLeader) {
+		return status.Errorf(codes.FailedPrecondition, "not the raft leader, current leader is %q", s.raftNode.Leader())
+	}
+	return err
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/sidneychang/no-db/consistenthash"
+	pb "github.com/sidneychang/no-db/proto"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	handoffBatchSize     = 128         // 每批移交的键值对数量
+	handoffRetryInterval = time.Second // 移交失败后的重试间隔
+)
+
+// 新的所属分片向原所属分片读取尚未移交的键时携带该请求头，原所属分片直接读取本地数据
+const handoffReadHeader = "x-kvdb-handoff-read"
+
+// handoff 是拓扑变化后本节点从其他分片取回所属键的过程。
+// 哈希环切换后写请求立即路由到本节点，移交期间写入的键不会被移交的旧数据覆盖；
+// 本地读不到的键从原所属分片读取。原所属分片只在本节点确认已保存一批键后才删除它们，
+// 因此移交中断后重新开始时只会移交剩余的键。
+type handoff struct {
+	cancel   context.CancelFunc
+	prevRing *consistenthash.HashRing // 拓扑变化前的哈希环
+	pending  map[string]bool          // 尚未完成移交的分片
+	written  map[string]struct{}      // 移交开始后本地写入或删除的键，由 merkleKey 编码
+}
+
+// startRebalance 在拓扑变化后按迁移计划从原所属分片取回本分片新获得的键，
+// 并取消之前未完成的移交，未完成的分片会在这次移交中继续。
+// prevRing 为 nil 时本节点刚加入集群，按没有本分片的哈希环查找键原来的所属分片。
+func (s *server) startRebalance(prevRing *consistenthash.HashRing, view *pb.ClusterInfoResponse) {
+	s.handoffMu.Lock()
+	defer s.handoffMu.Unlock()
+	written := make(map[string]struct{})
+	sources := make(map[string]bool)
+	if old := s.handoff; old != nil {
+		old.cancel()
+		// 上一次移交期间写入的键同样比其他分片上的数据新
+		written = old.written
+		for source := range old.pending {
+			sources[source] = true
+		}
+	}
+	s.handoff = nil
+	if isPrimary, _ := s.primary(); !isPrimary {
+		return
+	}
+	if prevRing == nil {
+		prevRing = buildRing(view, s.shard)
+	}
+
+	if replicationFactor(view) > 1 {
+		// 键保存在多个分片上时，本分片新保存的键可能在任一分片上
+		for _, shard := range view.Shards {
+			sources[shard.Name] = true
+		}
+	} else {
+		for _, move := range consistenthash.PlanMigration(prevRing.Points(), buildRing(view, "").Points()) {
+			if move.To == s.shard {
+				sources[move.From] = true
+			}
+		}
+	}
+	// 已经离开集群的分片无法再移交数据
+	present := make(map[string]bool)
+	for _, shard := range view.Shards {
+		present[shard.Name] = shard.Name != s.shard
+	}
+	for source := range sources {
+		if !present[source] {
+			delete(sources, source)
+		}
+	}
+	if len(sources) == 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	h := &handoff{cancel: cancel, prevRing: prevRing, pending: sources, written: written}
+	s.handoff = h
+	for source := range sources {
+		go s.runHandoff(ctx, h, source, view.Version)
+	}
+}
+
+// runHandoff 从指定分片取回键，失败时重试，直到完成或拓扑再次变化
+func (s *server) runHandoff(ctx context.Context, h *handoff, source string, version uint64) {
+	for {
+		moved, err := s.pullHandoff(ctx, h, source, version)
+		if err == nil {
+			if moved > 0 {
+				s.logger().Info("Took over keys", zap.Int("keys", moved), zap.String("shard", source))
+			}
+			break
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		s.logger().Warn("Handoff failed, retrying", zap.String("shard", source), zap.Int("keys", moved), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(handoffRetryInterval):
+		}
+	}
+
+	s.handoffMu.Lock()
+	defer s.handoffMu.Unlock()
+	delete(h.pending, source)
+	if len(h.pending) == 0 && s.handoff == h {
+		s.logger().Info("Rebalancing finished", zap.Uint64("version", version))
+		h.cancel()
+		s.handoff = nil
+	}
+}
+
+// pullHandoff 执行一次移交，返回已取回的键的数量
+func (s *server) pullHandoff(ctx context.Context, h *handoff, source string, version uint64) (int, error) {
+	addr := s.shardPrimary(source)
+	if addr == "" {
+		return 0, fmt.Errorf("shard %s has no primary", source)
+	}
+	conn, err := grpc.Dial(addr, s.peerDialOptions()...)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := pb.NewReplicationClient(conn).Handoff(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if err := stream.Send(&pb.HandoffAck{Shard: s.shard, Version: version}); err != nil {
+		return 0, err
+	}
+
+	moved := 0
+	for {
+		batch, err := stream.Recv()
+		if err == io.EOF {
+			return moved, nil
+		}
+		if err != nil {
+			return moved, err
+		}
+		stored, err := s.applyHandoff(h, batch.Entries, s.replicated())
+		if err != nil {
+			return moved, err
+		}
+		if err := stream.Send(&pb.HandoffAck{Batch: batch.Batch, Digest: handoffDigest(stored)}); err != nil {
+			return moved, err
+		}
+		moved += len(batch.Entries)
+	}
+}
+
+// applyHandoff 保存移交的键值对，并返回读回的值用于校验。
+// 移交开始后本地写入过的键保留本地的值，按收到的值参与校验；
+// keepExisting 为 true 时本地已有的键同样保留，键保存在多个分片上时会从多个分片收到同一个键。
+func (s *server) applyHandoff(h *handoff, entries []*pb.KeyValue, keepExisting bool) ([]*pb.KeyValue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := make([]*pb.KeyValue, 0, len(entries))
+	for _, kv := range entries {
+		s.handoffMu.Lock()
+		_, written := h.written[string(merkleKey(kv.Namespace, kv.Key))]
+		s.handoffMu.Unlock()
+		if written {
+			stored = append(stored, kv)
+			continue
+		}
+		ns := s.db.Namespace(kv.Namespace)
+		if _, err := ns.Get(kv.Key); keepExisting && err == nil {
+			stored = append(stored, kv)
+			continue
+		}
+		if err := ns.Put(kv.Key, kv.Value); err != nil {
+			return nil, err
+		}
+		value, err := ns.Get(kv.Key)
+		if err != nil {
+			return nil, err
+		}
+		stored = append(stored, &pb.KeyValue{Namespace: kv.Namespace, Key: kv.Key, Value: value})
+	}
+	return stored, nil
+}
+
+// noteWrite 在移交期间记录本地写入或删除的键
+// hold s.mu before accessing this method
+func (s *server) noteWrite(namespace string, key string) {
+	s.handoffMu.Lock()
+	defer s.handoffMu.Unlock()
+	if s.handoff != nil {
+		s.handoff.written[string(merkleKey(namespace, []byte(key)))] = struct{}{}
+	}
+}
+
+// readThrough 在移交期间从原所属分片读取本地还没有的键
+func (s *server) readThrough(ctx context.Context, req *pb.GetRequest) (*pb.GetResponse, bool) {
+	s.handoffMu.Lock()
+	h := s.handoff
+	var source string
+	if h != nil && h.prevRing != nil {
+		if _, written := h.written[string(merkleKey(req.Namespace, []byte(req.Key)))]; !written {
+			source = h.prevRing.Get(req.Key)
+		}
+	}
+	s.handoffMu.Unlock()
+	if source == "" || source == s.shard {
+		return nil, false
+	}
+	addr := s.shardPrimary(source)
+	if addr == "" {
+		return nil, false
+	}
+	conn, err := s.peerConn(addr)
+	if err != nil {
+		return nil, false
+	}
+	outgoing := metadata.Pairs(handoffReadHeader, s.nodeID)
+	if md, _ := metadata.FromIncomingContext(ctx); len(md.Get(authorizationHeader)) > 0 {
+		outgoing.Set(authorizationHeader, md.Get(authorizationHeader)...)
+	}
+	resp, err := pb.NewKVDBClient(conn).Get(metadata.NewOutgoingContext(ctx, outgoing), req)
+	if err != nil {
+		return nil, false
+	}
+	return resp, true
+}
+
+// shardPrimary 返回当前拓扑中分片的主节点地址
+func (s *server) shardPrimary(name string) string {
+	s.clusterMu.RLock()
+	defer s.clusterMu.RUnlock()
+	if s.cluster == nil {
+		return ""
+	}
+	for _, shard := range s.cluster.Shards {
+		if shard.Name == name {
+			return shard.Primary
+		}
+	}
+	return ""
+}
+
+// Handoff 方法：将属于请求方分片的键分批移交给请求方，
+// 请求方确认的摘要与发送的数据一致后才删除本分片不再保存的键
+func (r *replicationServer) Handoff(stream pb.Replication_HandoffServer) error {
+	s := r.s
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if isPrimary, _ := s.primary(); !isPrimary {
+		return status.Errorf(codes.FailedPrecondition, "not the Primary server")
+	}
+	s.clusterMu.RLock()
+	var version uint64
+	ring, replicas := s.ring, 1
+	if s.cluster != nil {
+		version, replicas = s.cluster.Version, replicationFactor(s.cluster)
+	}
+	s.clusterMu.RUnlock()
+	if ring == nil || version != first.Version {
+		return status.Errorf(codes.FailedPrecondition, "topology version %d differs from %d", version, first.Version)
+	}
+
+	// 迭代器遍历的是索引的快照，并在关闭前固定数据文件，扫描时不持有 s.mu，每凑满一批就发送，
+	// 内存中最多保存一批键值对，扫描期间本节点的写入不受影响
+	var seq uint64
+	send := func(batch []*pb.KeyValue) error {
+		if err := stream.Send(&pb.HandoffBatch{Batch: seq, Entries: batch}); err != nil {
+			return err
+		}
+		ack, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if ack.Batch != seq || !bytes.Equal(ack.Digest, handoffDigest(batch)) {
+			return status.Errorf(codes.DataLoss, "shard %s stored batch %d differently, keeping the keys", first.Shard, seq)
+		}
+		seq++
+		var handedOff []*pb.KeyValue
+		for _, kv := range batch {
+			if !contains(ring.GetN(string(kv.Key), replicas), s.shard) {
+				handedOff = append(handedOff, kv)
+			}
+		}
+		return s.deleteHandedOff(handedOff)
+	}
+	var batch []*pb.KeyValue
+	err = scanBuckets(s.db, 0, nil, func(namespace string, key []byte, value []byte) error {
+		if !contains(ring.GetN(string(key), replicas), first.Shard) {
+			return nil
+		}
+		batch = append(batch, &pb.KeyValue{Namespace: namespace, Key: key, Value: value})
+		if len(batch) < handoffBatchSize {
+			return nil
+		}
+		err := send(batch)
+		batch = nil
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if len(batch) > 0 {
+		if err := send(batch); err != nil {
+			return err
+		}
+	}
+
+	if s.leaving() {
+		count, err := s.keyCount()
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			s.logger().Info("Shard has handed off all its keys and can be stopped", zap.String("shard", s.shard))
+		}
+	}
+	return nil
+}
+
+// replicated 返回当前拓扑中每个键是否保存在多个分片上
+func (s *server) replicated() bool {
+	s.clusterMu.RLock()
+	defer s.clusterMu.RUnlock()
+	return s.cluster != nil && replicationFactor(s.cluster) > 1
+}
+
+func contains(list []string, item string) bool {
+	for _, v := range list {
+		if v == item {
+			return true
+		}
+	}
+	return false
+}
+
+// keyCount 返回所有命名空间中键的数量，扫描失败时返回错误，
+// 避免把只扫描了一部分的分片当作已经移交完毕
+func (s *server) keyCount() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := 0
+	err := scanBuckets(s.db, 0, nil, func(namespace string, key []byte, value []byte) error {
+		count++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// leaving 返回本节点所在分片是否已下线
+func (s *server) leaving() bool {
+	s.clusterMu.RLock()
+	defer s.clusterMu.RUnlock()
+	if s.cluster == nil {
+		return false
+	}
+	for _, shard := range s.cluster.Shards {
+		if shard.Name == s.shard {
+			return shard.Leaving
+		}
+	}
+	return false
+}
+
+// deleteHandedOff 删除已移交的键，发送后又被修改的键保留
+func (s *server) deleteHandedOff(entries []*pb.KeyValue) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, kv := range entries {
+		ns := s.db.Namespace(kv.Namespace)
+		if value, err := ns.Get(kv.Key); err != nil || !bytes.Equal(value, kv.Value) {
+			continue
+		}
+		if err := ns.Delete(kv.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handoffDigest 计算一批键值对的摘要
+func handoffDigest(entries []*pb.KeyValue) []byte {
+	h := sha256.New()
+	var buf [binary.MaxVarintLen64]byte
+	for _, kv := range entries {
+		for _, field := range [][]byte{[]byte(kv.Namespace), kv.Key, kv.Value} {
+			n := binary.PutUvarint(buf[:], uint64(len(field)))
+			h.Write(buf[:n])
+			h.Write(field)
+		}
+	}
+	return h.Sum(nil)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/sidneychang/no-db/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestRebalance(t *testing.T) {
+	a, _ := startRoutingServer(t, "a", routeForward)
+	b, clientB := startRoutingServer(t, "b", routeForward)
+	for i := 0; i < 300; i++ {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		assert.Nil(t, a.db.Put(key, []byte("old")))
+		assert.Nil(t, a.db.Namespace("users").Put(key, []byte("old")))
+	}
+	a.setClusterInfo(&pb.ClusterInfoResponse{Version: 1, VirtualNodes: 100, Shards: []*pb.Shard{{Name: "a", Primary: a.nodeID}}})
+
+	// b joins the cluster
+	view := &pb.ClusterInfoResponse{Version: 2, VirtualNodes: 100, Shards: []*pb.Shard{
+		{Name: "a", Primary: a.nodeID},
+		{Name: "b", Primary: b.nodeID},
+	}}
+	prevRing, _ := a.setClusterInfo(view)
+	a.startRebalance(prevRing, view)
+	prevRing, _ = b.setClusterInfo(view)
+	assert.Nil(t, prevRing)
+
+	var moved []string
+	for i := 0; i < 300; i++ {
+		if key := fmt.Sprintf("key-%d", i); b.ring.Get(key) == "b" {
+			moved = append(moved, key)
+		}
+	}
+	assert.NotEmpty(t, moved)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// before the keys are handed off, b reads them from a
+	b.handoff = &handoff{cancel: func() {}, prevRing: buildRing(view, "b"), written: make(map[string]struct{})}
+	resp, err := clientB.Get(ctx, &pb.GetRequest{Key: moved[0]})
+	assert.Nil(t, err)
+	assert.Equal(t, "old", resp.Value)
+
+	// a write to b during the handoff is newer than the copy on a
+	_, err = clientB.Put(ctx, &pb.PutRequest{Key: moved[0], Value: "new"})
+	assert.Nil(t, err)
+	b.startRebalance(nil, view)
+	assert.Eventually(t, func() bool {
+		b.handoffMu.Lock()
+		defer b.handoffMu.Unlock()
+		return b.handoff == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	for _, key := range moved {
+		value, err := b.db.Get([]byte(key))
+		assert.Nil(t, err)
+		if key == moved[0] {
+			assert.Equal(t, "new", string(value))
+		} else {
+			assert.Equal(t, "old", string(value))
+		}
+		value, err = b.db.Namespace("users").Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, "old", string(value))
+		_, err = a.db.Get([]byte(key))
+		assert.NotNil(t, err)
+		_, err = a.db.Namespace("users").Get([]byte(key))
+		assert.NotNil(t, err)
+	}
+	assert.Equal(t, 2*(300-len(moved)), keyCount(t, a))
+	assert.Equal(t, 2*len(moved), keyCount(t, b))
+}
+
+func TestCoordinatorDecommission(t *testing.T) {
+	c := newCoordinatorServer(time.Second)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	ctx := context.Background()
+	_, err := c.Register(ctx, &pb.RegisterRequest{Address: "a1", Shard: "a", Primary: true, TtlMs: 1000})
+	assert.Nil(t, err)
+
+	_, err = c.Decommission(ctx, &pb.DecommissionRequest{Shard: "b"})
+	assert.NotNil(t, err)
+	view, err := c.Decommission(ctx, &pb.DecommissionRequest{Shard: "a"})
+	assert.Nil(t, err)
+	assert.True(t, view.Shards[0].Leaving)
+	assert.Equal(t, 0, len(buildRing(view, "").Get("key")))
+
+	// once its servers are gone, a shard with the same name joins again
+	now = now.Add(2 * time.Second)
+	_, err = c.Register(ctx, &pb.RegisterRequest{Address: "a1", Shard: "a", Primary: true, TtlMs: 1000})
+	assert.Nil(t, err)
+	view, _ = c.ClusterInfo(ctx, &pb.ClusterInfoRequest{})
+	assert.False(t, view.Shards[0].Leaving)
+}
+
+func keyCount(t *testing.T, s *server) int {
+	count, err := s.keyCount()
+	assert.Nil(t, err)
+	return count
+}
+
+func TestHandoff_Batches(t *testing.T) {
+	a, clientA := startRoutingServer(t, "a", routeForward)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, a.db.Put([]byte(fmt.Sprintf("key-%d", i)), []byte("old")))
+	}
+	view := &pb.ClusterInfoResponse{Version: 2, VirtualNodes: 100, Shards: []*pb.Shard{
+		{Name: "a", Primary: a.nodeID},
+		{Name: "b", Primary: "b:1"},
+	}}
+	a.setClusterInfo(view)
+	owned, kept := 0, ""
+	for i := 0; i < 1000; i++ {
+		if key := fmt.Sprintf("key-%d", i); a.ring.Get(key) == "b" {
+			owned++
+		} else {
+			kept = key
+		}
+	}
+	assert.Greater(t, owned, handoffBatchSize)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := grpc.Dial(a.nodeID, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.Nil(t, err)
+	defer conn.Close()
+	stream, err := pb.NewReplicationClient(conn).Handoff(ctx)
+	assert.Nil(t, err)
+	assert.Nil(t, stream.Send(&pb.HandoffAck{Shard: "b", Version: 2}))
+
+	received := 0
+	for seq := uint64(0); ; seq++ {
+		batch, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, seq, batch.Batch)
+		assert.LessOrEqual(t, len(batch.Entries), handoffBatchSize)
+		received += len(batch.Entries)
+		if seq == 0 {
+			// the source keeps serving writes while a batch is in flight
+			_, err := clientA.Put(ctx, &pb.PutRequest{Key: kept, Value: "new"})
+			assert.Nil(t, err)
+		}
+		assert.Nil(t, stream.Send(&pb.HandoffAck{Batch: seq, Digest: handoffDigest(batch.Entries)}))
+	}
+	assert.Equal(t, owned, received)
+	assert.Equal(t, 1000-owned, keyCount(t, a))
+}
+
+func TestHandoff_ConcurrentPuts(t *testing.T) {
+	a, _ := startRoutingServer(t, "a", routeForward)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, a.db.Put([]byte(fmt.Sprintf("key-%d", i)), []byte("old")))
+	}
+	view := &pb.ClusterInfoResponse{Version: 2, VirtualNodes: 100, Shards: []*pb.Shard{
+		{Name: "a", Primary: a.nodeID},
+		{Name: "b", Primary: "b:1"},
+	}}
+	a.setClusterInfo(view)
+
+	// the scan runs without s.mu while the source keeps taking writes
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				assert.Nil(t, a.db.Put([]byte(fmt.Sprintf("new-%d-%d", w, i)), []byte("new")))
+			}
+		}(w)
+	}
+	defer func() {
+		close(done)
+		wg.Wait()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, err := grpc.Dial(a.nodeID, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.Nil(t, err)
+	defer conn.Close()
+	stream, err := pb.NewReplicationClient(conn).Handoff(ctx)
+	assert.Nil(t, err)
+	assert.Nil(t, stream.Send(&pb.HandoffAck{Shard: "b", Version: 2}))
+	for seq := uint64(0); ; seq++ {
+		batch, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if !assert.Nil(t, err) {
+			return
+		}
+		assert.Nil(t, stream.Send(&pb.HandoffAck{Batch: seq, Digest: handoffDigest(batch.Entries)}))
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/sidneychang/no-db/proto"
+	"github.com/sidneychang/no-db/tracing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// 副本请求携带的身份、时间戳、随机数和签名
+	replicationIDHeader        = "x-replication-id"
+	replicationTimeHeader      = "x-replication-time"
+	replicationNonceHeader     = "x-replication-nonce"
+	replicationSignatureHeader = "x-replication-signature"
+	// 主节点在响应头中返回的证明，副本据此确认对端持有相同的密钥
+	replicationProofHeader = "x-replication-proof"
+	// 允许的时钟偏差，超出范围的签名视为过期
+	replicationTokenSkew = 30 * time.Second
+)
+
+var errReplicationAuth = errors.New("replication authentication failed")
+
+// replicationAuth 使用共享密钥对复制流量进行双向认证：
+// 副本用 HMAC 对每个请求签名，主节点用同一密钥对请求中的随机数签名作为应答，
+// 因此只有持有密钥的主节点能向副本发送数据，也只有持有密钥的副本能拉取数据和确认位置。
+// 签名只认证建立连接的双方，不覆盖之后传输的记录，中间人仍然可以篡改或注入记录，
+// 跨不可信的网络复制时必须同时启用 TLS。
+type replicationAuth struct {
+	key    []byte
+	nodeID string
+	now    func() time.Time
+
+	mu     sync.Mutex
+	seen   map[string]time.Time // 时间窗口内已使用的随机数，防止重放
+	expiry []usedNonce          // seen 中的随机数，按使用时间排序，过期后从头部移除
+}
+
+type usedNonce struct {
+	nonce string
+	at    time.Time
+}
+
+type replicaIDKey struct{}
+
+// loadReplicationAuth 从文件中读取共享密钥
+func loadReplicationAuth(keyFile string, nodeID string) (*replicationAuth, error) {
+	content, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key := []byte(strings.TrimSpace(string(content)))
+	if len(key) < 16 {
+		return nil, errors.New("replication key must be at least 16 bytes")
+	}
+	return newReplicationAuth(key, nodeID), nil
+}
+
+func newReplicationAuth(key []byte, nodeID string) *replicationAuth {
+	return &replicationAuth{
+		key:    key,
+		nodeID: nodeID,
+		now:    time.Now,
+		seen:   make(map[string]time.Time),
+	}
+}
+
+func (a *replicationAuth) sign(parts ...string) string {
+	mac := hmac.New(sha256.New, a.key)
+	for _, part := range parts {
+		mac.Write([]byte(part))
+		mac.Write([]byte{0})
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// token 生成副本调用 method 时携带的签名
+func (a *replicationAuth) token(method string) (metadata.MD, string) {
+	nonce := make([]byte, 16)
+	_, _ = rand.Read(nonce)
+	nonceStr := hex.EncodeToString(nonce)
+	ts := strconv.FormatInt(a.now().UnixMilli(), 10)
+	return metadata.Pairs(
+		replicationIDHeader, a.nodeID,
+		replicationTimeHeader, ts,
+		replicationNonceHeader, nonceStr,
+		replicationSignatureHeader, a.sign("replica", method, a.nodeID, ts, nonceStr),
+	), nonceStr
+}
+
+// verifyToken 校验副本请求的签名，返回副本 id 和请求中的随机数
+func (a *replicationAuth) verifyToken(method string, md metadata.MD) (string, string, error) {
+	get := func(key string) string {
+		if values := md.Get(key); len(values) == 1 {
+			return values[0]
+		}
+		return ""
+	}
+	id, ts, nonce, signature := get(replicationIDHeader), get(replicationTimeHeader), get(replicationNonceHeader), get(replicationSignatureHeader)
+	if id == "" || nonce == "" || signature == "" {
+		return "", "", errReplicationAuth
+	}
+	if !hmac.Equal([]byte(signature), []byte(a.sign("replica", method, id, ts, nonce))) {
+		return "", "", errReplicationAuth
+	}
+	millis, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return "", "", errReplicationAuth
+	}
+	now := a.now()
+	issued := time.UnixMilli(millis)
+	if issued.Before(now.Add(-replicationTokenSkew)) || issued.After(now.Add(replicationTokenSkew)) {
+		return "", "", errReplicationAuth
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	cutoff := now.Add(-2 * replicationTokenSkew)
+	for len(a.expiry) > 0 && a.expiry[0].at.Before(cutoff) {
+		delete(a.seen, a.expiry[0].nonce)
+		a.expiry = a.expiry[1:]
+	}
+	if _, ok := a.seen[nonce]; ok {
+		return "", "", errReplicationAuth
+	}
+	a.seen[nonce] = now
+	a.expiry = append(a.expiry, usedNonce{nonce: nonce, at: now})
+	return id, nonce, nil
+}
+
+// proof 生成主节点对请求随机数的应答
+func (a *replicationAuth) proof(method string, nonce string) string {
+	return a.sign("primary", method, nonce)
+}
+
+// verifyProof 校验主节点的应答
+func (a *replicationAuth) verifyProof(method string, nonce string, header metadata.MD) error {
+	values := header.Get(replicationProofHeader)
+	if len(values) != 1 || !hmac.Equal([]byte(values[0]), []byte(a.proof(method, nonce))) {
+		return status.Errorf(codes.Unauthenticated, "%v: primary did not prove the replication key", errReplicationAuth)
+	}
+	return nil
+}
+
+func isReplicationMethod(method string) bool {
+	return strings.HasPrefix(method, "/"+pb.Replication_ServiceDesc.ServiceName+"/")
+}
+
+// signed 判断请求是否带有集群节点的签名。Replication 以外的服务中，
+// 节点之间的调用（转发、写入副本、访问协调节点等）同样带有签名
+func signed(ctx context.Context) bool {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return len(md.Get(replicationSignatureHeader)) > 0
+}
+
+// isPeer 判断请求是否来自通过认证的集群节点
+func isPeer(ctx context.Context) bool {
+	_, ok := ctx.Value(replicaIDKey{}).(string)
+	return ok
+}
+
+// authenticate 校验请求签名，并在上下文中记录通过认证的节点 id
+func (a *replicationAuth) authenticate(ctx context.Context, method string) (context.Context, metadata.MD, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	id, nonce, err := a.verifyToken(method, md)
+	if err != nil {
+		return nil, nil, status.Errorf(codes.Unauthenticated, "%v", err)
+	}
+	return context.WithValue(ctx, replicaIDKey{}, id), metadata.Pairs(replicationProofHeader, a.proof(method, nonce)), nil
+}
+
+// UnaryServerInterceptor 认证 Replication 服务的一元调用以及其他服务中带有签名的调用
+func (a *replicationAuth) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !isReplicationMethod(info.FullMethod) && !signed(ctx) {
+			return handler(ctx, req)
+		}
+		ctx, header, err := a.authenticate(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		if err := grpc.SetHeader(ctx, header); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+// StreamServerInterceptor 认证 Replication 服务的流式调用以及其他服务中带有签名的调用，
+// 并立即发送应答，使副本在收到第一条记录之前就能确认主节点的身份
+func (a *replicationAuth) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !isReplicationMethod(info.FullMethod) && !signed(ss.Context()) {
+			return handler(srv, ss)
+		}
+		ctx, header, err := a.authenticate(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		if err := ss.SendHeader(header); err != nil {
+			return err
+		}
+		return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// withToken 将签名加入请求头，保留转发的 token 等已有的请求头
+func withToken(ctx context.Context, token metadata.MD) context.Context {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	return metadata.NewOutgoingContext(ctx, metadata.Join(md, token))
+}
+
+// UnaryClientInterceptor 为副本的一元调用签名并校验主节点的应答
+func (a *replicationAuth) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		token, nonce := a.token(method)
+		var header metadata.MD
+		ctx = withToken(ctx, token)
+		if err := invoker(ctx, method, req, reply, cc, append(opts, grpc.Header(&header))...); err != nil {
+			return err
+		}
+		return a.verifyProof(method, nonce, header)
+	}
+}
+
+// StreamClientInterceptor 为副本的流式调用签名，在读取数据之前校验主节点的应答
+func (a *replicationAuth) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		token, nonce := a.token(method)
+		ctx = withToken(ctx, token)
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			return nil, err
+		}
+		header, err := stream.Header()
+		if err != nil {
+			return nil, err
+		}
+		if err := a.verifyProof(method, nonce, header); err != nil {
+			return nil, err
+		}
+		return stream, nil
+	}
+}
+
+// peerDialOptions 返回连接其他节点（主节点、协调节点和转发的目标）时使用的选项，
+// 未配置密钥时不进行认证
+func (s *server) peerDialOptions() []grpc.DialOption {
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(s.transportCredentials()), tracing.DialOption()}
+	if s.replAuth != nil {
+		opts = append(opts,
+			grpc.WithUnaryInterceptor(s.replAuth.UnaryClientInterceptor()),
+			grpc.WithStreamInterceptor(s.replAuth.StreamClientInterceptor()))
+	}
+	return opts
+}
+
+// authorizeReplica 检查请求中的副本 id 与通过认证的身份一致
+func authorizeReplica(ctx context.Context, replicaID string) error {
+	id, ok := ctx.Value(replicaIDKey{}).(string)
+	if ok && id != replicaID {
+		return status.Errorf(codes.PermissionDenied, "authenticated as replica %q but requested as %q", id, replicaID)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	pb "github.com/sidneychang/no-db/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var testReplicationKey = []byte("0123456789abcdef0123456789abcdef")
+
+// startAuthenticatedPrimary serves replication for a primary that authenticates with key
+func startAuthenticatedPrimary(t *testing.T, key []byte) (*server, string) {
+	primary, err := NewServer(t.TempDir(), "primary", true, "", nil)
+	assert.Nil(t, err)
+	primary.replAuth = newReplicationAuth(key, primary.nodeID)
+	primary.initReplicas("replica")
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	grpcServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(primary.replAuth.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(primary.replAuth.StreamServerInterceptor()))
+	pb.RegisterReplicationServer(grpcServer, &replicationServer{s: primary})
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+	return primary, listener.Addr().String()
+}
+
+func dialReplication(t *testing.T, s *server, addr string) pb.ReplicationClient {
+	conn, err := grpc.Dial(addr, s.peerDialOptions()...)
+	assert.Nil(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewReplicationClient(conn)
+}
+
+func TestReplicationAuth(t *testing.T) {
+	primary, addr := startAuthenticatedPrimary(t, testReplicationKey)
+	assert.Nil(t, primary.db.Put([]byte("key"), []byte("value")))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// a replica holding the key can pull and acknowledge
+	replica, err := NewServer(t.TempDir(), "replica", false, addr, nil)
+	assert.Nil(t, err)
+	replica.replAuth = newReplicationAuth(testReplicationKey, replica.nodeID)
+	client := dialReplication(t, replica, addr)
+	stream, err := client.Pull(ctx, &pb.PullRequest{ReplicaId: "replica"})
+	assert.Nil(t, err)
+	record, err := stream.Recv()
+	assert.Nil(t, err)
+	assert.Equal(t, "key", string(record.Key))
+	_, err = client.Ack(ctx, &pb.AckRequest{ReplicaId: "replica", Applied: record.Next})
+	assert.Nil(t, err)
+
+	// but cannot acknowledge on behalf of another replica
+	_, err = client.Ack(ctx, &pb.AckRequest{ReplicaId: "other", Applied: record.Next})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// peers without the key or with another key are rejected
+	anonymous, err := NewServer(t.TempDir(), "replica", false, addr, nil)
+	assert.Nil(t, err)
+	_, err = dialReplication(t, anonymous, addr).Ack(ctx, &pb.AckRequest{ReplicaId: "replica"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	anonymous.replAuth = newReplicationAuth([]byte("another key of 32 bytes........."), "replica")
+	_, err = dialReplication(t, anonymous, addr).Pull(ctx, &pb.PullRequest{ReplicaId: "replica"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestReplicationAuth_RejectsFakePrimary(t *testing.T) {
+	_, addr := startAuthenticatedPrimary(t, []byte("another key of 32 bytes........."))
+	replica, err := NewServer(t.TempDir(), "replica", false, addr, nil)
+	assert.Nil(t, err)
+	replica.replAuth = newReplicationAuth(testReplicationKey, replica.nodeID)
+
+	// a peer that cannot prove the key is rejected before any data is read from it
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = replica.repairFromPrimary(ctx, addr)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = dialReplication(t, replica, addr).Pull(ctx, &pb.PullRequest{ReplicaId: "replica"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestReplicationAuth_VerifyToken(t *testing.T) {
+	now := time.Now()
+	auth := newReplicationAuth(testReplicationKey, "replica")
+	auth.now = func() time.Time { return now }
+	method := "/proto.Replication/Ack"
+
+	token, _ := auth.token(method)
+	id, _, err := auth.verifyToken(method, token)
+	assert.Nil(t, err)
+	assert.Equal(t, "replica", id)
+
+	// replayed tokens, tokens for another method and forged identities are rejected
+	_, _, err = auth.verifyToken(method, token)
+	assert.Equal(t, errReplicationAuth, err)
+	token, _ = auth.token(method)
+	_, _, err = auth.verifyToken("/proto.Replication/Pull", token)
+	assert.Equal(t, errReplicationAuth, err)
+	forged := metadata.Join(token.Copy())
+	forged.Set(replicationIDHeader, "other")
+	_, _, err = auth.verifyToken(method, forged)
+	assert.Equal(t, errReplicationAuth, err)
+
+	// expired tokens are rejected
+	token, _ = auth.token(method)
+	now = now.Add(2 * replicationTokenSkew)
+	_, _, err = auth.verifyToken(method, token)
+	assert.Equal(t, errReplicationAuth, err)
+
+	// used nonces are forgotten once their tokens expired
+	now = now.Add(replicationTokenSkew)
+	token, _ = auth.token(method)
+	_, _, err = auth.verifyToken(method, token)
+	assert.Nil(t, err)
+	assert.Len(t, auth.seen, 1)
+	assert.Len(t, auth.expiry, 1)
+}
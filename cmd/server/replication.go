@@ -0,0 +1,437 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sidneychang/no-db/config"
+	"github.com/sidneychang/no-db/db/data"
+	"github.com/sidneychang/no-db/db/engine"
+	pb "github.com/sidneychang/no-db/proto"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// 副本保存复制进度的文件名
+	replicationStateFile = "replication.state"
+	// 与主节点断开后的重连间隔
+	replicationRetryInterval = time.Second
+	// 等待副本确认写入的默认超时时间
+	defaultAckTimeout = 2 * time.Second
+	// 没有拉取流且超过该时间没有确认的副本不再计入写入确认，通过 -replicas 登记的副本除外
+	replicaExpiry = 30 * time.Second
+)
+
+// replicaProgress 是主节点记录的一个副本的复制进度
+type replicaProgress struct {
+	applied  *data.RecordPst // 已应用并写入磁盘的日志位置，尚未确认时为 nil
+	pulling  int             // 正在进行的拉取流数量
+	lastSeen time.Time       // 最近一次确认或拉取结束的时间
+	expected bool            // 通过 -replicas 登记，不会过期
+}
+
+// replicationServer 在 Primary 上提供日志拉取服务
+type replicationServer struct {
+	pb.UnimplementedReplicationServer
+	s *server
+}
+
+// Pull 方法：副本从指定位置开始拉取数据文件中的记录
+func (r *replicationServer) Pull(req *pb.PullRequest, stream pb.Replication_PullServer) error {
+	s := r.s
+	if isPrimary, _ := s.primary(); !isPrimary {
+		return status.Errorf(codes.Unavailable, "replication is only served by the Primary server")
+	}
+	if err := authorizeReplica(stream.Context(), req.ReplicaId); err != nil {
+		return err
+	}
+	from := fromLogPosition(req.From)
+	s.logger().Info("Replica pulling", zap.String("replica", req.ReplicaId), zap.Uint32("fid", from.Fid), zap.Int64("offset", from.Offset))
+	s.trackPull(req.ReplicaId, 1)
+	defer s.trackPull(req.ReplicaId, -1)
+
+	tailer := s.db.Tail(from)
+	defer tailer.Close()
+	for {
+		record, pst, err := tailer.Next(stream.Context())
+		if err != nil {
+			if errors.Is(err, engine.ErrPositionCompacted) || errors.Is(err, engine.ErrInvalidPosition) {
+				return status.Errorf(codes.FailedPrecondition, "pull from %d:%d: %v", from.Fid, from.Offset, err)
+			}
+			return err
+		}
+		err = stream.Send(&pb.LogRecord{
+			Position:  toLogPosition(pst),
+			Next:      toLogPosition(tailer.Position()),
+			Key:       record.Key,
+			Value:     record.Value,
+			Type:      uint32(record.Type),
+			Namespace: record.Namespace,
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Ack 方法：副本汇报已经应用的日志位置
+func (r *replicationServer) Ack(ctx context.Context, req *pb.AckRequest) (*pb.Empty, error) {
+	s := r.s
+	if err := authorizeReplica(ctx, req.ReplicaId); err != nil {
+		return nil, err
+	}
+	s.replicaMu.Lock()
+	defer s.replicaMu.Unlock()
+	progress := s.replicaLocked(req.ReplicaId)
+	progress.applied = fromLogPosition(req.Applied)
+	progress.lastSeen = time.Now()
+	close(s.ackNotify)
+	s.ackNotify = make(chan struct{})
+	return &pb.Empty{}, nil
+}
+
+// waitForReplicas 按写入确认级别等待足够多的副本应用到 target 位置
+func (s *server) waitForReplicas(ctx context.Context, target *data.RecordPst, concern *pb.WriteConcern) (err error) {
+	if concern == nil || concern.Level == pb.AckLevel_ACK_DEFAULT {
+		concern = s.writeConcern
+	}
+	if concern.Level == pb.AckLevel_ACK_LOCAL {
+		return nil
+	}
+	ctx, span := tracer.Start(ctx, "replication.wait", trace.WithAttributes(attribute.String("level", concern.Level.String())))
+	defer func() { endSpan(span, err) }()
+	timeout := time.Duration(concern.TimeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = time.Duration(s.writeConcern.TimeoutMs) * time.Millisecond
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		acked, required, known, notify := s.countAcked(target, concern)
+		if acked >= required {
+			return nil
+		}
+		if known < required {
+			return status.Errorf(codes.Unavailable,
+				"write is durable locally but only %d replicas are known, %d required", known, required)
+		}
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			return status.Errorf(codes.DeadlineExceeded,
+				"write is durable locally but only acknowledged by %d/%d replicas within %v", acked, required, timeout)
+		}
+	}
+}
+
+// countAcked 统计已经应用到 target 位置的副本数、需要的副本数以及已知的副本数。
+// ACK_ALL 至少需要一个副本，否则没有副本保存写入时也会成功
+func (s *server) countAcked(target *data.RecordPst, concern *pb.WriteConcern) (acked int, required int, known int, notify <-chan struct{}) {
+	s.replicaMu.Lock()
+	defer s.replicaMu.Unlock()
+	s.expireReplicasLocked(time.Now())
+	known = len(s.replicas)
+	required = int(concern.Replicas)
+	if concern.Level == pb.AckLevel_ACK_ALL {
+		required = max(known, 1)
+	}
+	for _, progress := range s.replicas {
+		if progress.applied != nil && data.ComparePst(progress.applied, target) >= 0 {
+			acked++
+		}
+	}
+	return acked, required, known, s.ackNotify
+}
+
+// replicaLocked 返回副本的复制进度，第一次拉取或确认时登记
+// hold s.replicaMu before accessing this method
+func (s *server) replicaLocked(id string) *replicaProgress {
+	progress, ok := s.replicas[id]
+	if !ok {
+		progress = &replicaProgress{}
+		s.replicas[id] = progress
+	}
+	return progress
+}
+
+// trackPull 记录副本拉取流的开始和结束
+func (s *server) trackPull(id string, delta int) {
+	s.replicaMu.Lock()
+	defer s.replicaMu.Unlock()
+	progress := s.replicaLocked(id)
+	progress.pulling += delta
+	progress.lastSeen = time.Now()
+}
+
+// expireReplicasLocked 移除已下线的副本：没有拉取流，且超过 replicaExpiry 没有确认
+// hold s.replicaMu before accessing this method
+func (s *server) expireReplicasLocked(now time.Time) {
+	for id, progress := range s.replicas {
+		if !progress.expected && progress.pulling == 0 && now.Sub(progress.lastSeen) > replicaExpiry {
+			delete(s.replicas, id)
+		}
+	}
+}
+
+// parseWriteConcern 解析命令行中的默认写入确认级别
+func parseWriteConcern(level string, replicas uint, timeout time.Duration) (*pb.WriteConcern, error) {
+	concern := &pb.WriteConcern{
+		Replicas:  uint32(replicas),
+		TimeoutMs: uint32(timeout.Milliseconds()),
+	}
+	switch level {
+	case "local":
+		concern.Level = pb.AckLevel_ACK_LOCAL
+	case "replicas":
+		concern.Level = pb.AckLevel_ACK_REPLICAS
+	case "all":
+		concern.Level = pb.AckLevel_ACK_ALL
+	default:
+		return nil, fmt.Errorf("unknown level %q", level)
+	}
+	return concern, nil
+}
+
+// initReplicas 登记需要跟踪复制进度的副本
+func (s *server) initReplicas(replicaIDs string) {
+	s.replicaMu.Lock()
+	defer s.replicaMu.Unlock()
+	for _, id := range strings.Split(replicaIDs, ",") {
+		s.replicas[id] = &replicaProgress{expected: true}
+	}
+}
+
+// becomeReplica 切换为跟随 primaryAddr 的副本，从上次应用的位置继续复制
+func (s *server) becomeReplica(primaryAddr string) error {
+	applied, err := loadReplicationState(s.pathdir, primaryAddr)
+	if err != nil {
+		return err
+	}
+	if *applied == (data.RecordPst{}) {
+		if err := s.resetReplication(primaryAddr); err != nil {
+			return err
+		}
+	}
+	s.setApplied(applied)
+
+	s.roleMu.Lock()
+	defer s.roleMu.Unlock()
+	if s.stopReplica != nil {
+		s.stopReplica()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.isPrimary, s.primaryAddr, s.stopReplica = false, primaryAddr, cancel
+	go s.runReplica(ctx, primaryAddr)
+	return nil
+}
+
+// becomePrimary 停止复制并开始接受写操作
+func (s *server) becomePrimary() {
+	s.roleMu.Lock()
+	defer s.roleMu.Unlock()
+	if s.stopReplica != nil {
+		s.stopReplica()
+		s.stopReplica = nil
+	}
+	s.isPrimary, s.primaryAddr = true, ""
+}
+
+// runReplica 持续从主节点拉取日志，断开后从上次应用的位置重连
+func (s *server) runReplica(ctx context.Context, primaryAddr string) {
+	for {
+		err := s.pullFromPrimary(ctx, primaryAddr)
+		if status.Code(err) == codes.FailedPrecondition {
+			// 主节点上的位置已被合并，从头开始重新复制
+			s.logger().Warn("Replication position is no longer valid, restarting from the beginning", zap.Error(err))
+			if err := s.resetReplication(primaryAddr); err != nil {
+				s.logger().Error("Failed to reset replication", zap.Error(err))
+			}
+		} else if err != nil {
+			s.logger().Warn("Replication interrupted", zap.String("primary", primaryAddr), zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(replicationRetryInterval):
+		}
+	}
+}
+
+// resetReplication 清空本地数据，之后从主节点日志的开头重新复制。
+// 主节点合并后已删除键的记录不再出现在日志中，不清空时这些键会一直留在副本上
+func (s *server) resetReplication(primaryAddr string) error {
+	// 先保存复制进度，清空过程中重启时仍然从头开始
+	if err := saveReplicationState(s.pathdir, primaryAddr, &data.RecordPst{}); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, name := range s.db.Namespaces() {
+		ns := s.db.Namespace(name)
+		var keys [][]byte
+		it := ns.NewIterator(config.DefaultIteratorOptions)
+		for it.Rewind(); it.Valid(); it.Next() {
+			keys = append(keys, it.Key())
+		}
+		it.Close()
+		for _, key := range keys {
+			if err := ns.Delete(key); err != nil {
+				return err
+			}
+		}
+	}
+	s.setApplied(&data.RecordPst{})
+	return nil
+}
+
+// pullFromPrimary 建立一次拉取流，并将收到的记录应用到本地存储
+func (s *server) pullFromPrimary(ctx context.Context, primaryAddr string) error {
+	conn, err := grpc.Dial(primaryAddr, s.peerDialOptions()...)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	client := pb.NewReplicationClient(conn)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := client.Pull(ctx, &pb.PullRequest{ReplicaId: s.nodeID, From: toLogPosition(s.getApplied())})
+	if err != nil {
+		return err
+	}
+
+	// 确认操作在单独的 goroutine 中进行，只保留最新的位置
+	acks := make(chan *data.RecordPst, 1)
+	ackDone := make(chan struct{})
+	go func() {
+		defer close(ackDone)
+		s.ackLoop(ctx, client, primaryAddr, acks)
+	}()
+	defer func() {
+		close(acks)
+		<-ackDone
+	}()
+
+	for {
+		record, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if err := s.applyLogRecord(record); err != nil {
+			return err
+		}
+		next := fromLogPosition(record.Next)
+		s.setApplied(next)
+		select {
+		case <-acks:
+		default:
+		}
+		acks <- next
+	}
+}
+
+// applyLogRecord 将主节点的一条记录应用到本地存储
+func (s *server) applyLogRecord(record *pb.LogRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.repairWritten != nil {
+		s.repairWritten[string(merkleKey(record.Namespace, record.Key))] = struct{}{}
+	}
+	ns := s.db.Namespace(record.Namespace)
+	switch data.RecordType(record.Type) {
+	case data.Normal:
+		return ns.Put(record.Key, record.Value)
+	case data.Deleted:
+		return ns.Delete(record.Key)
+	default:
+		return fmt.Errorf("unknown record type %d", record.Type)
+	}
+}
+
+// ackLoop 将已应用的记录写入磁盘，持久化复制进度并向主节点确认。
+// 先写入数据再保存进度，确认的位置在副本重启后不会丢失
+func (s *server) ackLoop(ctx context.Context, client pb.ReplicationClient, primaryAddr string, acks <-chan *data.RecordPst) {
+	for pst := range acks {
+		if err := s.db.Sync(); err != nil {
+			s.logger().Error("Failed to sync replicated records", zap.Error(err))
+			continue
+		}
+		if err := saveReplicationState(s.pathdir, primaryAddr, pst); err != nil {
+			s.logger().Error("Failed to save replication state", zap.Error(err))
+		}
+		_, err := client.Ack(ctx, &pb.AckRequest{ReplicaId: s.nodeID, Applied: toLogPosition(pst)})
+		if err != nil && ctx.Err() == nil {
+			s.logger().Warn("Failed to ack replication position", zap.Error(err))
+		}
+	}
+}
+
+func (s *server) getApplied() *data.RecordPst {
+	s.replicaMu.Lock()
+	defer s.replicaMu.Unlock()
+	return s.applied
+}
+
+func (s *server) setApplied(pst *data.RecordPst) {
+	s.replicaMu.Lock()
+	defer s.replicaMu.Unlock()
+	s.applied = pst
+}
+
+// loadReplicationState 读取副本上次应用的位置，主节点地址变化时从头开始
+func loadReplicationState(pathdir string, primaryAddr string) (*data.RecordPst, error) {
+	content, err := os.ReadFile(filepath.Join(pathdir, replicationStateFile))
+	if os.IsNotExist(err) {
+		return &data.RecordPst{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var addr string
+	pst := &data.RecordPst{}
+	if _, err := fmt.Sscanf(string(content), "%s %d %d", &addr, &pst.Fid, &pst.Offset); err != nil {
+		return nil, fmt.Errorf("invalid replication state: %v", err)
+	}
+	if addr != primaryAddr {
+		return &data.RecordPst{}, nil
+	}
+	return pst, nil
+}
+
+// saveReplicationState 原子地保存副本已应用的位置
+func saveReplicationState(pathdir string, primaryAddr string, pst *data.RecordPst) error {
+	fileName := filepath.Join(pathdir, replicationStateFile)
+	content := fmt.Sprintf("%s %d %d\n", primaryAddr, pst.Fid, pst.Offset)
+	if err := os.WriteFile(fileName+".tmp", []byte(content), 0644); err != nil {
+		return err
+	}
+	return os.Rename(fileName+".tmp", fileName)
+}
+
+func toLogPosition(pst *data.RecordPst) *pb.LogPosition {
+	if pst == nil {
+		return nil
+	}
+	return &pb.LogPosition{Fid: pst.Fid, Offset: pst.Offset}
+}
+
+func fromLogPosition(pos *pb.LogPosition) *data.RecordPst {
+	if pos == nil {
+		return &data.RecordPst{}
+	}
+	return &data.RecordPst{Fid: pos.Fid, Offset: pos.Offset}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sidneychang/no-db/db/data"
+	"github.com/sidneychang/no-db/db/engine"
+	pb "github.com/sidneychang/no-db/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestReplicationState(t *testing.T) {
+	dir := t.TempDir()
+
+	pst, err := loadReplicationState(dir, "localhost:50051")
+	assert.Nil(t, err)
+	assert.Equal(t, &data.RecordPst{}, pst)
+
+	assert.Nil(t, saveReplicationState(dir, "localhost:50051", &data.RecordPst{Fid: 3, Offset: 120}))
+	pst, err = loadReplicationState(dir, "localhost:50051")
+	assert.Nil(t, err)
+	assert.Equal(t, &data.RecordPst{Fid: 3, Offset: 120}, pst)
+
+	// positions are only meaningful for the primary they were pulled from
+	pst, err = loadReplicationState(dir, "localhost:50052")
+	assert.Nil(t, err)
+	assert.Equal(t, &data.RecordPst{}, pst)
+}
+
+func TestWaitForReplicas(t *testing.T) {
+	s, err := NewServer(t.TempDir(), "primary", true, "", nil)
+	assert.Nil(t, err)
+	s.initReplicas("r1,r2")
+	r := &replicationServer{s: s}
+	target := &data.RecordPst{Fid: 1, Offset: 100}
+	ctx := context.Background()
+
+	// local writes do not wait
+	assert.Nil(t, s.waitForReplicas(ctx, target, nil))
+
+	quorum := &pb.WriteConcern{Level: pb.AckLevel_ACK_REPLICAS, Replicas: 1, TimeoutMs: 1000}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_, _ = r.Ack(ctx, &pb.AckRequest{ReplicaId: "r1", Applied: &pb.LogPosition{Fid: 1, Offset: 50}})
+		_, _ = r.Ack(ctx, &pb.AckRequest{ReplicaId: "r1", Applied: &pb.LogPosition{Fid: 1, Offset: 100}})
+	}()
+	assert.Nil(t, s.waitForReplicas(ctx, target, quorum))
+
+	all := &pb.WriteConcern{Level: pb.AckLevel_ACK_ALL, TimeoutMs: 20}
+	err = s.waitForReplicas(ctx, target, all)
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+
+	_, _ = r.Ack(ctx, &pb.AckRequest{ReplicaId: "r2", Applied: &pb.LogPosition{Fid: 2}})
+	assert.Nil(t, s.waitForReplicas(ctx, target, all))
+
+	// a replica that is not in -replicas counts while it is connected and
+	// until it expires
+	_, _ = r.Ack(ctx, &pb.AckRequest{ReplicaId: "r3", Applied: &pb.LogPosition{Fid: 1}})
+	err = s.waitForReplicas(ctx, target, all)
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	s.replicaMu.Lock()
+	s.replicas["r3"].lastSeen = time.Now().Add(-2 * replicaExpiry)
+	s.replicaMu.Unlock()
+	assert.Nil(t, s.waitForReplicas(ctx, target, all))
+	s.trackPull("r3", 1)
+	s.replicaMu.Lock()
+	s.replicas["r3"].lastSeen = time.Now().Add(-2 * replicaExpiry)
+	s.replicaMu.Unlock()
+	err = s.waitForReplicas(ctx, target, all)
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+
+	// writes fail at once without enough known replicas
+	four := &pb.WriteConcern{Level: pb.AckLevel_ACK_REPLICAS, Replicas: 4, TimeoutMs: 1000}
+	err = s.waitForReplicas(ctx, target, four)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestWaitForReplicas_NoReplicas(t *testing.T) {
+	s, err := NewServer(t.TempDir(), "primary", true, "", nil)
+	assert.Nil(t, err)
+	// nothing holds the write, it must not count as acknowledged by all
+	all := &pb.WriteConcern{Level: pb.AckLevel_ACK_ALL, TimeoutMs: 1000}
+	err = s.waitForReplicas(context.Background(), &data.RecordPst{Fid: 1}, all)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+// servePrimary opens a primary on dir serving replication on addr, until stop
+// is called or the test ends
+func servePrimary(t *testing.T, dir string, addr string) (s *server, listenAddr string, stop func()) {
+	s, err := NewServer(dir, "primary", true, "", nil)
+	assert.Nil(t, err)
+	listener, err := net.Listen("tcp", addr)
+	assert.Nil(t, err)
+	grpcServer := grpc.NewServer()
+	pb.RegisterReplicationServer(grpcServer, &replicationServer{s: s})
+	go grpcServer.Serve(listener)
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			grpcServer.Stop()
+			assert.Nil(t, s.close())
+		})
+	}
+	t.Cleanup(stop)
+	return s, listener.Addr().String(), stop
+}
+
+// waitForReplica waits until the replica applied everything written on the primary
+func waitForReplica(t *testing.T, primary *server, replica *server) {
+	target := primary.db.LogPosition()
+	assert.Eventually(t, func() bool {
+		return data.ComparePst(replica.getApplied(), target) >= 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func assertValue(t *testing.T, s *server, key string, value string) {
+	got, err := s.db.Get([]byte(key))
+	if value == "" {
+		assert.Equal(t, engine.ErrKeyNotFound, err, key)
+		return
+	}
+	assert.Nil(t, err, key)
+	assert.Equal(t, value, string(got), key)
+}
+
+func TestReplication(t *testing.T) {
+	primaryDir, replicaDir := t.TempDir(), t.TempDir()
+	primary, addr, stop := servePrimary(t, primaryDir, "127.0.0.1:0")
+
+	replica, err := NewServer(replicaDir, "replica", false, addr, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, replica.becomeReplica(addr))
+	// records are applied in the order of the log
+	assert.Nil(t, primary.db.Put([]byte("a"), []byte("1")))
+	assert.Nil(t, primary.db.Put([]byte("b"), []byte(strings.Repeat("2", 1024))))
+	assert.Nil(t, primary.db.Put([]byte("c"), []byte("3")))
+	assert.Nil(t, primary.db.Delete([]byte("b")))
+	assert.Nil(t, primary.db.Put([]byte("a"), []byte("4")))
+	waitForReplica(t, primary, replica)
+	assertValue(t, replica, "a", "4")
+	assertValue(t, replica, "b", "")
+	assertValue(t, replica, "c", "3")
+	assert.Eventually(t, func() bool {
+		saved, err := loadReplicationState(replicaDir, addr)
+		return err == nil && data.ComparePst(saved, primary.db.LogPosition()) == 0
+	}, time.Second, 10*time.Millisecond)
+
+	// a restarted replica resumes from the saved position
+	assert.Nil(t, replica.close())
+	assert.Nil(t, primary.db.Put([]byte("d"), []byte("5")))
+	replica, err = NewServer(replicaDir, "replica", false, addr, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, replica.becomeReplica(addr))
+	waitForReplica(t, primary, replica)
+	assertValue(t, replica, "a", "4")
+	assertValue(t, replica, "d", "5")
+	assert.Equal(t, uint64(1), replica.db.Stats().Puts)
+	assert.Nil(t, replica.close())
+
+	// the primary restarts with a compacted log holding only the live keys:
+	// the position of the replica is gone and so is the tombstone of c
+	stop()
+	primary, _, _ = servePrimary(t, t.TempDir(), addr)
+	assert.Nil(t, primary.db.Put([]byte("a"), []byte("4")))
+	assert.Nil(t, primary.db.Put([]byte("d"), []byte("5")))
+	assert.Nil(t, primary.db.Put([]byte("e"), []byte("6")))
+
+	// the replica starts over from an empty db and drops c
+	replica, err = NewServer(replicaDir, "replica", false, addr, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, replica.becomeReplica(addr))
+	defer replica.close()
+	assert.Eventually(t, func() bool {
+		_, err := replica.db.Get([]byte("e"))
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	assertValue(t, replica, "a", "4")
+	assertValue(t, replica, "b", "")
+	assertValue(t, replica, "c", "")
+	assertValue(t, replica, "d", "5")
+	assertValue(t, replica, "e", "6")
+}
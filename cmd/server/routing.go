@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sidneychang/no-db/consistenthash"
+	pb "github.com/sidneychang/no-db/proto"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// 转发的请求携带该请求头，收到转发请求的节点不再继续转发，避免拓扑不一致时请求循环
+const forwardedHeader = "x-kvdb-forwarded"
+
+// 请求的键不属于本节点所在分片时的处理方式
+const (
+	routeForward  = "forward"  // 转发给所属分片的主节点
+	routeRedirect = "redirect" // 返回带有 Redirect 的 FailedPrecondition，由客户端重试
+)
+
+func parseRouting(mode string) (string, error) {
+	switch mode {
+	case routeForward, routeRedirect:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown routing mode %q", mode)
+	}
+}
+
+// setClusterInfo 保存集群拓扑，版本变化时重建哈希环，并返回之前的哈希环
+func (s *server) setClusterInfo(view *pb.ClusterInfoResponse) (prevRing *consistenthash.HashRing, changed bool) {
+	s.clusterMu.Lock()
+	defer s.clusterMu.Unlock()
+	if s.cluster != nil && s.cluster.Version == view.Version {
+		s.cluster = view
+		return s.ring, false
+	}
+	s.logger().Info("Cluster topology changed", zap.Uint64("version", view.Version), zap.Int("shards", len(view.Shards)))
+	prevRing = s.ring
+	s.cluster = view
+	s.ring = buildRing(view, "")
+	return prevRing, true
+}
+
+// buildRing 按拓扑中的分片及其权重构建哈希环，已下线的分片和 exclude 不在环上
+func buildRing(view *pb.ClusterInfoResponse, exclude string) *consistenthash.HashRing {
+	// 协调节点启动时已检查过哈希函数的名称
+	hashFunc, _ := consistenthash.ParseHashFunc(view.Hash)
+	ring := consistenthash.NewHashRingWithHash(nil, int(view.VirtualNodes), hashFunc)
+	for _, shard := range view.Shards {
+		if !shard.Leaving && shard.Name != exclude {
+			ring.AddNodeWithWeight(shard.Name, int(shard.Weight))
+		}
+		ring.SetZone(shard.Name, shard.Zone)
+	}
+	return ring
+}
+
+// replicationFactor 返回每个键保存在多少个分片上
+func replicationFactor(view *pb.ClusterInfoResponse) int {
+	if view.ReplicationFactor > 1 {
+		return int(view.ReplicationFactor)
+	}
+	return 1
+}
+
+// owners 返回保存键的分片、本节点所在分片是否是其中之一以及拓扑版本，
+// 第一个分片是键在哈希环上所属的分片。本节点尚未获取拓扑时返回 nil
+func (s *server) owners(key string) (shards []*pb.Shard, local bool, version uint64) {
+	s.clusterMu.RLock()
+	defer s.clusterMu.RUnlock()
+	if s.cluster == nil || len(s.cluster.Shards) == 0 {
+		return nil, false, 0
+	}
+	for _, name := range s.ring.GetN(key, replicationFactor(s.cluster)) {
+		local = local || name == s.shard
+		for _, shard := range s.cluster.Shards {
+			if shard.Name == name {
+				shards = append(shards, shard)
+			}
+		}
+	}
+	return shards, local, s.cluster.Version
+}
+
+// routingUnaryInterceptor 将键不属于本节点所在分片的请求转发给所属分片的主节点，
+// 或者返回重定向，让使用过期哈希环的客户端也能访问到正确的节点。
+// 键保存在多个分片上时，其中任一分片都直接处理读请求，写请求由收到的分片写入其他副本
+func (s *server) routingUnaryInterceptor(mode string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		var reply interface{}
+		switch info.FullMethod {
+		case pb.KVDB_Get_FullMethodName:
+			reply = &pb.GetResponse{}
+		case pb.KVDB_Put_FullMethodName, pb.KVDB_Delete_FullMethodName:
+			reply = &pb.Empty{}
+		default:
+			return handler(ctx, req)
+		}
+		md, _ := metadata.FromIncomingContext(ctx)
+		// 迁移期间的读取和写入副本由其他节点发出，跳过归属检查；客户端携带这些请求头时忽略，
+		// 否则任何调用者都能绕过路由直接写入不拥有该键的节点
+		if s.fromPeer(ctx) {
+			if info.FullMethod == pb.KVDB_Get_FullMethodName && len(md.Get(handoffReadHeader)) > 0 {
+				return handler(ctx, req)
+			}
+			if len(md.Get(replicaWriteHeader)) > 0 {
+				return handler(ctx, req)
+			}
+		}
+		owners, local, version := s.owners(req.(keyRequest).GetKey())
+		if len(owners) == 0 {
+			return handler(ctx, req)
+		}
+		if local {
+			if info.FullMethod == pb.KVDB_Get_FullMethodName {
+				return handler(ctx, req)
+			}
+			return s.replicateWrite(ctx, md, info.FullMethod, req, owners, handler)
+		}
+		// 所属分片没有主节点时交给下一个副本所在的分片处理
+		shard := owners[0]
+		for _, owner := range owners {
+			if owner.Primary != "" {
+				shard = owner
+				break
+			}
+		}
+		if shard.Primary == "" {
+			return nil, status.Errorf(codes.Unavailable, "shard %s owning the key has no primary", shard.Name)
+		}
+
+		if mode == routeRedirect || len(md.Get(forwardedHeader)) > 0 {
+			st, err := status.New(codes.FailedPrecondition, fmt.Sprintf("key is owned by shard %s at %s", shard.Name, shard.Primary)).
+				WithDetails(&pb.Redirect{Shard: shard.Name, Address: shard.Primary, Version: version})
+			if err != nil {
+				return nil, err
+			}
+			return nil, st.Err()
+		}
+
+		conn, err := s.peerConn(shard.Primary)
+		if err != nil {
+			return nil, status.Errorf(codes.Unavailable, "failed to connect to %s: %v", shard.Primary, err)
+		}
+		// 转发时携带客户端的 token，由所属节点重新检查权限
+		outgoing := metadata.Pairs(forwardedHeader, s.nodeID)
+		if token := md.Get(authorizationHeader); len(token) > 0 {
+			outgoing.Set(authorizationHeader, token...)
+		}
+		ctx = metadata.NewOutgoingContext(ctx, outgoing)
+		if err := conn.Invoke(ctx, info.FullMethod, req, reply); err != nil {
+			return nil, err
+		}
+		return reply, nil
+	}
+}
+
+// fromPeer 判断请求是否来自集群节点：配置了复制密钥时要求请求带有有效签名，
+// 未配置时节点之间不进行认证，所有请求都被视为来自集群节点
+func (s *server) fromPeer(ctx context.Context) bool {
+	return s.replAuth == nil || isPeer(ctx)
+}
+
+// peerConn 返回到其他服务端的连接，连接会被复用
+func (s *server) peerConn(addr string) (*grpc.ClientConn, error) {
+	s.peerMu.Lock()
+	defer s.peerMu.Unlock()
+	if conn, ok := s.peers[addr]; ok {
+		return conn, nil
+	}
+	// 转发的请求延续原请求的 trace，并带有本节点的签名
+	conn, err := grpc.Dial(addr, s.peerDialOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	s.peers[addr] = conn
+	return conn, nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	pb "github.com/sidneychang/no-db/proto"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// startRoutingServer serves a primary of shard with the routing interceptor
+// and the replication service
+func startRoutingServer(t *testing.T, shard string, mode string) (*server, pb.KVDBClient) {
+	return startRoutingServerWithKey(t, shard, mode, nil)
+}
+
+// startRoutingServerWithKey is startRoutingServer with peers authenticated by
+// the replication key when key is not nil
+func startRoutingServerWithKey(t *testing.T, shard string, mode string, key []byte) (*server, pb.KVDBClient) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	s, err := NewServer(t.TempDir(), listener.Addr().String(), true, "", nil)
+	assert.Nil(t, err)
+	s.shard = shard
+	interceptors := []grpc.UnaryServerInterceptor{s.routingUnaryInterceptor(mode)}
+	if key != nil {
+		s.replAuth = newReplicationAuth(key, s.nodeID)
+		interceptors = append([]grpc.UnaryServerInterceptor{s.replAuth.UnaryServerInterceptor()}, interceptors...)
+	}
+	grpcServer := grpc.NewServer(grpc.ChainUnaryInterceptor(interceptors...))
+	pb.RegisterKVDBServer(grpcServer, s)
+	pb.RegisterReplicationServer(grpcServer, &replicationServer{s: s})
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.Dial(s.nodeID, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.Nil(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return s, pb.NewKVDBClient(conn)
+}
+
+func TestRouting(t *testing.T) {
+	a, clientA := startRoutingServer(t, "a", routeForward)
+	b, clientB := startRoutingServer(t, "b", routeRedirect)
+	view := &pb.ClusterInfoResponse{Version: 1, VirtualNodes: 100, Shards: []*pb.Shard{
+		{Name: "a", Primary: a.nodeID},
+		{Name: "b", Primary: b.nodeID},
+	}}
+	a.setClusterInfo(view)
+	b.setClusterInfo(view)
+
+	// find a key owned by each shard
+	var keyA, keyB string
+	for i := 0; keyA == "" || keyB == ""; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if a.ring.Get(key) == "a" {
+			keyA = key
+		} else {
+			keyB = key
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// a forwards the keys of b
+	_, err := clientA.Put(ctx, &pb.PutRequest{Key: keyB, Value: "v"})
+	assert.Nil(t, err)
+	value, err := b.db.Get([]byte(keyB))
+	assert.Nil(t, err)
+	assert.Equal(t, "v", string(value))
+	_, err = a.db.Get([]byte(keyB))
+	assert.NotNil(t, err)
+	resp, err := clientA.Get(ctx, &pb.GetRequest{Key: keyB})
+	assert.Nil(t, err)
+	assert.Equal(t, "v", resp.Value)
+
+	// b redirects the keys of a
+	_, err = clientB.Put(ctx, &pb.PutRequest{Key: keyA, Value: "v"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	details := status.Convert(err).Details()
+	assert.Equal(t, 1, len(details))
+	redirect := details[0].(*pb.Redirect)
+	assert.Equal(t, "a", redirect.Shard)
+	assert.Equal(t, a.nodeID, redirect.Address)
+	assert.Equal(t, uint64(1), redirect.Version)
+
+	// a forwarded request is never forwarded again
+	forwarded := metadata.AppendToOutgoingContext(ctx, forwardedHeader, "other")
+	_, err = clientA.Put(forwarded, &pb.PutRequest{Key: keyB, Value: "v"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	_, err = clientA.Put(forwarded, &pb.PutRequest{Key: keyA, Value: "v"})
+	assert.Nil(t, err)
+}
+
+func TestRouting_PeerHeaders(t *testing.T) {
+	a, clientA := startRoutingServerWithKey(t, "a", routeForward, testReplicationKey)
+	b, _ := startRoutingServerWithKey(t, "b", routeForward, testReplicationKey)
+	view := &pb.ClusterInfoResponse{Version: 1, VirtualNodes: 100, Shards: []*pb.Shard{
+		{Name: "a", Primary: a.nodeID},
+		{Name: "b", Primary: b.nodeID},
+	}}
+	a.setClusterInfo(view)
+	b.setClusterInfo(view)
+	var keyB string
+	for i := 0; keyB == ""; i++ {
+		if key := fmt.Sprintf("key-%d", i); a.ring.Get(key) == "b" {
+			keyB = key
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// clients cannot skip routing by sending the headers of peer requests
+	spoofed := metadata.AppendToOutgoingContext(ctx, replicaWriteHeader, "other", handoffReadHeader, "other")
+	_, err := clientA.Put(spoofed, &pb.PutRequest{Key: keyB, Value: "v"})
+	assert.Nil(t, err)
+	_, err = a.db.Get([]byte(keyB))
+	assert.NotNil(t, err)
+	value, err := b.db.Get([]byte(keyB))
+	assert.Nil(t, err)
+	assert.Equal(t, "v", string(value))
+	resp, err := clientA.Get(spoofed, &pb.GetRequest{Key: keyB})
+	assert.Nil(t, err)
+	assert.Equal(t, "v", resp.Value)
+
+	// requests signed by a peer are served locally
+	conn, err := grpc.Dial(a.nodeID, b.peerDialOptions()...)
+	assert.Nil(t, err)
+	defer conn.Close()
+	peer := pb.NewKVDBClient(conn)
+	_, err = peer.Put(metadata.AppendToOutgoingContext(ctx, replicaWriteHeader, b.nodeID), &pb.PutRequest{Key: keyB, Value: "replica"})
+	assert.Nil(t, err)
+	value, err = a.db.Get([]byte(keyB))
+	assert.Nil(t, err)
+	assert.Equal(t, "replica", string(value))
+	resp, err = peer.Get(metadata.AppendToOutgoingContext(ctx, handoffReadHeader, b.nodeID), &pb.GetRequest{Key: keyB})
+	assert.Nil(t, err)
+	assert.Equal(t, "replica", resp.Value)
+}
+
+func TestNewServer_Logger(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	s, err := NewServer(t.TempDir(), "node", true, "", zap.New(core))
+	assert.Nil(t, err)
+	s.setClusterInfo(&pb.ClusterInfoResponse{Version: 1, VirtualNodes: 100, Shards: []*pb.Shard{{Name: "a", Primary: "node"}}})
+	entries := logs.FilterMessage("Cluster topology changed").All()
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "Primary", entries[0].ContextMap()["role"])
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+)
+
+// shutdownOptions 控制收到退出信号后的优雅关闭
+type shutdownOptions struct {
+	delay          time.Duration      // 报告 NOT_SERVING 后继续处理请求的时间，让客户端和负载均衡先摘除本节点
+	timeout        time.Duration      // 等待进行中的请求完成的最长时间，超时后强制关闭连接
+	stopBackground context.CancelFunc // 停止故障转移、成员注册、反熵等后台任务
+}
+
+// serve 处理请求直到收到退出信号，然后优雅关闭：健康检查报告 NOT_SERVING，等待 delay 后停止后台任务，
+// 不再接受新请求并等待进行中的请求完成，最后停止复制、关闭 raft 和存储引擎。
+// 关闭期间再次收到信号时跳过等待立即关闭
+func (s *server) serve(grpcServer *grpc.Server, listener net.Listener, healthServer *health.Server, signals <-chan os.Signal, opts shutdownOptions) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- grpcServer.Serve(listener)
+	}()
+
+	var err error
+	select {
+	case err = <-serveErr:
+		s.logger().Error("Server stopped serving", zap.Error(err))
+	case sig := <-signals:
+		s.logger().Info("Shutting down", zap.Stringer("signal", sig))
+		healthServer.Shutdown()
+		if opts.delay > 0 {
+			timer := time.NewTimer(opts.delay)
+			select {
+			case <-timer.C:
+			case <-signals:
+			}
+			timer.Stop()
+		}
+		if opts.stopBackground != nil {
+			opts.stopBackground()
+		}
+
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		var timeout <-chan time.Time
+		if opts.timeout > 0 {
+			timer := time.NewTimer(opts.timeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case <-stopped:
+		case <-timeout:
+			s.logger().Warn("Requests still running, closing connections", zap.Duration("timeout", opts.timeout))
+			grpcServer.Stop()
+		case <-signals:
+			grpcServer.Stop()
+		}
+		<-stopped
+		// GracefulStop 之后 Serve 返回 nil
+		err = <-serveErr
+	}
+	if opts.stopBackground != nil {
+		opts.stopBackground()
+	}
+	return errors.Join(err, s.close())
+}
+
+// close 停止复制和 raft，关闭到其他服务端的连接，最后将数据写入磁盘并关闭存储引擎
+func (s *server) close() error {
+	var errs []error
+	s.roleMu.Lock()
+	if s.stopReplica != nil {
+		s.stopReplica()
+		s.stopReplica = nil
+	}
+	s.roleMu.Unlock()
+	if s.raftNode != nil {
+		errs = append(errs, s.raftNode.Shutdown())
+	}
+
+	s.peerMu.Lock()
+	for addr, conn := range s.peers {
+		errs = append(errs, conn.Close())
+		delete(s.peers, addr)
+	}
+	s.peerMu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	errs = append(errs, s.db.Sync(), s.db.Close())
+	return errors.Join(errs...)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sidneychang/no-db/config"
+	"github.com/sidneychang/no-db/db/engine"
+	pb "github.com/sidneychang/no-db/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// startShutdownServer serves a primary whose Put requests for key "slow" block
+// until release is closed or the request is cancelled, and returns the channel
+// serve waits on for signals together with serve's result.
+func startShutdownServer(t *testing.T, dir string, opts shutdownOptions, release chan struct{}) (*grpc.ClientConn, chan os.Signal, chan error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	s, err := NewServer(dir, listener.Addr().String(), true, "", nil)
+	assert.Nil(t, err)
+	block := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if put, ok := req.(*pb.PutRequest); ok && put.Key == "slow" {
+			select {
+			case <-release:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		return handler(ctx, req)
+	}
+	grpcServer := grpc.NewServer(grpc.ChainUnaryInterceptor(block))
+	pb.RegisterKVDBServer(grpcServer, s)
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(pb.KVDB_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+
+	signals := make(chan os.Signal, 2)
+	done := make(chan error, 1)
+	go func() {
+		done <- s.serve(grpcServer, listener, healthServer, signals, opts)
+	}()
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.Nil(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn, signals, done
+}
+
+func TestServer_GracefulShutdown(t *testing.T) {
+	dir := t.TempDir()
+	release := make(chan struct{})
+	stopped := make(chan struct{})
+	opts := shutdownOptions{delay: time.Minute, timeout: time.Minute, stopBackground: func() {
+		select {
+		case <-stopped:
+		default:
+			close(stopped)
+		}
+	}}
+	conn, signals, done := startShutdownServer(t, dir, opts, release)
+	client := pb.NewKVDBClient(conn)
+	healthClient := healthpb.NewHealthClient(conn)
+
+	_, err := client.Put(context.Background(), &pb.PutRequest{Key: "fast", Value: "1"})
+	assert.Nil(t, err)
+	slow := make(chan error, 1)
+	go func() {
+		_, err := client.Put(context.Background(), &pb.PutRequest{Key: "slow", Value: "2"})
+		slow <- err
+	}()
+
+	signals <- syscall.SIGTERM
+	// the server reports NOT_SERVING but keeps serving during the delay
+	assert.Eventually(t, func() bool {
+		resp, err := healthClient.Check(context.Background(), &healthpb.HealthCheckRequest{Service: pb.KVDB_ServiceDesc.ServiceName})
+		return err == nil && resp.Status == healthpb.HealthCheckResponse_NOT_SERVING
+	}, time.Second, 10*time.Millisecond)
+	_, err = client.Get(context.Background(), &pb.GetRequest{Key: "fast"})
+	assert.Nil(t, err)
+
+	// a second signal skips the delay, the in-flight request is still waited for
+	signals <- syscall.SIGTERM
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("background tasks were not stopped")
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("serve returned before the in-flight request completed: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	assert.Nil(t, <-slow)
+	assert.Nil(t, <-done)
+
+	// the engine was closed and every write is on disk
+	db, err := engine.NewDB(*config.NewOptions(1, 1024, dir))
+	assert.Nil(t, err)
+	defer db.Close()
+	for key, value := range map[string]string{"fast": "1", "slow": "2"} {
+		got, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, value, string(got))
+	}
+}
+
+func TestServer_ShutdownTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	conn, signals, done := startShutdownServer(t, t.TempDir(), shutdownOptions{timeout: 100 * time.Millisecond}, release)
+	client := pb.NewKVDBClient(conn)
+
+	slow := make(chan error, 1)
+	go func() {
+		_, err := client.Put(context.Background(), &pb.PutRequest{Key: "slow", Value: "2"})
+		slow <- err
+	}()
+	// wait for the request to reach the server
+	time.Sleep(50 * time.Millisecond)
+	signals <- syscall.SIGINT
+
+	select {
+	case err := <-done:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("serve did not return after the shutdown timeout")
+	}
+	assert.Equal(t, codes.Unavailable, status.Code(<-slow))
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer 记录服务端内部的 span，gRPC 请求本身的 span 由 tracing.ServerOption 记录
+var tracer = otel.Tracer("github.com/sidneychang/no-db/cmd/server")
+
+// lockDB 获取 s.mu，等待锁的时间记录为一个 span
+func (s *server) lockDB(ctx context.Context) {
+	_, span := tracer.Start(ctx, "server.lock")
+	s.mu.Lock()
+	span.End()
+}
+
+// endSpan 结束 span，err 不为 nil 时将 span 标记为失败
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	pb "github.com/sidneychang/no-db/proto"
+	"github.com/sidneychang/no-db/tracing"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	_, err := tracing.Setup(context.Background(), tracing.Config{})
+	assert.Nil(t, err)
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	s, err := NewServer(t.TempDir(), listener.Addr().String(), true, "", nil)
+	assert.Nil(t, err)
+	grpcServer := grpc.NewServer(tracing.ServerOption())
+	pb.RegisterKVDBServer(grpcServer, s)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()), tracing.DialOption())
+	assert.Nil(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	ctx, root := otel.Tracer("test").Start(context.Background(), "put")
+	_, err = pb.NewKVDBClient(conn).Put(ctx, &pb.PutRequest{Key: "a", Value: "1"})
+	assert.Nil(t, err)
+	root.End()
+	// the server span ends once the response is sent, possibly after the client got it
+	assert.Eventually(t, func() bool {
+		for _, span := range recorder.Ended() {
+			if span.SpanKind() == trace.SpanKindServer {
+				return true
+			}
+		}
+		return false
+	}, time.Second, 10*time.Millisecond)
+
+	// the server's spans continue the trace of the caller, down to the engine;
+	// the client and server spans of the RPC have the same name and are told
+	// apart by their kind
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		assert.Equal(t, root.SpanContext().TraceID(), span.SpanContext().TraceID(), span.Name())
+		name := span.Name()
+		if span.SpanKind() != trace.SpanKindInternal {
+			name += " " + span.SpanKind().String()
+		}
+		spans[name] = span
+	}
+	parents := map[string]string{
+		"proto.KVDB/Put client": "put",
+		"proto.KVDB/Put server": "proto.KVDB/Put client",
+		"server.lock":           "proto.KVDB/Put server",
+		"engine.Put":            "proto.KVDB/Put server",
+		"engine.append":         "engine.Put",
+		"engine.index":          "engine.Put",
+	}
+	for name, parent := range parents {
+		if assert.Contains(t, spans, name) && assert.Contains(t, spans, parent) {
+			assert.Equal(t, spans[parent].SpanContext().SpanID(), spans[name].Parent().SpanID(), name)
+		}
+	}
+}
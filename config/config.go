@@ -1,6 +1,10 @@
 package config
 
-import "os"
+import (
+	"os"
+
+	"go.uber.org/zap"
+)
 
 // type Options string;
 type Options struct {
@@ -14,6 +18,9 @@ type Options struct {
 	// SyncWrite determines whether the database should ensure data persistence with
 	// every write operation.
 	SyncWrite bool
+	// Logger receives the logs of the database, nil discards them.
+	// Values are never logged, keys only at debug level.
+	Logger *zap.Logger
 }
 
 func NewOptions(nodes int, segmentSize int, DirPath string) *Options {
@@ -43,6 +50,18 @@ var DefaultOptions = Options{
 	SyncWrite:    false,
 }
 
+// NamespaceOptions is the configuration of a namespace within a DB.
+// All the namespaces of a DB share its data files.
+type NamespaceOptions struct {
+	// SyncWrite syncs the data files after every write to the namespace,
+	// even when the DB does not sync every write.
+	SyncWrite bool
+}
+
+var DefaultNamespaceOptions = NamespaceOptions{
+	SyncWrite: false,
+}
+
 var DefaultIteratorOptions = IteratorOptions{
 	Prefix:  nil,
 	Reverse: false,
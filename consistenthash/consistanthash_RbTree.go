@@ -1,37 +1,50 @@
 package consistenthash
 
 import (
-	"context"
-	"fmt"
-	"hash/crc32"
+	"sort"
 	"strconv"
 	"sync"
-	"time"
 
 	rb "github.com/sidneychang/no-db/RbTree"
-	pb "github.com/sidneychang/no-db/proto"
-	"google.golang.org/grpc"
 )
 
 // 虚拟节点数
 const DefaultVirtualNodes = 100
 
+// RbHashRing 使用红黑树保存虚拟节点的一致性哈希环，只负责路由，不迁移数据；
+// 节点变化时需要迁移的数据由 PlanMigration 给出。
+// 树节点的值是哈希值相同的虚拟节点所属节点的有序列表，与 HashRing 一样由节点名最小的节点拥有该位置
 type RbHashRing struct {
 	virtualNodes int      // 每个物理节点的虚拟节点数
 	nodes        []string // 所有节点的列表
 	rbTree       rb.RbTree
-	mu           sync.RWMutex // 锁，保护并发访问
+	size         int               // 虚拟节点数，包括哈希值相同的虚拟节点
+	hashFunc     HashFunc          // 计算虚拟节点和键在环上的位置
+	zones        map[string]string // 节点所在的可用区，GetN 优先选择不同可用区的节点
+	weights      map[string]int    // 节点的权重，虚拟节点数为 virtualNodes 乘以权重
+	mu           sync.RWMutex      // 锁，保护并发访问
 }
 
+// NewRbHashRing 创建使用 CRC32 计算哈希值的哈希环
 func NewRbHashRing(nodes []string, virtualNodes int) *RbHashRing {
+	return NewRbHashRingWithHash(nodes, virtualNodes, CRC32)
+}
+
+// NewRbHashRingWithHash 创建使用指定哈希函数的哈希环，hashFunc 为 nil 时使用 CRC32
+func NewRbHashRingWithHash(nodes []string, virtualNodes int, hashFunc HashFunc) *RbHashRing {
 	if virtualNodes <= 0 {
 		virtualNodes = DefaultVirtualNodes
 	}
+	if hashFunc == nil {
+		hashFunc = CRC32
+	}
 
 	ring := &RbHashRing{
 		virtualNodes: virtualNodes,
-		nodes:        nodes,
 		rbTree:       *rb.NewRbTree(),
+		hashFunc:     hashFunc,
+		zones:        make(map[string]string),
+		weights:      make(map[string]int),
 	}
 
 	// 将每个节点添加到哈希环
@@ -42,82 +55,38 @@ func NewRbHashRing(nodes []string, virtualNodes int) *RbHashRing {
 	return ring
 }
 
-// addNode 将节点加入哈希环
+// AddNode 将节点及其虚拟节点加入哈希环
 func (r *RbHashRing) AddNode(node string) {
+	r.AddNodeWithWeight(node, 1)
+}
+
+// AddNodeWithWeight 按权重将节点加入哈希环，节点的虚拟节点数及分到的键与权重成正比，
+// 权重小于 1 时按 1 处理
+func (r *RbHashRing) AddNodeWithWeight(node string, weight int) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	NewConn, err := grpc.Dial(node, grpc.WithInsecure())
-	if err != nil {
-		fmt.Errorf("Failed To Connect To Server: %v", err)
-	}
-	defer NewConn.Close()
-	NewClient := pb.NewKVDBClient(NewConn)
-	if err != nil {
-		fmt.Errorf("Connected failed: %v", err)
+	if weight < 1 {
+		weight = 1
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-	defer cancel()
-	var Keys []string
-	var Values []string
-	for i := 0; i < r.virtualNodes; i++ {
+	r.weights[node] = weight
+	for i := 0; i < r.virtualNodes*weight; i++ {
 		virtualNode := node + "#" + strconv.Itoa(i)
-		hash := r.hash(virtualNode)
-		NextNode := r.rbTree.FindNextNode(rb.RbTreeKeyType(hash))
-		PreNode := r.rbTree.FindPreNode(rb.RbTreeKeyType(hash))
-
-		if NextNode == r.rbTree.Sentinel {
+		key := rb.RbTreeKeyType(r.hash(virtualNode))
+		r.size++
+		// 哈希值冲突时把节点加入已有树节点的列表，而不是覆盖
+		if existing := r.rbTree.GetNode(key); existing != r.rbTree.Sentinel {
+			owners := existing.Value.([]string)
+			idx := sort.SearchStrings(owners, node)
+			existing.Value = append(owners[:idx], append([]string{node}, owners[idx:]...)...)
 			continue
 		}
-		NextNodeValue, ok := NextNode.Value.(string)
-		if !ok {
-			fmt.Errorf("expected a string, got %T", NextNodeValue)
-			return
-		}
-		PreNodeKey := PreNode.Key
-		// if !ok {
-		// 	fmt.Errorf("expected a string, got %T", PreNodeKey)
-		// 	return
-		// }
-		NextConn, err := grpc.Dial(NextNodeValue, grpc.WithInsecure())
-		if err != nil {
-			fmt.Errorf("Failed To Connect To Server: %v", err)
-			return
-		}
-		defer NextConn.Close()
-		NextClinent := pb.NewKVDBClient(NextConn)
-		if err != nil {
-			fmt.Errorf("Connected failed: %v", err)
-		}
-		resp, err := NextClinent.ListAllData(ctx, &pb.Empty{})
-
-		if err != nil {
-			fmt.Errorf("GetAllData failed: %v", err)
-			return
-		}
-		for j := 0; j < len(resp.Keys); j++ {
-			NowHash := r.hash(resp.Keys[j])
-			if Judge(uint32(PreNodeKey), hash, NowHash) {
-				NextClinent.Delete(ctx, &pb.DeleteRequest{Key: resp.Keys[j]})
-				Keys = append(Keys, resp.Keys[j])
-				Values = append(Values, resp.Values[j])
-			}
-		}
-	}
-
-	for i := 0; i < r.virtualNodes; i++ {
-		virtualNode := node + "#" + strconv.Itoa(i)
-		hash := r.hash(virtualNode)
-		newNode := r.rbTree.NewRbTreeNode(rb.RbTreeKeyType(hash), node)
-		r.rbTree.InsertNewNode(newNode)
-	}
-
-	for i := 0; i < len(Keys); i++ {
-		NewClient.Put(ctx, &pb.PutRequest{Key: Keys[i], Value: Values[i]})
-		fmt.Printf("AddNode to Put %s - %s\n", Keys[i], Values[i])
+		r.rbTree.InsertNewNode(r.rbTree.NewRbTreeNode(key, []string{node}))
 	}
+	r.nodes = append(r.nodes, node)
 }
 
+// Judge 判断哈希值 now 是否落在 (pre, next] 区间内，区间可以跨过 0
 func Judge(pre uint32, next uint32, now uint32) bool {
 	if next >= pre {
 		return now > pre && next >= now
@@ -129,15 +98,45 @@ func Judge(pre uint32, next uint32, now uint32) bool {
 func (r *RbHashRing) RemoveNode(node string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	for i := 0; i < r.virtualNodes; i++ {
+	weight, ok := r.weights[node]
+	if !ok {
+		weight = 1
+	}
+	delete(r.weights, node)
+	for i := 0; i < r.virtualNodes*weight; i++ {
 		virtualNode := node + "#" + strconv.Itoa(i)
-		hash := r.hash(virtualNode)
-		r.rbTree.DeleteByKey(rb.RbTreeKeyType(hash))
+		existing := r.rbTree.GetNode(rb.RbTreeKeyType(r.hash(virtualNode)))
+		if existing == r.rbTree.Sentinel {
+			continue
+		}
+		// 只删除该节点的一个虚拟节点，哈希值相同的其他节点的虚拟节点保留
+		owners := existing.Value.([]string)
+		idx := sort.SearchStrings(owners, node)
+		if idx == len(owners) || owners[idx] != node {
+			continue
+		}
+		r.size--
+		if len(owners) == 1 {
+			r.rbTree.DeleteByNode(existing)
+			continue
+		}
+		existing.Value = append(owners[:idx:idx], owners[idx+1:]...)
+	}
+	for i, n := range r.nodes {
+		if n == node {
+			r.nodes = append(r.nodes[:i], r.nodes[i+1:]...)
+			break
+		}
 	}
 }
 
 func (r *RbHashRing) hash(value string) uint32 {
-	return crc32.ChecksumIEEE([]byte(value))
+	return r.hashFunc([]byte(value))
+}
+
+// Hash 返回键在哈希环上的位置
+func (r *RbHashRing) Hash(key string) uint32 {
+	return r.hash(key)
 }
 
 // Get 根据 key 查找对应的节点
@@ -147,17 +146,68 @@ func (r *RbHashRing) Get(key string) string {
 
 	// 计算 key 的哈希值
 	hash := r.hash(key)
-	// fmt.Printf("%s %d\n", key, hash)
 	node := r.rbTree.FindMaxKey(rb.RbTreeKeyType(hash))
-	value, ok := node.Value.(string)
-	if !ok {
-		fmt.Errorf("expected a string, got %T", value)
+	owners, _ := node.Value.([]string)
+	if len(owners) == 0 {
+		return ""
 	}
-	// fmt.Printf("%s %d\n", value, node.Key)
-	return value
+	return owners[0]
 }
 
-// func (r *RbHashRing) NewAddNode(key uint32) {
-// 	NextNode := r.rbTree.FindNextNode(rb.Uint32Key{Value: key})
+// SetZone 设置节点所在的可用区，zone 为空时清除
+func (r *RbHashRing) SetZone(node string, zone string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if zone == "" {
+		delete(r.zones, node)
+		return
+	}
+	r.zones[node] = zone
+}
 
-// }
+// GetN 返回从 key 开始顺时针遇到的 n 个不同的节点，第一个节点与 Get 的结果相同；
+// 环上的节点不足 n 个时返回所有节点
+func (r *RbHashRing) GetN(key string, n int) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.size == 0 {
+		return nil
+	}
+	// 依次返回每个树节点列表中的节点，与 HashRing 遍历虚拟节点的顺序一致
+	var node *rb.RbTreeNode
+	var owners []string
+	return pickN(n, r.size, r.zones, func() string {
+		if len(owners) == 0 {
+			if node == nil {
+				node = r.rbTree.FindMaxKey(rb.RbTreeKeyType(r.hash(key)))
+			} else {
+				node = r.rbTree.FindNextNode(node.Key)
+			}
+			owners = node.Value.([]string)
+		}
+		owner := owners[0]
+		owners = owners[1:]
+		return owner
+	})
+}
+
+// Points 按哈希值顺序返回环上的所有虚拟节点
+func (r *RbHashRing) Points() []Point {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	points := make([]Point, 0, r.size)
+	var walk func(node *rb.RbTreeNode)
+	walk = func(node *rb.RbTreeNode) {
+		if node == r.rbTree.Sentinel {
+			return
+		}
+		walk(node.Left)
+		for _, owner := range node.Value.([]string) {
+			points = append(points, Point{Hash: uint32(node.Key), Node: owner})
+		}
+		walk(node.Right)
+	}
+	walk(r.rbTree.Root)
+	return points
+}
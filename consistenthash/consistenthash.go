@@ -1,7 +1,6 @@
 package consistenthash
 
 import (
-	"hash/crc32"
 	"sort"
 	"strconv"
 	"sync"
@@ -14,29 +13,33 @@ const defaultVirtualNodes = 100
 type HashRing struct {
 	virtualNodes int               // 每个物理节点的虚拟节点数
 	nodes        []string          // 物理节点列表
-	nodeHashes   map[uint32]string // 哈希值到物理节点的映射
-	sortedHashes sortedHashes      // 排序后的哈希值
+	points       []Point           // 按哈希值和节点名排序的虚拟节点
+	hashFunc     HashFunc          // 计算虚拟节点和键在环上的位置
+	zones        map[string]string // 物理节点所在的可用区，GetN 优先选择不同可用区的节点
+	weights      map[string]int    // 物理节点的权重，虚拟节点数为 virtualNodes 乘以权重
 	mu           sync.RWMutex      // 锁，保护并发访问
 }
 
-// sortedHashes 是自定义的类型，包装了 uint32 类型的切片
-type sortedHashes []uint32
-
-// 实现 sort.Interface 接口方法
-func (s sortedHashes) Len() int           { return len(s) }
-func (s sortedHashes) Less(i, j int) bool { return s[i] < s[j] }
-func (s sortedHashes) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
-
-// NewHashRing 创建一个新的带虚拟节点的一致性哈希环
+// NewHashRing 创建一个新的带虚拟节点的一致性哈希环，使用 CRC32 计算哈希值
 func NewHashRing(nodes []string, virtualNodes int) *HashRing {
+	return NewHashRingWithHash(nodes, virtualNodes, CRC32)
+}
+
+// NewHashRingWithHash 创建使用指定哈希函数的一致性哈希环，hashFunc 为 nil 时使用 CRC32
+func NewHashRingWithHash(nodes []string, virtualNodes int, hashFunc HashFunc) *HashRing {
 	if virtualNodes <= 0 {
 		virtualNodes = defaultVirtualNodes
 	}
+	if hashFunc == nil {
+		hashFunc = CRC32
+	}
 
 	ring := &HashRing{
 		virtualNodes: virtualNodes,
 		nodes:        nodes,
-		nodeHashes:   make(map[uint32]string),
+		hashFunc:     hashFunc,
+		zones:        make(map[string]string),
+		weights:      make(map[string]int),
 	}
 
 	// 将每个物理节点添加到哈希环
@@ -49,21 +52,27 @@ func NewHashRing(nodes []string, virtualNodes int) *HashRing {
 
 // AddNode 将物理节点及其虚拟节点加入哈希环
 func (r *HashRing) AddNode(node string) {
+	r.AddNodeWithWeight(node, 1)
+}
+
+// AddNodeWithWeight 按权重将物理节点加入哈希环，节点的虚拟节点数及分到的键与权重成正比，
+// 权重小于 1 时按 1 处理
+func (r *HashRing) AddNodeWithWeight(node string, weight int) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	// 为每个物理节点创建多个虚拟节点
-	for i := 0; i < r.virtualNodes; i++ {
+	if weight < 1 {
+		weight = 1
+	}
+	r.weights[node] = weight
+	// 为每个物理节点创建多个虚拟节点，与其他虚拟节点哈希值相同的虚拟节点同样保留
+	for i := 0; i < r.virtualNodes*weight; i++ {
 		virtualNode := node + "#" + strconv.Itoa(i)
-		hash := r.hash(virtualNode)
-
-		// 记录虚拟节点的哈希值与物理节点的映射
-		r.nodeHashes[hash] = node
-		r.sortedHashes = append(r.sortedHashes, hash)
+		r.points = append(r.points, Point{Hash: r.hash(virtualNode), Node: node})
 	}
 
-	// 保持哈希值排序
-	sort.Sort(r.sortedHashes)
+	// 保持排序，哈希值相同时按节点名排序
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i].less(r.points[j]) })
 }
 
 // RemoveNode 从哈希环中移除节点及其虚拟节点
@@ -71,27 +80,60 @@ func (r *HashRing) RemoveNode(node string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	// 删除节点的所有虚拟节点
-	for i := 0; i < r.virtualNodes; i++ {
-		virtualNode := node + "#" + strconv.Itoa(i)
-		hash := r.hash(virtualNode)
-
-		// 删除虚拟节点的哈希值
-		delete(r.nodeHashes, hash)
-
-		// 删除排序后的哈希值
-		for i, v := range r.sortedHashes {
-			if v == hash {
-				r.sortedHashes = append(r.sortedHashes[:i], r.sortedHashes[i+1:]...)
-				break
-			}
+	delete(r.weights, node)
+	// 只删除属于该节点的虚拟节点，哈希值相同的其他节点的虚拟节点不受影响
+	points := r.points[:0]
+	for _, p := range r.points {
+		if p.Node != node {
+			points = append(points, p)
 		}
 	}
+	r.points = points
 }
 
-// hash 使用 crc32 哈希函数来计算节点或键的哈希值
+// hash 计算节点或键的哈希值
 func (r *HashRing) hash(value string) uint32 {
-	return crc32.ChecksumIEEE([]byte(value))
+	return r.hashFunc([]byte(value))
+}
+
+// Hash 返回键在哈希环上的位置
+func (r *HashRing) Hash(key string) uint32 {
+	return r.hash(key)
+}
+
+// Points 按哈希值顺序返回环上的所有虚拟节点
+func (r *HashRing) Points() []Point {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]Point(nil), r.points...)
+}
+
+// SetZone 设置节点所在的可用区，zone 为空时清除
+func (r *HashRing) SetZone(node string, zone string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if zone == "" {
+		delete(r.zones, node)
+		return
+	}
+	r.zones[node] = zone
+}
+
+// GetN 返回从 key 开始顺时针遇到的 n 个不同的物理节点，第一个节点与 Get 的结果相同；
+// 环上的节点不足 n 个时返回所有节点
+func (r *HashRing) GetN(key string, n int) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.points) == 0 {
+		return nil
+	}
+	idx := r.search(r.hash(key))
+	return pickN(n, len(r.points), r.zones, func() string {
+		node := r.points[idx%len(r.points)].Node
+		idx++
+		return node
+	})
 }
 
 // Get 根据 key 查找对应的节点
@@ -99,19 +141,23 @@ func (r *HashRing) Get(key string) string {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	// 计算 key 的哈希值
-	hash := r.hash(key)
+	if len(r.points) == 0 {
+		return ""
+	}
+
+	// 返回第一个哈希值大于等于 key 的哈希值的虚拟节点所属的物理节点，
+	// 多个虚拟节点哈希值相同时属于节点名最小的节点
+	return r.points[r.search(r.hash(key))].Node
+}
 
-	// 找到大于等于 hash 的最小的哈希值
-	idx := sort.Search(len(r.sortedHashes), func(i int) bool {
-		return r.sortedHashes[i] >= hash
+// search 返回第一个哈希值大于等于 hash 的虚拟节点，没有找到时环绕回到第一个虚拟节点
+// hold r.mu before accessing this method
+func (r *HashRing) search(hash uint32) int {
+	idx := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i].Hash >= hash
 	})
-
-	// 如果没有找到，则环绕回到第一个节点
-	if idx == len(r.sortedHashes) {
+	if idx == len(r.points) {
 		idx = 0
 	}
-
-	// 返回该虚拟节点映射的物理节点
-	return r.nodeHashes[r.sortedHashes[idx]]
+	return idx
 }
@@ -0,0 +1,167 @@
+package consistenthash
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRbHashRing(t *testing.T) {
+	rbRing := NewRbHashRing([]string{"a", "b", "c"}, 20)
+	ring := NewHashRing([]string{"a", "b", "c"}, 20)
+	assert.Equal(t, ring.Points(), rbRing.Points())
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		assert.Equal(t, ring.Get(key), rbRing.Get(key))
+	}
+
+	rbRing.RemoveNode("b")
+	ring.RemoveNode("b")
+	assert.Equal(t, 40, len(rbRing.Points()))
+	assert.Equal(t, ring.Points(), rbRing.Points())
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		assert.NotEqual(t, "b", rbRing.Get(key))
+		assert.Equal(t, ring.Get(key), rbRing.Get(key))
+	}
+
+	// the red-black tree stays ordered through many removals
+	for _, node := range []string{"d", "e", "f"} {
+		rbRing.AddNode(node)
+		ring.AddNode(node)
+	}
+	for _, node := range []string{"a", "e", "c"} {
+		rbRing.RemoveNode(node)
+		ring.RemoveNode(node)
+		assert.Equal(t, ring.Points(), rbRing.Points())
+	}
+
+	empty := NewRbHashRing(nil, 20)
+	assert.Equal(t, "", empty.Get("key"))
+	assert.Equal(t, "", NewHashRing(nil, 20).Get("key"))
+}
+
+func TestGetN(t *testing.T) {
+	rbRing := NewRbHashRing([]string{"a", "b", "c", "d"}, 20)
+	ring := NewHashRing([]string{"a", "b", "c", "d"}, 20)
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		nodes := ring.GetN(key, 3)
+		assert.Equal(t, nodes, rbRing.GetN(key, 3))
+		assert.Equal(t, 3, len(nodes))
+		assert.Equal(t, ring.Get(key), nodes[0])
+		assert.NotEqual(t, nodes[0], nodes[1])
+		assert.NotEqual(t, nodes[1], nodes[2])
+		assert.NotEqual(t, nodes[0], nodes[2])
+
+		// the replicas are the next distinct nodes clockwise
+		points := ring.Points()
+		start := 0
+		for start < len(points) && points[start].Hash < ring.Hash(key) {
+			start++
+		}
+		var walked []string
+		for j := 0; j < len(points) && len(walked) < 3; j++ {
+			if node := points[(start+j)%len(points)].Node; !contains(walked, node) {
+				walked = append(walked, node)
+			}
+		}
+		assert.Equal(t, walked, nodes)
+	}
+	assert.Equal(t, 4, len(ring.GetN("key", 10)))
+	assert.Nil(t, ring.GetN("key", 0))
+	assert.Nil(t, NewHashRing(nil, 20).GetN("key", 3))
+	assert.Nil(t, NewRbHashRing(nil, 20).GetN("key", 3))
+}
+
+func TestGetN_Zones(t *testing.T) {
+	rbRing := NewRbHashRing([]string{"a1", "a2", "b1", "b2", "c1"}, 20)
+	ring := NewHashRing([]string{"a1", "a2", "b1", "b2", "c1"}, 20)
+	for _, node := range []string{"a1", "a2", "b1", "b2", "c1"} {
+		ring.SetZone(node, node[:1])
+		rbRing.SetZone(node, node[:1])
+	}
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		nodes := ring.GetN(key, 3)
+		assert.Equal(t, nodes, rbRing.GetN(key, 3))
+		assert.Equal(t, ring.Get(key), nodes[0])
+		zones := map[byte]bool{}
+		for _, node := range nodes {
+			zones[node[0]] = true
+		}
+		assert.Equal(t, 3, len(zones), "replicas of %s are %v", key, nodes)
+
+		// with fewer zones than replicas the ring fills up with nodes of a used zone
+		assert.Equal(t, 5, len(ring.GetN(key, 5)))
+	}
+}
+
+func contains(list []string, item string) bool {
+	for _, v := range list {
+		if v == item {
+			return true
+		}
+	}
+	return false
+}
+
+// loadSkew returns the largest relative difference between the share of keys
+// a node gets and the share its weight entitles it to
+func loadSkew(ring HashRingInterface, weights map[string]int, keys int) float64 {
+	counts := make(map[string]int)
+	for i := 0; i < keys; i++ {
+		counts[ring.Get(fmt.Sprintf("key-%d", i))]++
+	}
+	total := 0
+	for _, weight := range weights {
+		total += weight
+	}
+	skew := 0.0
+	for node, weight := range weights {
+		expected := float64(keys) * float64(weight) / float64(total)
+		if d := math.Abs(float64(counts[node])-expected) / expected; d > skew {
+			skew = d
+		}
+	}
+	return skew
+}
+
+func TestAddNodeWithWeight(t *testing.T) {
+	weights := map[string]int{"small": 1, "medium": 2, "large": 8}
+	rbRing := NewRbHashRing(nil, 100)
+	ring := NewHashRing(nil, 100)
+	for node, weight := range weights {
+		rbRing.AddNodeWithWeight(node, weight)
+		ring.AddNodeWithWeight(node, weight)
+	}
+	assert.Equal(t, 1100, len(ring.Points()))
+	assert.Equal(t, ring.Points(), rbRing.Points())
+
+	// CRC32 of similar virtual node names is uneven, so the bound is loose
+	skew := loadSkew(ring, weights, 100000)
+	t.Logf("weighted skew %.3f", skew)
+	assert.Less(t, skew, 0.5)
+	assert.Equal(t, skew, loadSkew(rbRing, weights, 100000))
+	counts := make(map[string]int)
+	for i := 0; i < 100000; i++ {
+		counts[ring.Get(fmt.Sprintf("key-%d", i))]++
+	}
+	assert.Less(t, counts["small"], counts["medium"])
+	assert.Less(t, counts["medium"], counts["large"])
+
+	equal := map[string]int{"a": 1, "b": 1, "c": 1, "d": 1}
+	skew = loadSkew(NewHashRing([]string{"a", "b", "c", "d"}, 100), equal, 100000)
+	t.Logf("unweighted skew %.3f", skew)
+	assert.Less(t, skew, 0.5)
+
+	// removing a weighted node removes all its virtual nodes
+	rbRing.RemoveNode("large")
+	ring.RemoveNode("large")
+	assert.Equal(t, 300, len(ring.Points()))
+	assert.Equal(t, ring.Points(), rbRing.Points())
+	delete(weights, "large")
+	assert.Less(t, loadSkew(ring, weights, 100000), 0.5)
+}
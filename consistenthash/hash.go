@@ -0,0 +1,48 @@
+package consistenthash
+
+import (
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/twmb/murmur3"
+)
+
+// HashFunc 计算虚拟节点和键在哈希环上的位置，HashRing 和 RbHashRing 使用相同的哈希函数时结果一致
+type HashFunc func(data []byte) uint32
+
+var (
+	// CRC32 是默认的哈希函数，相似的短字符串（例如同一节点的虚拟节点）在环上分布不均匀
+	CRC32 HashFunc = crc32.ChecksumIEEE
+	// FNV 使用 32 位的 FNV-1a
+	FNV HashFunc = func(data []byte) uint32 {
+		h := fnv.New32a()
+		h.Write(data)
+		return h.Sum32()
+	}
+	// Murmur3 使用 32 位的 MurmurHash3
+	Murmur3 HashFunc = func(data []byte) uint32 {
+		return murmur3.Sum32(data)
+	}
+	// XXHash 取 64 位 xxHash 的低 32 位
+	XXHash HashFunc = func(data []byte) uint32 {
+		return uint32(xxhash.Sum64(data))
+	}
+)
+
+// ParseHashFunc 按名称返回哈希函数：crc32、fnv、murmur3 或 xxhash，名称为空时返回 CRC32
+func ParseHashFunc(name string) (HashFunc, error) {
+	switch name {
+	case "", "crc32":
+		return CRC32, nil
+	case "fnv":
+		return FNV, nil
+	case "murmur3":
+		return Murmur3, nil
+	case "xxhash":
+		return XXHash, nil
+	default:
+		return nil, fmt.Errorf("unknown hash function %q", name)
+	}
+}
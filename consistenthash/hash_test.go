@@ -0,0 +1,99 @@
+package consistenthash
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// coarseHash maps everything to 64 positions, so virtual nodes collide all the time
+func coarseHash(data []byte) uint32 {
+	return CRC32(data) % 64 << 26
+}
+
+func TestHashRing_Collisions(t *testing.T) {
+	nodes := []string{"a", "b", "c"}
+	ring := NewHashRingWithHash(nodes, 50, coarseHash)
+	rbRing := NewRbHashRingWithHash(nodes, 50, coarseHash)
+	assert.Equal(t, 150, len(ring.Points()))
+	assert.Equal(t, ring.Points(), rbRing.Points())
+
+	// the placement does not depend on the order the nodes joined in
+	reversed := NewRbHashRingWithHash([]string{"c", "b", "a"}, 50, coarseHash)
+	assert.Equal(t, ring.Points(), reversed.Points())
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		assert.Equal(t, ring.Get(key), rbRing.Get(key))
+		assert.Equal(t, ring.Get(key), reversed.Get(key))
+		assert.Equal(t, ring.GetN(key, 3), rbRing.GetN(key, 3))
+	}
+
+	// removing a node keeps the virtual nodes of the others at the same positions
+	ring.RemoveNode("a")
+	rbRing.RemoveNode("a")
+	assert.Equal(t, 100, len(ring.Points()))
+	assert.Equal(t, ring.Points(), rbRing.Points())
+	assert.Equal(t, NewHashRingWithHash([]string{"b", "c"}, 50, coarseHash).Points(), ring.Points())
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		assert.NotEqual(t, "a", ring.Get(key))
+		assert.Equal(t, ring.Get(key), rbRing.Get(key))
+	}
+}
+
+func TestParseHashFunc(t *testing.T) {
+	for _, name := range []string{"", "crc32", "fnv", "murmur3", "xxhash"} {
+		hash, err := ParseHashFunc(name)
+		assert.Nil(t, err)
+		assert.NotNil(t, hash)
+	}
+	_, err := ParseHashFunc("md5")
+	assert.NotNil(t, err)
+}
+
+// TestHashFunc_Distribution measures for every hash function how evenly short
+// sequential keys spread over 256 buckets, and how evenly a ring of 10 nodes
+// with 400 virtual nodes each shares them. A random function gives a
+// chi-square around 255; CRC32 is far below, its output is too regular.
+// FNV-1a spreads keys well but places similar virtual node names unevenly.
+func TestHashFunc_Distribution(t *testing.T) {
+	hashes := []struct {
+		name    string
+		hash    HashFunc
+		random  bool    // the chi-square must look like that of a random function
+		maxSkew float64 // 0 when the ring skew is only reported
+	}{
+		{"crc32", CRC32, false, 0},
+		{"fnv", FNV, true, 0},
+		{"murmur3", Murmur3, true, 0.2},
+		{"xxhash", XXHash, true, 0.2},
+	}
+	const keys = 100000
+	nodes := nodeNames(10)
+	for _, h := range hashes {
+		t.Run(h.name, func(t *testing.T) {
+			// chi-square of the top 8 bits against a uniform distribution, 255 degrees of freedom
+			var buckets [256]int
+			for i := 0; i < keys; i++ {
+				buckets[h.hash([]byte(fmt.Sprintf("key-%d", i)))>>24]++
+			}
+			chiSquare := 0.0
+			expected := float64(keys) / 256
+			for _, count := range buckets {
+				chiSquare += (float64(count) - expected) * (float64(count) - expected) / expected
+			}
+
+			ring := NewHashRingWithHash(nodes, 400, h.hash)
+			skew := loadSkew(ring, weightsOf(nodes), keys)
+			t.Logf("chi-square %.1f, ring skew %.3f", chiSquare, skew)
+			if h.random {
+				assert.InDelta(t, 255, chiSquare, 110)
+			}
+			if h.maxSkew > 0 {
+				assert.Less(t, skew, h.maxSkew)
+			}
+			assert.Equal(t, ring.Points(), NewRbHashRingWithHash(nodes, 400, h.hash).Points())
+		})
+	}
+}
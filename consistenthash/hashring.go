@@ -6,3 +6,14 @@ type HashRingInterface interface {
 	RemoveNode(node string)
 	Get(key string) string
 }
+
+// Ring 是由虚拟节点组成的哈希环，节点变化前后的 Points 可以交给 PlanMigration 规划数据迁移，
+// GetN 返回的节点可以作为键的副本所在的节点
+type Ring interface {
+	HashRingInterface
+	AddNodeWithWeight(node string, weight int)
+	Hash(key string) uint32
+	Points() []Point
+	GetN(key string, n int) []string
+	SetZone(node string, zone string)
+}
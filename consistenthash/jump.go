@@ -0,0 +1,67 @@
+package consistenthash
+
+import "sync"
+
+// JumpHash 使用 Jump Consistent Hash（Lamping 和 Veach）将键分配到编号连续的桶中，
+// 不需要虚拟节点，内存占用只有节点列表，各节点分到的键几乎完全均匀。
+// 在末尾添加节点时只有移到新节点的键发生移动；删除中间的节点时，
+// 最后一个节点换到被删除节点的位置，最后一个节点原有的键会重新分配到其他节点
+type JumpHash struct {
+	nodes []string // 第 i 个桶对应的节点
+	mu    sync.RWMutex
+}
+
+func NewJumpHash(nodes []string) *JumpHash {
+	j := &JumpHash{}
+	for _, node := range nodes {
+		j.AddNode(node)
+	}
+	return j
+}
+
+// AddNode 将节点作为新的桶加在末尾
+func (j *JumpHash) AddNode(node string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, n := range j.nodes {
+		if n == node {
+			return
+		}
+	}
+	j.nodes = append(j.nodes, node)
+}
+
+// RemoveNode 删除节点，最后一个节点换到它的位置
+func (j *JumpHash) RemoveNode(node string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for i, n := range j.nodes {
+		if n == node {
+			last := len(j.nodes) - 1
+			j.nodes[i] = j.nodes[last]
+			j.nodes = j.nodes[:last]
+			return
+		}
+	}
+}
+
+// Get 根据 key 查找对应的节点
+func (j *JumpHash) Get(key string) string {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	if len(j.nodes) == 0 {
+		return ""
+	}
+	return j.nodes[jumpHash(hash64(key), len(j.nodes))]
+}
+
+// jumpHash 返回键在 buckets 个桶中所属的桶
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
@@ -0,0 +1,72 @@
+package consistenthash
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// partitioners builds every partitioning algorithm over the same nodes
+var partitioners = []struct {
+	name string
+	new  func(nodes []string) HashRingInterface
+}{
+	{"HashRing", func(nodes []string) HashRingInterface { return NewHashRing(nodes, 100) }},
+	{"RbHashRing", func(nodes []string) HashRingInterface { return NewRbHashRing(nodes, 100) }},
+	{"JumpHash", func(nodes []string) HashRingInterface { return NewJumpHash(nodes) }},
+	{"Rendezvous", func(nodes []string) HashRingInterface { return NewRendezvous(nodes) }},
+	{"RangePartitioner", func(nodes []string) HashRingInterface { return NewRangePartitioner(nodes) }},
+}
+
+// uniformKeys returns keys spread evenly over the key space, so the range
+// partitioner is compared on the distribution it is designed for
+func uniformKeys(n int) []string {
+	keys := make([]string, n)
+	var buf [8]byte
+	for i := range keys {
+		binary.BigEndian.PutUint64(buf[:], mix64(uint64(i)))
+		keys[i] = string(buf[:])
+	}
+	return keys
+}
+
+// BenchmarkPartitioners measures the lookup time of each algorithm and reports
+// how evenly 10 nodes share the keys (skew, the largest relative difference
+// from an equal share) and which fraction of the keys moves when an 11th node
+// joins (moved, ideally 1/11 = 0.091).
+func BenchmarkPartitioners(b *testing.B) {
+	keys := uniformKeys(100000)
+	for _, p := range partitioners {
+		b.Run(p.name, func(b *testing.B) {
+			ring := p.new(nodeNames(10))
+			counts := make(map[string]int)
+			before := make([]string, len(keys))
+			for i, key := range keys {
+				before[i] = ring.Get(key)
+				counts[before[i]]++
+			}
+			skew := 0.0
+			expected := float64(len(keys)) / 10
+			for _, count := range counts {
+				skew = max(skew, math.Abs(float64(count)/expected-1))
+			}
+			if len(counts) < 10 {
+				skew = 1
+			}
+			ring.AddNode("node-10")
+			moved := 0
+			for i, key := range keys {
+				if ring.Get(key) != before[i] {
+					moved++
+				}
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				ring.Get(keys[i%len(keys)])
+			}
+			b.ReportMetric(skew, "skew")
+			b.ReportMetric(float64(moved)/float64(len(keys)), "moved")
+		})
+	}
+}
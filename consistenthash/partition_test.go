@@ -0,0 +1,114 @@
+package consistenthash
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func nodeNames(n int) []string {
+	nodes := make([]string, n)
+	for i := range nodes {
+		nodes[i] = fmt.Sprintf("node-%d", i)
+	}
+	return nodes
+}
+
+// placement returns the owner of each test key
+func placement(p HashRingInterface, keys int) []string {
+	owners := make([]string, keys)
+	for i := range owners {
+		owners[i] = p.Get(fmt.Sprintf("key-%d", i))
+	}
+	return owners
+}
+
+func TestJumpHash(t *testing.T) {
+	j := NewJumpHash(nodeNames(10))
+	before := placement(j, 10000)
+	j.AddNode("node-10")
+	after := placement(j, 10000)
+	moved := 0
+	for i := range before {
+		if before[i] != after[i] {
+			assert.Equal(t, "node-10", after[i])
+			moved++
+		}
+	}
+	assert.InDelta(t, 10000/11, moved, 150)
+	assert.Less(t, loadSkew(j, weightsOf(nodeNames(11)), 100000), 0.05)
+
+	// removing the last node moves only its keys back
+	j.RemoveNode("node-10")
+	assert.Equal(t, before, placement(j, 10000))
+
+	// removing another node moves its keys and those of the last node
+	j.RemoveNode("node-3")
+	for i, owner := range placement(j, 10000) {
+		assert.NotEqual(t, "node-3", owner)
+		if before[i] != "node-3" && before[i] != "node-9" {
+			assert.Equal(t, before[i], owner)
+		}
+	}
+	assert.Equal(t, "", NewJumpHash(nil).Get("key"))
+}
+
+func TestRendezvous(t *testing.T) {
+	r := NewRendezvous(nodeNames(10))
+	before := placement(r, 10000)
+	assert.Less(t, loadSkew(r, weightsOf(nodeNames(10)), 100000), 0.05)
+
+	r.RemoveNode("node-3")
+	for i, owner := range placement(r, 10000) {
+		assert.NotEqual(t, "node-3", owner)
+		if before[i] != "node-3" {
+			assert.Equal(t, before[i], owner)
+		}
+	}
+	// the order nodes join in does not matter
+	r.AddNode("node-3")
+	assert.Equal(t, before, placement(r, 10000))
+	assert.Equal(t, "", NewRendezvous(nil).Get("key"))
+}
+
+func TestRangePartitioner(t *testing.T) {
+	p := NewRangePartitioner([]string{"a", "b", "c", "d"})
+	// the key space is cut in four equal ranges
+	assert.Equal(t, []string{"", "\x40", "\x80", "\xc0"}, p.starts)
+	assert.Equal(t, []string{"a", "c", "b", "d"}, p.owners)
+	assert.Equal(t, "a", p.Get(""))
+	assert.Equal(t, "a", p.Get("\x3f\xff"))
+	assert.Equal(t, "c", p.Get("\x40"))
+	assert.Equal(t, "d", p.Get("\xff"))
+
+	// keys sharing a prefix stay together, so a scan reaches a single node
+	p.Split("user:", "e")
+	p.Split("user;", "c")
+	assert.Equal(t, "e", p.Get("user:1"))
+	assert.Equal(t, "e", p.Get("user:zzz"))
+	assert.Equal(t, "c", p.Get("user;"))
+	assert.Equal(t, []string{"e"}, p.Owners("user:", "user;"))
+	assert.Equal(t, []string{"c", "e", "c", "b", "d"}, p.Owners("\x40", ""))
+	assert.Nil(t, p.Owners("b", "a"))
+
+	// the ranges of a removed node join the previous range
+	p.RemoveNode("e")
+	assert.Equal(t, "c", p.Get("user:1"))
+	assert.Equal(t, []string{"", "\x40", "\x80", "\xc0"}, p.starts)
+	p.RemoveNode("a")
+	assert.Equal(t, "c", p.Get(""))
+	assert.Equal(t, []string{"", "\x80", "\xc0"}, p.starts)
+	for _, node := range []string{"b", "c", "d"} {
+		p.RemoveNode(node)
+	}
+	assert.Equal(t, "", p.Get("key"))
+}
+
+func weightsOf(nodes []string) map[string]int {
+	weights := make(map[string]int)
+	for _, node := range nodes {
+		weights[node] = 1
+	}
+	return weights
+}
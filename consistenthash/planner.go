@@ -0,0 +1,91 @@
+package consistenthash
+
+import "sort"
+
+// Point 是环上的一个虚拟节点，哈希值落在 (上一个虚拟节点, Hash] 中的键属于 Node。
+// 多个虚拟节点的哈希值相同时，它们按节点名排序，区间属于节点名最小的节点
+type Point struct {
+	Hash uint32
+	Node string
+}
+
+func (p Point) less(other Point) bool {
+	return p.Hash < other.Hash || (p.Hash == other.Hash && p.Node < other.Node)
+}
+
+// Range 是哈希空间中的区间 (Start, End]，End 不大于 Start 时区间跨过 0，
+// Start 与 End 相等时为整个哈希空间
+type Range struct {
+	Start uint32
+	End   uint32
+}
+
+// Contains 判断哈希值是否落在区间内
+func (r Range) Contains(hash uint32) bool {
+	if r.Start == r.End {
+		return true
+	}
+	return Judge(r.Start, r.End, hash)
+}
+
+// Move 表示区间中的键需要从 From 迁移到 To
+type Move struct {
+	Range Range
+	From  string
+	To    string
+}
+
+// PlanMigration 比较节点变化前后的哈希环，返回所有者发生变化的区间，
+// 相邻且迁移方向相同的区间会被合并。任一哈希环为空时没有需要迁移的数据。
+func PlanMigration(old []Point, new []Point) []Move {
+	if len(old) == 0 || len(new) == 0 {
+		return nil
+	}
+	bounds := make([]uint32, 0, len(old)+len(new))
+	for _, p := range old {
+		bounds = append(bounds, p.Hash)
+	}
+	for _, p := range new {
+		bounds = append(bounds, p.Hash)
+	}
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+	unique := bounds[:1]
+	for _, b := range bounds[1:] {
+		if b != unique[len(unique)-1] {
+			unique = append(unique, b)
+		}
+	}
+	bounds = unique
+
+	// 相邻边界之间的区间在两个哈希环上都只属于一个节点，即区间终点所属的节点
+	var moves []Move
+	for i, end := range bounds {
+		start := bounds[(i+len(bounds)-1)%len(bounds)]
+		from, to := ownerOf(old, end), ownerOf(new, end)
+		if from == to {
+			continue
+		}
+		if n := len(moves); n > 0 && moves[n-1].From == from && moves[n-1].To == to && moves[n-1].Range.End == start {
+			moves[n-1].Range.End = end
+			continue
+		}
+		moves = append(moves, Move{Range: Range{Start: start, End: end}, From: from, To: to})
+	}
+	// 合并跨过 0 的区间
+	if n := len(moves); n > 1 && moves[n-1].From == moves[0].From && moves[n-1].To == moves[0].To && moves[n-1].Range.End == moves[0].Range.Start {
+		moves[0].Range.Start = moves[n-1].Range.Start
+		moves = moves[:n-1]
+	}
+	return moves
+}
+
+// ownerOf 返回哈希值在按哈希值排序的虚拟节点中所属的节点
+func ownerOf(points []Point, hash uint32) string {
+	idx := sort.Search(len(points), func(i int) bool {
+		return points[i].Hash >= hash
+	})
+	if idx == len(points) {
+		idx = 0
+	}
+	return points[idx].Node
+}
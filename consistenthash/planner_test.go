@@ -0,0 +1,67 @@
+package consistenthash
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRange_Contains(t *testing.T) {
+	assert.True(t, Range{Start: 10, End: 20}.Contains(20))
+	assert.False(t, Range{Start: 10, End: 20}.Contains(10))
+	assert.True(t, Range{Start: 20, End: 10}.Contains(5))
+	assert.True(t, Range{Start: 20, End: 10}.Contains(30))
+	assert.False(t, Range{Start: 20, End: 10}.Contains(15))
+	assert.True(t, Range{Start: 7, End: 7}.Contains(100))
+}
+
+func TestPlanMigration(t *testing.T) {
+	// checkPlan verifies that exactly the keys changing owners are covered by a move
+	checkPlan := func(old, new Ring, moves []Move) {
+		for i := 0; i < 10000; i++ {
+			key := fmt.Sprintf("key-%d", i)
+			hash := old.Hash(key)
+			var covering []Move
+			for _, move := range moves {
+				if move.Range.Contains(hash) {
+					covering = append(covering, move)
+				}
+			}
+			if from, to := old.Get(key), new.Get(key); from == to {
+				assert.Empty(t, covering, key)
+			} else if assert.Len(t, covering, 1, key) {
+				assert.Equal(t, from, covering[0].From)
+				assert.Equal(t, to, covering[0].To)
+			}
+		}
+	}
+
+	old := NewHashRing([]string{"a", "b", "c"}, 50)
+	new := NewHashRing([]string{"a", "b", "c", "d"}, 50)
+	moves := PlanMigration(old.Points(), new.Points())
+	assert.NotEmpty(t, moves)
+	for _, move := range moves {
+		// a new node only takes keys, nothing moves between the old nodes
+		assert.Equal(t, "d", move.To)
+	}
+	checkPlan(old, new, moves)
+
+	moves = PlanMigration(new.Points(), old.Points())
+	for _, move := range moves {
+		assert.Equal(t, "d", move.From)
+	}
+	checkPlan(new, old, moves)
+
+	// adjacent ranges moving the same way are merged
+	assert.True(t, len(moves) <= 50)
+
+	assert.Empty(t, PlanMigration(old.Points(), old.Points()))
+	assert.Empty(t, PlanMigration(nil, old.Points()))
+
+	// a single node takes the whole ring
+	single := NewHashRing([]string{"a"}, 1)
+	moves = PlanMigration(single.Points(), NewHashRing([]string{"b"}, 1).Points())
+	assert.Len(t, moves, 1)
+	assert.True(t, moves[0].Range.Contains(0))
+}
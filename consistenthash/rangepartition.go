@@ -0,0 +1,148 @@
+package consistenthash
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// RangePartitioner 按键的字典序将键空间划分为连续的区间，每个区间属于一个节点。
+// 相邻的键大多在同一个节点上，范围查询只需要访问与范围相交的区间所属的节点（见 Owners）。
+// AddNode 平分键空间中最宽的区间，不了解键的分布；键集中在某些前缀下时用 Split 指定分割点
+type RangePartitioner struct {
+	starts []string // 排序后的区间起点，第一个区间从空字符串开始，区间 i 为 [starts[i], starts[i+1])
+	owners []string // 各区间所属的节点
+	mu     sync.RWMutex
+}
+
+func NewRangePartitioner(nodes []string) *RangePartitioner {
+	p := &RangePartitioner{}
+	for _, node := range nodes {
+		p.AddNode(node)
+	}
+	return p
+}
+
+// AddNode 将最宽的区间的后一半分给节点，区间按键的前 8 个字节衡量宽度
+func (p *RangePartitioner) AddNode(node string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.starts) == 0 {
+		p.starts, p.owners = []string{""}, []string{node}
+		return
+	}
+	var mid, widest uint64
+	for i, start := range p.starts {
+		// 最后一个区间的上界为 2^64
+		lo, half := keyPrefix(start), (math.MaxUint64-keyPrefix(start))/2+1
+		if i+1 < len(p.starts) {
+			half = (keyPrefix(p.starts[i+1]) - lo) / 2
+		}
+		if half > widest {
+			mid, widest = lo+half, half
+		}
+	}
+	// 区间已经窄到无法再分
+	if widest == 0 {
+		return
+	}
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], mid)
+	p.split(strings.TrimRight(string(buf[:]), "\x00"), node)
+}
+
+// Split 将从 key 开始到下一个分割点的区间分给节点
+func (p *RangePartitioner) Split(key string, node string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.starts) == 0 {
+		p.starts, p.owners = []string{""}, []string{node}
+	}
+	p.split(key, node)
+}
+
+// hold p.mu before accessing this method
+func (p *RangePartitioner) split(key string, node string) {
+	i := sort.SearchStrings(p.starts, key)
+	if i < len(p.starts) && p.starts[i] == key {
+		p.owners[i] = node
+		return
+	}
+	p.starts = append(p.starts[:i], append([]string{key}, p.starts[i:]...)...)
+	p.owners = append(p.owners[:i], append([]string{node}, p.owners[i:]...)...)
+}
+
+// RemoveNode 将节点的区间并入前一个区间，第一个区间并入后一个区间
+func (p *RangePartitioner) RemoveNode(node string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := 0; i < len(p.starts); {
+		if p.owners[i] != node {
+			i++
+			continue
+		}
+		switch {
+		case i > 0:
+			p.starts = append(p.starts[:i], p.starts[i+1:]...)
+			p.owners = append(p.owners[:i], p.owners[i+1:]...)
+		case len(p.starts) > 1:
+			// 第二个区间的起点变为空字符串
+			p.starts = append(p.starts[:1], p.starts[2:]...)
+			p.owners = p.owners[1:]
+		default:
+			p.starts, p.owners = nil, nil
+		}
+	}
+	// 合并属于同一个节点的相邻区间
+	for i := 1; i < len(p.starts); {
+		if p.owners[i] == p.owners[i-1] {
+			p.starts = append(p.starts[:i], p.starts[i+1:]...)
+			p.owners = append(p.owners[:i], p.owners[i+1:]...)
+			continue
+		}
+		i++
+	}
+}
+
+// Get 返回键所在区间所属的节点
+func (p *RangePartitioner) Get(key string) string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if len(p.starts) == 0 {
+		return ""
+	}
+	return p.owners[p.index(key)]
+}
+
+// Owners 按键的顺序返回与 [start, end) 相交的区间所属的节点，end 为空时没有上界
+func (p *RangePartitioner) Owners(start string, end string) []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if len(p.starts) == 0 || (end != "" && end <= start) {
+		return nil
+	}
+	var owners []string
+	for i := p.index(start); i < len(p.starts) && (end == "" || p.starts[i] < end); i++ {
+		if len(owners) == 0 || owners[len(owners)-1] != p.owners[i] {
+			owners = append(owners, p.owners[i])
+		}
+	}
+	return owners
+}
+
+// index 返回键所在的区间
+// hold p.mu before accessing this method
+func (p *RangePartitioner) index(key string) int {
+	return sort.Search(len(p.starts), func(i int) bool {
+		return p.starts[i] > key
+	}) - 1
+}
+
+// keyPrefix 将键的前 8 个字节按大端序转为整数，不足 8 个字节时用 0 补足
+func keyPrefix(key string) uint64 {
+	var buf [8]byte
+	copy(buf[:], key)
+	return binary.BigEndian.Uint64(buf[:])
+}
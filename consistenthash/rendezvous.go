@@ -0,0 +1,68 @@
+package consistenthash
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// Rendezvous 使用最高随机权重（HRW）哈希：键属于与它组合后得分最高的节点。
+// 增删节点时只有属于该节点或移到该节点的键发生移动，与节点的加入顺序无关；
+// 每次查找需要计算所有节点的得分，适合节点数不多的集群
+type Rendezvous struct {
+	nodes map[string]uint64 // 节点到节点名的哈希值
+	mu    sync.RWMutex
+}
+
+func NewRendezvous(nodes []string) *Rendezvous {
+	r := &Rendezvous{nodes: make(map[string]uint64)}
+	for _, node := range nodes {
+		r.AddNode(node)
+	}
+	return r
+}
+
+// AddNode 将节点加入集合
+func (r *Rendezvous) AddNode(node string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nodes[node] = hash64(node)
+}
+
+// RemoveNode 从集合中删除节点
+func (r *Rendezvous) RemoveNode(node string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.nodes, node)
+}
+
+// Get 返回得分最高的节点，得分相同时取名称较小的节点
+func (r *Rendezvous) Get(key string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	keyHash := hash64(key)
+	var owner string
+	var best uint64
+	for node, nodeHash := range r.nodes {
+		score := mix64(keyHash ^ nodeHash)
+		if owner == "" || score > best || (score == best && node < owner) {
+			owner, best = node, score
+		}
+	}
+	return owner
+}
+
+func hash64(value string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(value))
+	return h.Sum64()
+}
+
+// mix64 是 splitmix64 的终结函数，使键和节点哈希值的组合在 64 位上均匀分布
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,38 @@
+package consistenthash
+
+// pickN 从键所在的虚拟节点开始顺时针遍历最多 total 个虚拟节点，选出 n 个不同的物理节点，
+// next 依次返回虚拟节点所属的物理节点。
+// 节点设置了可用区时优先选择与已选节点不在同一可用区的节点，不同可用区的节点不足 n 个时，
+// 再按顺时针顺序用跳过的节点补足；没有设置可用区的节点不与任何节点冲突。
+func pickN(n int, total int, zones map[string]string, next func() string) []string {
+	if n <= 0 || total == 0 {
+		return nil
+	}
+	picked := make([]string, 0, n)
+	var skipped []string
+	seen := make(map[string]bool)
+	usedZones := make(map[string]bool)
+	for i := 0; i < total && len(picked) < n; i++ {
+		node := next()
+		if seen[node] {
+			continue
+		}
+		seen[node] = true
+		zone := zones[node]
+		if zone != "" && usedZones[zone] {
+			skipped = append(skipped, node)
+			continue
+		}
+		if zone != "" {
+			usedZones[zone] = true
+		}
+		picked = append(picked, node)
+	}
+	for _, node := range skipped {
+		if len(picked) == n {
+			break
+		}
+		picked = append(picked, node)
+	}
+	return picked
+}
@@ -40,10 +40,12 @@ func OpenMergeFinaFile(dirPath string, fileSize int64, fioType int8) (*DataFile,
 }
 
 // WriteHintRecord writes index information to the hint file.
-func (df *DataFile) WriteHintRecord(key []byte, pst *RecordPst) error {
+// The namespace of the key is kept in the hint record.
+func (df *DataFile) WriteHintRecord(namespace string, key []byte, pst *RecordPst) error {
 	record := &Record{
-		Key:   key,
-		Value: EncodeRecordPst(pst),
+		Key:       key,
+		Value:     EncodeRecordPst(pst),
+		Namespace: namespace,
 	}
 	encRecord, _ := EncodeRecord(record)
 	return df.Write(encRecord)
@@ -110,6 +112,9 @@ func (df *DataFile) ReadRecord(offset int64) (*Record, int64, error) {
 	if crc != header.crc {
 		return nil, 0, fmt.Errorf("invalid record crc")
 	}
+	if err := decodeNamespace(record); err != nil {
+		return nil, 0, err
+	}
 	return record, recordSize, nil
 }
 func (df *DataFile) Write(buf []byte) error {
@@ -2,6 +2,7 @@ package data
 
 import (
 	"encoding/binary"
+	"errors"
 	"hash/crc32"
 )
 
@@ -16,10 +17,18 @@ const (
 	Finished
 )
 
+// namespaceFlag is set in the type byte of the records of a named namespace.
+// Their encoded key starts with the length and the name of the namespace.
+const namespaceFlag RecordType = 0x80
+
+var ErrInvalidNamespace = errors.New("invalid record namespace")
+
 type Record struct {
 	Key   []byte
 	Value []byte
 	Type  RecordType
+	// Namespace is the namespace the record belongs to, empty for the default namespace.
+	Namespace string
 }
 
 type RecordHeader struct {
@@ -63,17 +72,23 @@ func EncodeRecord(record *Record) ([]byte, int64) {
 	// store the record type at fifth byte
 	header[4] = record.Type
 
+	key := record.Key
+	if record.Namespace != "" {
+		header[4] |= namespaceFlag
+		key = encodeNamespaceKey(record.Namespace, record.Key)
+	}
+
 	offset := 5
 
-	offset += binary.PutVarint(header[offset:], int64(len(record.Key)))
+	offset += binary.PutVarint(header[offset:], int64(len(key)))
 	offset += binary.PutVarint(header[offset:], int64(len(record.Value)))
 
-	size := offset + len(record.Key) + len(record.Value)
+	size := offset + len(key) + len(record.Value)
 
 	encBytes := make([]byte, size)
 	copy(encBytes[:offset], header[:offset])
-	copy(encBytes[offset:], record.Key)
-	copy(encBytes[offset+len(record.Key):], record.Value)
+	copy(encBytes[offset:], key)
+	copy(encBytes[offset+len(key):], record.Value)
 
 	crc := crc32.ChecksumIEEE(encBytes[4:])
 
@@ -82,6 +97,30 @@ func EncodeRecord(record *Record) ([]byte, int64) {
 	return encBytes, int64(size)
 }
 
+// encodeNamespaceKey prefixes key with the length and the name of the namespace
+func encodeNamespaceKey(namespace string, key []byte) []byte {
+	buf := make([]byte, binary.MaxVarintLen32+len(namespace)+len(key))
+	n := binary.PutUvarint(buf, uint64(len(namespace)))
+	n += copy(buf[n:], namespace)
+	n += copy(buf[n:], key)
+	return buf[:n]
+}
+
+// decodeNamespace moves the namespace of a record read from a data file out of its key
+func decodeNamespace(record *Record) error {
+	if record.Type&namespaceFlag == 0 {
+		return nil
+	}
+	record.Type &^= namespaceFlag
+	size, n := binary.Uvarint(record.Key)
+	if n <= 0 || uint64(len(record.Key)-n) < size {
+		return ErrInvalidNamespace
+	}
+	record.Namespace = string(record.Key[n : n+int(size)])
+	record.Key = record.Key[n+int(size):]
+	return nil
+}
+
 func decodeRecordHeader(data []byte) (*RecordHeader, int64) {
 	if len(data) <= 4 {
 		return nil, 0
@@ -116,3 +155,19 @@ func calRecordCRC(record *Record, header []byte) uint32 {
 
 	return crc
 }
+
+// ComparePst compares two positions in the data files,
+// returning -1, 0 or 1 when a is before, at or after b.
+func ComparePst(a, b *RecordPst) int {
+	switch {
+	case a.Fid < b.Fid:
+		return -1
+	case a.Fid > b.Fid:
+		return 1
+	case a.Offset < b.Offset:
+		return -1
+	case a.Offset > b.Offset:
+		return 1
+	}
+	return 0
+}
@@ -94,4 +94,30 @@ func TestGetRecordCRC(t *testing.T) {
 	crc3 := calRecordCRC(record3, headerBuf3[crc32.Size:])
 	assert.Equal(t, uint32(3920004365), crc3)
 
-}
\ No newline at end of file
+}
+func TestRecordNamespace(t *testing.T) {
+	dataFile, err := OpenDataFile(t.TempDir(), 0, 1024, 1)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+
+	records := []*Record{
+		{Key: []byte("name"), Value: []byte("flydb"), Type: Normal},
+		{Key: []byte("name"), Value: []byte("users"), Type: Normal, Namespace: "users"},
+		{Key: []byte("name"), Type: Deleted, Namespace: "users"},
+	}
+	for _, record := range records {
+		buf, _ := EncodeRecord(record)
+		assert.Nil(t, dataFile.Write(buf))
+	}
+
+	var offset int64
+	for _, expected := range records {
+		record, size, err := dataFile.ReadRecord(offset)
+		assert.Nil(t, err)
+		assert.Equal(t, expected.Type, record.Type)
+		assert.Equal(t, expected.Namespace, record.Namespace)
+		assert.Equal(t, expected.Key, record.Key)
+		assert.Equal(t, len(expected.Value), len(record.Value))
+		offset += size
+	}
+}
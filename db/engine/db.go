@@ -1,6 +1,7 @@
 package engine
 
 import (
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -15,6 +16,8 @@ import (
 	"github.com/sidneychang/no-db/config"
 	"github.com/sidneychang/no-db/db/data"
 	"github.com/sidneychang/no-db/db/index"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
@@ -26,12 +29,33 @@ type DB struct {
 	index      index.Indexer
 	olderFiles map[uint32]*data.DataFile
 	isMerging  bool
+	// appendNotify is closed and replaced whenever the log grows,
+	// waking up tailers blocked at the end of the active file.
+	appendNotify chan struct{}
+	tailers      map[*Tailer]struct{}
+	// pinned counts the open snapshots and iterators, which read data files after
+	// releasing the lock; Merge and Restore wait on unpinned until it drops to zero,
+	// so the data files stay in place while they are read.
+	pinned   int
+	unpinned *sync.Cond
+	// mergedFileID is the id of the first file not rewritten by the last merge;
+	// the records of the files before it have moved.
+	mergedFileID uint32
+	// defaultNamespace holds the keys written without a namespace, indexed by index.
+	defaultNamespace *Namespace
+	namespaces       map[string]*Namespace
+	stats            stats
+	log              *zap.Logger
 }
 
 const nonTransactionSeqNo = 1
 
 func NewDB(options config.Options) (*DB, error) {
-	zap.L().Info("open db", zap.Any("options", options))
+	log := options.Logger
+	if log == nil {
+		log = zap.NewNop()
+	}
+	log.Info("opening db", zap.String("dir", options.DirPath))
 	if err := checkOptions(options); err != nil {
 		return nil, err
 	}
@@ -39,16 +63,32 @@ func NewDB(options config.Options) (*DB, error) {
 		return nil, err
 	}
 	db := &DB{
-		options:    options,
-		olderFiles: make(map[uint32]*data.DataFile),
-		lock:       new(sync.RWMutex),
-		index:      index.NewIndexer(options.DirPath),
+		options:      options,
+		olderFiles:   make(map[uint32]*data.DataFile),
+		lock:         new(sync.RWMutex),
+		index:        index.NewIndexer(options.DirPath),
+		appendNotify: make(chan struct{}),
+		tailers:      make(map[*Tailer]struct{}),
+		namespaces:   make(map[string]*Namespace),
+		log:          log,
+	}
+	db.defaultNamespace = &Namespace{db: db, options: config.DefaultNamespaceOptions}
+	db.unpinned = sync.NewCond(db.lock)
+
+	//install the files of a finished merge
+	if err := db.loadMergeFiles(); err != nil {
+		return nil, err
 	}
 
 	if err := db.loadDataFiles(); err != nil {
 		return nil, err
 	}
 
+	//the merged files are indexed by the hint file
+	if err := db.loadIndexFromHintFile(); err != nil {
+		return nil, err
+	}
+
 	if err := db.loadIndexFromDataFiles(); err != nil {
 		return nil, err
 	}
@@ -65,18 +105,30 @@ func checkOptions(options config.Options) error {
 	return nil
 }
 
+// Close syncs the active file and closes all the data files.
+// Open tailers are closed as well, so their pending and later calls to Next return ErrTailerClosed.
+// Closing a closed db does nothing.
 func (db *DB) Close() error {
-	zap.L().Info("closing db", zap.Any("options", db.options))
-	if db.activeFile != nil {
-		return nil
-	}
+	db.log.Info("closing db", zap.String("dir", db.options.DirPath))
 	db.lock.Lock()
 	defer db.lock.Unlock()
+	for t := range db.tailers {
+		t.closed = true
+		delete(db.tailers, t)
+	}
+	db.notifyTailers()
+	if db.activeFile == nil {
+		return nil
+	}
 
-	//close active file
+	//sync and close active file
+	if err := db.activeFile.Sync(); err != nil {
+		return err
+	}
 	if err := db.activeFile.Close(); err != nil {
 		return err
 	}
+	db.activeFile = nil
 	// close older files
 	for _, file := range db.olderFiles {
 		if err := file.Close(); err != nil {
@@ -84,50 +136,55 @@ func (db *DB) Close() error {
 		}
 	}
 	return nil
-
 }
 
 // sync the db instance
 func (db *DB) Sync() error {
-	zap.L().Info("syncing db", zap.Any("options", db.options))
+	db.log.Debug("syncing db", zap.String("dir", db.options.DirPath))
+	db.lock.Lock()
+	defer db.lock.Unlock()
 	if db.activeFile == nil {
 		return nil
 	}
-	db.lock.Lock()
-	defer db.lock.Unlock()
 
 	//sync active file
 	return db.activeFile.Sync()
 }
 
 func (db *DB) Put(key []byte, value []byte) error {
-	zap.L().Info("putting key", zap.Any("key", string(key)), zap.Any("value", string(value)))
-	if len(key) == 0 {
-		return errors.New("key cannot be empty")
-	}
-	record := &data.Record{
-		Key:   encodeRecordKeyWithSeq(key, nonTransactionSeqNo),
-		Type:  data.Normal,
-		Value: value,
-	}
+	return db.defaultNamespace.Put(key, value)
+}
 
-	pos, err := db.appendRecordWithLock(record)
+// appendRecordWithLock appends a record, and syncs the active file when sync
+// is set even if the db does not sync every write
+func (db *DB) appendRecordWithLock(ctx context.Context, record *data.Record, sync bool) (*data.RecordPst, error) {
+	ctx, span := tracer.Start(ctx, "engine.append")
+	defer span.End()
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	// the time before this event is spent waiting for the lock
+	span.AddEvent("locked")
+	pst, err := db.appendRecord(ctx, record)
 	if err != nil {
-		return err
+		return nil, spanError(span, err)
 	}
-
-	if ok := db.index.Put(key, pos); !ok {
-		return errors.New("put key failed")
+	if sync && !db.options.SyncWrite {
+		if err := db.syncActiveFile(ctx, "write"); err != nil {
+			return nil, spanError(span, err)
+		}
 	}
-	return nil
+	return pst, nil
 }
-func (db *DB) appendRecordWithLock(record *data.Record) (*data.RecordPst, error) {
-	db.lock.Lock()
-	defer db.lock.Unlock()
-	return db.appendRecord(record)
+
+// syncActiveFile syncs the active file in a span, reason tells why.
+// hold a mutex before accessing this method
+func (db *DB) syncActiveFile(ctx context.Context, reason string) error {
+	_, span := tracer.Start(ctx, "engine.sync", trace.WithAttributes(attribute.String("reason", reason)))
+	defer span.End()
+	return spanError(span, db.activeFile.Sync())
 }
 
-func (db *DB) appendRecord(record *data.Record) (*data.RecordPst, error) {
+func (db *DB) appendRecord(ctx context.Context, record *data.Record) (*data.RecordPst, error) {
 	if db.activeFile == nil {
 		if err := db.setActiveDataFile(); err != nil {
 			return nil, err
@@ -138,7 +195,7 @@ func (db *DB) appendRecord(record *data.Record) (*data.RecordPst, error) {
 	encRecord, size := data.EncodeRecord(record)
 	if db.activeFile.WriteOff+size > db.options.DataFileSize {
 		// persisting data files to ensure that existing data  on disk
-		if err := db.activeFile.Sync(); err != nil {
+		if err := db.syncActiveFile(ctx, "rotate"); err != nil {
 			return nil, err
 		}
 
@@ -148,19 +205,21 @@ func (db *DB) appendRecord(record *data.Record) (*data.RecordPst, error) {
 		if err := db.setActiveDataFile(); err != nil {
 			return nil, err
 		}
-
+		db.stats.fileRotations.Add(1)
 	}
 	writeOff := db.activeFile.WriteOff
 	if err := db.activeFile.Write(encRecord); err != nil {
 		return nil, err
 	}
+	db.stats.bytesWritten.Add(uint64(size))
 
 	//determin whether to init based on user configuration
 	if db.options.SyncWrite {
-		if err := db.activeFile.Sync(); err != nil {
+		if err := db.syncActiveFile(ctx, "write"); err != nil {
 			return nil, err
 		}
 	}
+	db.notifyTailers()
 
 	//build in-memory index info
 	pst := &data.RecordPst{
@@ -183,25 +242,12 @@ func (db *DB) setActiveDataFile() error {
 		return err
 	}
 	db.activeFile = dataFile
+	db.notifyTailers()
 	return nil
 
 }
 func (db *DB) Get(key []byte) ([]byte, error) {
-	zap.L().Info("getting key", zap.Any("key", key))
-	db.lock.RLock()
-	defer db.lock.RUnlock()
-
-	if len(key) == 0 {
-		fmt.Println("key is empyty")
-		return nil, errors.New("key is empty")
-	}
-
-	recordPst := db.index.Get(key)
-	if recordPst == nil {
-		fmt.Println("key not found")
-		return nil, errors.New("key not found")
-	}
-	return db.getValueByPosition(recordPst)
+	return db.defaultNamespace.Get(key)
 }
 func (db *DB) GetListKeys() [][]byte {
 	iterator := db.index.Iterator(false)
@@ -218,19 +264,7 @@ func (db *DB) GetListKeys() [][]byte {
 }
 
 func (db *DB) ListAllData() ([][]byte, [][]byte) {
-	iterator := db.index.Iterator(false)
-
-	keys := make([][]byte, db.index.Size())
-	values := make([][]byte, db.index.Size())
-
-	var idx int
-	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
-		keys[idx] = iterator.Key()
-		values[idx], _ = db.getValueByPosition(iterator.Value())
-		idx++
-	}
-	return keys, values
-
+	return db.defaultNamespace.ListAllData()
 }
 
 // Fold get all the data and perform the operation specified by the user.
@@ -255,12 +289,7 @@ func (db *DB) Fold(f func(key []byte, value []byte) bool) error {
 
 // getValueByPosition Get the corresponding value based on the location index information
 func (db *DB) getValueByPosition(recordPst *data.RecordPst) ([]byte, error) {
-	var dataFile *data.DataFile
-	if recordPst.Fid == db.activeFile.FileID {
-		dataFile = db.activeFile
-	} else {
-		dataFile = db.olderFiles[recordPst.Fid]
-	}
+	dataFile := db.dataFile(recordPst.Fid)
 	if dataFile == nil {
 		return nil, errors.New("data file not found")
 	}
@@ -276,26 +305,7 @@ func (db *DB) getValueByPosition(recordPst *data.RecordPst) ([]byte, error) {
 }
 
 func (db *DB) Delete(key []byte) error {
-	zap.L().Info("deleting key", zap.Any("key", key))
-	if len(key) == 0 {
-		return errors.New("key is empty")
-	}
-	if pst := db.index.Get(key); pst == nil {
-		return nil
-	}
-	record := &data.Record{
-		Key:  encodeRecordKeyWithSeq(key, nonTransactionSeqNo),
-		Type: data.Deleted,
-	}
-	_, err := db.appendRecordWithLock(record)
-	if err != nil {
-		return err
-	}
-	ok := db.index.Delete(key)
-	if !ok {
-		return errors.New("delete key failed")
-	}
-	return nil
+	return db.defaultNamespace.Delete(key)
 }
 
 func (db *DB) loadDataFiles() error {
@@ -352,12 +362,14 @@ func (db *DB) loadIndexFromDataFiles() error {
 		nonMergeFileId = fileId
 		hasMerge = true
 	}
-	updateIndex := func(key []byte, typ data.RecordType, pst *data.RecordPst) {
+	db.mergedFileID = nonMergeFileId
+	updateIndex := func(namespace string, key []byte, typ data.RecordType, pst *data.RecordPst) {
+		idx := db.namespaceLocked(namespace, nil).indexer()
 		var ok bool
 		if typ == data.Deleted {
-			ok = db.index.Delete(key)
+			ok = idx.Delete(key)
 		} else {
-			ok = db.index.Put(key, pst)
+			ok = idx.Put(key, pst)
 		}
 		if !ok {
 			panic("update index failed")
@@ -392,7 +404,7 @@ func (db *DB) loadIndexFromDataFiles() error {
 			}
 			realKey, _ := parseRecordKeyAndSeq(record.Key)
 
-			updateIndex(realKey, record.Type, recordPst)
+			updateIndex(record.Namespace, realKey, record.Type, recordPst)
 
 			offset += size
 		}
@@ -0,0 +1,61 @@
+package engine
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/sidneychang/no-db/config"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestDB_Close(t *testing.T) {
+	options := config.NewOptions(1, 1024, t.TempDir())
+	// small data files so that older files are closed too
+	options.DataFileSize = 256
+	db, err := NewDB(*options)
+	assert.Nil(t, err)
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("value-%d", i))))
+	}
+	assert.Greater(t, len(db.olderFiles), 0)
+	assert.Nil(t, db.Close())
+	assert.Nil(t, db.activeFile)
+	// closing twice is harmless
+	assert.Nil(t, db.Close())
+
+	reopened, err := NewDB(*options)
+	assert.Nil(t, err)
+	defer reopened.Close()
+	for i := 0; i < 20; i++ {
+		value, err := reopened.Get([]byte(fmt.Sprintf("key-%d", i)))
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("value-%d", i), string(value))
+	}
+}
+
+func TestDB_Logger(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	options := config.NewOptions(1, 1024, t.TempDir())
+	options.Logger = zap.New(core)
+	db, err := NewDB(*options)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	assert.Nil(t, db.Put([]byte("user"), []byte("secret")))
+	_, err = db.Get([]byte("user"))
+	assert.Nil(t, err)
+	assert.Nil(t, db.Delete([]byte("user")))
+
+	// requests are logged at debug level with their key, never their value
+	requests := logs.FilterField(zap.ByteString("key", []byte("user")))
+	assert.Equal(t, 3, requests.Len())
+	for _, entry := range logs.All() {
+		assert.NotContains(t, fmt.Sprint(entry.ContextMap()), "secret", entry.Message)
+	}
+	for _, entry := range requests.All() {
+		assert.Equal(t, zapcore.DebugLevel, entry.Level)
+	}
+}
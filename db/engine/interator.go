@@ -7,18 +7,30 @@ import (
 	"github.com/sidneychang/no-db/db/index"
 )
 
+// Iterator walks a snapshot of an index taken when it was created.
+// It pins the data files until it is closed, so the values it reads are those
+// the snapshot points to; Merge and Restore wait for open iterators to be closed.
 type Iterator struct {
 	indexIter index.Iterator
 	db        *DB
 	options   config.IteratorOptions
+	pinned    bool
 }
 
 func (db *DB) NewIterator(options config.IteratorOptions) *Iterator {
-	indexIter := db.index.Iterator(options.Reverse)
+	return db.newIterator(db.defaultNamespace, options)
+}
+
+// newIterator pins the data files and takes a snapshot of the index of ns
+func (db *DB) newIterator(ns *Namespace, options config.IteratorOptions) *Iterator {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.pinFiles()
 	return &Iterator{
-		indexIter: indexIter,
+		indexIter: ns.indexer().Iterator(options.Reverse),
 		db:        db,
 		options:   options,
+		pinned:    true,
 	}
 }
 func (it *Iterator) Rewind() {
@@ -48,6 +60,12 @@ func (it *Iterator) Value() ([]byte, error) {
 }
 func (it *Iterator) Close() {
 	it.indexIter.Close()
+	if it.pinned {
+		it.pinned = false
+		it.db.lock.Lock()
+		it.db.unpinFiles()
+		it.db.lock.Unlock()
+	}
 }
 func (it *Iterator) skipToNext() {
 	prefixLen := len(it.options.Prefix)
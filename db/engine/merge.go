@@ -1,6 +1,7 @@
 package engine
 
 import (
+	"context"
 	"errors"
 	"io"
 	"os"
@@ -8,6 +9,7 @@ import (
 	"path/filepath"
 	"sort"
 	"strconv"
+	"time"
 
 	"github.com/sidneychang/no-db/db/data"
 )
@@ -22,16 +24,29 @@ func (db *DB) Merge() error {
 		return nil
 	}
 	db.lock.Lock()
+	//the files of open snapshots and iterators are still being read
+	db.waitForUnpinned()
 	if db.isMerging {
 		db.lock.Unlock()
 		return errors.New("db is merging")
 	}
 	db.isMerging = true
+	start := time.Now()
 	defer func() {
 		db.isMerging = false
+		db.stats.merges.Add(1)
+		db.stats.mergeNanos.Add(int64(time.Since(start)))
 	}()
 
 	if err := db.activeFile.Sync(); err != nil {
+		db.lock.Unlock()
+		return err
+	}
+
+	//tailers must not lose the records that are about to be compacted,
+	//checked before rotating so that a rejected merge leaves the files as they are
+	if err := db.checkTailersBeforeMerge(); err != nil {
+		db.lock.Unlock()
 		return err
 	}
 
@@ -50,7 +65,14 @@ func (db *DB) Merge() error {
 	for _, file := range db.olderFiles {
 		mergeFiles = append(mergeFiles, file)
 	}
+	//a restore must not replace the files while they are merged
+	db.pinFiles()
 	db.lock.Unlock()
+	defer func() {
+		db.lock.Lock()
+		db.unpinFiles()
+		db.lock.Unlock()
+	}()
 
 	//sort marge files from smallest toe largest
 	sort.Slice(mergeFiles, func(i, j int) bool {
@@ -78,12 +100,14 @@ func (db *DB) Merge() error {
 	if err != nil {
 		return err
 	}
+	defer mergeDB.Close()
 
 	//open the hint file storage index
 	hintFile, err := data.OpenHintFile(mergePath, db.options.DataFileSize, 1)
 	if err != nil {
 		return err
 	}
+	defer hintFile.Close()
 	//walk through each data file
 	for _, files := range mergeFiles {
 		//open the data file
@@ -97,18 +121,18 @@ func (db *DB) Merge() error {
 				}
 				return err
 			}
+			//parse the key
 			realKey, _ := parseRecordKeyAndSeq(record.Key)
-			recordPst, err := mergeDB.appendRecord(record)
-			if recordPst != nil && recordPst.Fid == files.FileID && recordPst.Offset == offset {
-				//parse the key
+			//only rewrite the records the index of their namespace still points to
+			if db.isLiveRecord(record.Namespace, realKey, files.FileID, offset) {
 				record.Key = encodeRecordKeyWithSeq(realKey, nonTransactionSeqNo)
-				recordPst, err := mergeDB.appendRecord(record)
+				recordPst, err := mergeDB.appendRecord(context.Background(), record)
 				if err != nil {
 					return err
 				}
 
 				// Writes the current location index to the hint file
-				if err := hintFile.WriteHintRecord(realKey, recordPst); err != nil {
+				if err := hintFile.WriteHintRecord(record.Namespace, realKey, recordPst); err != nil {
 					return err
 				}
 			}
@@ -149,6 +173,22 @@ func (db *DB) Merge() error {
 
 	return nil
 }
+
+// isLiveRecord reports whether the index of the namespace still points to the record at offset in the file fid.
+func (db *DB) isLiveRecord(namespace string, key []byte, fid uint32, offset int64) bool {
+	db.lock.RLock()
+	ns := db.defaultNamespace
+	if namespace != "" {
+		ns = db.namespaces[namespace]
+	}
+	db.lock.RUnlock()
+	if ns == nil {
+		return false
+	}
+	pst := ns.indexer().Get(key)
+	return pst != nil && pst.Fid == fid && pst.Offset == offset
+}
+
 func (db *DB) getMergePath() string {
 	// Gets the database parent directory
 	parentDir := path.Dir(path.Clean(db.options.DirPath))
@@ -268,6 +308,7 @@ func (db *DB) loadIndexFromHintFile() error {
 	if err != nil {
 		return err
 	}
+	defer hintFile.Close()
 
 	// Read the index in the file
 	var offset int64 = 0
@@ -282,7 +323,7 @@ func (db *DB) loadIndexFromHintFile() error {
 
 		// Decode to get the actual index location
 		pst := data.DecodeRecordPst(logRecord.Value)
-		db.index.Put(logRecord.Key, pst)
+		db.namespaceLocked(logRecord.Namespace, nil).indexer().Put(logRecord.Key, pst)
 		offset += size
 	}
 	return nil
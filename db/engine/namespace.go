@@ -0,0 +1,221 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/sidneychang/no-db/config"
+	"github.com/sidneychang/no-db/db/data"
+	"github.com/sidneychang/no-db/db/index"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// ErrKeyNotFound is returned by Get when the key does not exist in the namespace.
+var ErrKeyNotFound = errors.New("key not found")
+
+// Namespace is a named key space within a DB, also known as a column family.
+// Each namespace has its own index and options, while all the namespaces
+// share the data files, so a single log orders the writes to all of them.
+type Namespace struct {
+	db      *DB
+	name    string
+	options config.NamespaceOptions
+	// index is nil for the default namespace, which uses the index of the db
+	index index.Indexer
+}
+
+// Namespace returns the namespace with the given name, creating it with the
+// default options if needed. The empty name is the default namespace of the db.
+func (db *DB) Namespace(name string) *Namespace {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	return db.namespaceLocked(name, nil)
+}
+
+// OpenNamespace returns the namespace with the given name and sets its options.
+// Options are not persisted and have to be set again after the db is reopened.
+func (db *DB) OpenNamespace(name string, options config.NamespaceOptions) *Namespace {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	return db.namespaceLocked(name, &options)
+}
+
+// Namespaces returns the names of the namespaces in order, starting with the default namespace.
+func (db *DB) Namespaces() []string {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	names := []string{""}
+	for name := range db.namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+// namespaceLocked returns or creates a namespace, replacing its options if given.
+// hold a mutex before accessing this method
+func (db *DB) namespaceLocked(name string, options *config.NamespaceOptions) *Namespace {
+	ns := db.defaultNamespace
+	if name != "" {
+		var ok bool
+		if ns, ok = db.namespaces[name]; !ok {
+			ns = &Namespace{
+				db:      db,
+				name:    name,
+				options: config.DefaultNamespaceOptions,
+				index:   index.NewIndexer(db.options.DirPath),
+			}
+			db.namespaces[name] = ns
+		}
+	}
+	if options != nil {
+		ns.options = *options
+	}
+	return ns
+}
+
+// resetNamespaceIndexes replaces the indexes of all the namespaces with empty ones.
+// hold a mutex before accessing this method
+func (db *DB) resetNamespaceIndexes() {
+	db.index = index.NewIndexer(db.options.DirPath)
+	for _, ns := range db.namespaces {
+		ns.index = index.NewIndexer(db.options.DirPath)
+	}
+}
+
+// Name returns the name of the namespace, empty for the default namespace.
+func (ns *Namespace) Name() string {
+	return ns.name
+}
+
+func (ns *Namespace) indexer() index.Indexer {
+	if ns.index == nil {
+		return ns.db.index
+	}
+	return ns.index
+}
+
+func (ns *Namespace) Put(key []byte, value []byte) error {
+	return ns.PutContext(context.Background(), key, value)
+}
+
+// PutContext is Put recording its spans under the span in ctx.
+func (ns *Namespace) PutContext(ctx context.Context, key []byte, value []byte) error {
+	ctx, span := tracer.Start(ctx, "engine.Put", trace.WithAttributes(attribute.String("namespace", ns.name)))
+	defer span.End()
+	if ce := ns.db.log.Check(zap.DebugLevel, "putting key"); ce != nil {
+		ce.Write(zap.String("namespace", ns.name), zap.ByteString("key", key), zap.Int("size", len(value)))
+	}
+	if len(key) == 0 {
+		return errors.New("key cannot be empty")
+	}
+	ns.db.stats.puts.Add(1)
+	record := &data.Record{
+		Key:       encodeRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Type:      data.Normal,
+		Value:     value,
+		Namespace: ns.name,
+	}
+
+	pos, err := ns.db.appendRecordWithLock(ctx, record, ns.options.SyncWrite)
+	if err != nil {
+		return spanError(span, err)
+	}
+
+	_, indexSpan := tracer.Start(ctx, "engine.index")
+	ok := ns.indexer().Put(key, pos)
+	indexSpan.End()
+	if !ok {
+		return spanError(span, errors.New("put key failed"))
+	}
+	return nil
+}
+
+func (ns *Namespace) Get(key []byte) ([]byte, error) {
+	return ns.GetContext(context.Background(), key)
+}
+
+// GetContext is Get recording its span under the span in ctx.
+func (ns *Namespace) GetContext(ctx context.Context, key []byte) ([]byte, error) {
+	_, span := tracer.Start(ctx, "engine.Get", trace.WithAttributes(attribute.String("namespace", ns.name)))
+	defer span.End()
+	db := ns.db
+	if ce := db.log.Check(zap.DebugLevel, "getting key"); ce != nil {
+		ce.Write(zap.String("namespace", ns.name), zap.ByteString("key", key))
+	}
+	db.stats.gets.Add(1)
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	if len(key) == 0 {
+		return nil, errors.New("key is empty")
+	}
+
+	recordPst := ns.indexer().Get(key)
+	if recordPst == nil {
+		return nil, ErrKeyNotFound
+	}
+	value, err := db.getValueByPosition(recordPst)
+	return value, spanError(span, err)
+}
+
+func (ns *Namespace) Delete(key []byte) error {
+	return ns.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext is Delete recording its spans under the span in ctx.
+func (ns *Namespace) DeleteContext(ctx context.Context, key []byte) error {
+	ctx, span := tracer.Start(ctx, "engine.Delete", trace.WithAttributes(attribute.String("namespace", ns.name)))
+	defer span.End()
+	if ce := ns.db.log.Check(zap.DebugLevel, "deleting key"); ce != nil {
+		ce.Write(zap.String("namespace", ns.name), zap.ByteString("key", key))
+	}
+	if len(key) == 0 {
+		return errors.New("key is empty")
+	}
+	ns.db.stats.deletes.Add(1)
+	if pst := ns.indexer().Get(key); pst == nil {
+		return nil
+	}
+	record := &data.Record{
+		Key:       encodeRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Type:      data.Deleted,
+		Namespace: ns.name,
+	}
+	_, err := ns.db.appendRecordWithLock(ctx, record, ns.options.SyncWrite)
+	if err != nil {
+		return spanError(span, err)
+	}
+	_, indexSpan := tracer.Start(ctx, "engine.index")
+	ok := ns.indexer().Delete(key)
+	indexSpan.End()
+	if !ok {
+		return spanError(span, errors.New("delete key failed"))
+	}
+	return nil
+}
+
+func (ns *Namespace) ListAllData() ([][]byte, [][]byte) {
+	idx := ns.indexer()
+	iterator := idx.Iterator(false)
+
+	keys := make([][]byte, idx.Size())
+	values := make([][]byte, idx.Size())
+
+	var i int
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		keys[i] = iterator.Key()
+		values[i], _ = ns.db.getValueByPosition(iterator.Value())
+		i++
+	}
+	return keys, values
+}
+
+// NewIterator returns an iterator over the keys of the namespace,
+// which must be closed to let Merge and Restore proceed.
+func (ns *Namespace) NewIterator(options config.IteratorOptions) *Iterator {
+	return ns.db.newIterator(ns, options)
+}
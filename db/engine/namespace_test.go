@@ -0,0 +1,133 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sidneychang/no-db/config"
+	"github.com/sidneychang/no-db/db/data"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespace_Isolation(t *testing.T) {
+	db := newTailTestDB(t)
+	users := db.Namespace("users")
+	orders := db.OpenNamespace("orders", config.NamespaceOptions{SyncWrite: true})
+
+	assert.Nil(t, db.Put([]byte("id"), []byte("default")))
+	assert.Nil(t, users.Put([]byte("id"), []byte("user")))
+	assert.Nil(t, orders.Put([]byte("id"), []byte("order")))
+	assert.Nil(t, users.Put([]byte("name"), []byte("alice")))
+	assert.Nil(t, orders.Delete([]byte("id")))
+
+	value, err := db.Get([]byte("id"))
+	assert.Nil(t, err)
+	assert.Equal(t, "default", string(value))
+	value, err = db.Namespace("users").Get([]byte("id"))
+	assert.Nil(t, err)
+	assert.Equal(t, "user", string(value))
+	_, err = orders.Get([]byte("id"))
+	assert.NotNil(t, err)
+	assert.Equal(t, db.defaultNamespace, db.Namespace(""))
+	assert.Equal(t, []string{"", "orders", "users"}, db.Namespaces())
+
+	keys, _ := users.ListAllData()
+	assert.Equal(t, [][]byte{[]byte("id"), []byte("name")}, keys)
+	keys, _ = db.ListAllData()
+	assert.Equal(t, [][]byte{[]byte("id")}, keys)
+
+	// the namespaces share the log, and their records carry the namespace
+	tailer := db.Tail(nil)
+	defer tailer.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var namespaces []string
+	for i := 0; i < 5; i++ {
+		record, _, err := tailer.Next(ctx)
+		assert.Nil(t, err)
+		assert.False(t, bytes.HasPrefix(record.Key, []byte("users")))
+		namespaces = append(namespaces, record.Namespace)
+	}
+	assert.Equal(t, []string{"", "users", "orders", "users", "orders"}, namespaces)
+}
+
+func TestNamespace_Reopen(t *testing.T) {
+	db := newTailTestDB(t)
+	for _, name := range []string{"", "a", "b"} {
+		assert.Nil(t, db.Namespace(name).Put([]byte("key"), []byte("value-"+name)))
+	}
+	assert.Nil(t, db.Namespace("a").Delete([]byte("key")))
+	assert.Nil(t, db.Sync())
+
+	reopened, err := NewDB(db.options)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"", "a", "b"}, reopened.Namespaces())
+	value, err := reopened.Get([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, "value-", string(value))
+	_, err = reopened.Namespace("a").Get([]byte("key"))
+	assert.NotNil(t, err)
+	value, err = reopened.Namespace("b").Get([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, "value-b", string(value))
+
+	// restoring a snapshot rebuilds the index of every namespace
+	files, err := db.Snapshot()
+	assert.Nil(t, err)
+	var buf bytes.Buffer
+	assert.Nil(t, db.WriteSnapshot(&buf, files))
+	db.ReleaseSnapshot()
+	target := newTailTestDB(t)
+	assert.Nil(t, target.Namespace("b").Put([]byte("stale"), []byte("value")))
+	assert.Nil(t, target.Restore(&buf))
+	value, err = target.Namespace("b").Get([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, "value-b", string(value))
+	_, err = target.Namespace("b").Get([]byte("stale"))
+	assert.NotNil(t, err)
+}
+
+func TestNamespace_Merge(t *testing.T) {
+	db := newTailTestDB(t)
+	users := db.Namespace("users")
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, users.Put([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("old-%d", i))))
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("default-%d", i))))
+	}
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, users.Put([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("new-%d", i))))
+	}
+	assert.Nil(t, users.Delete([]byte("key-0")))
+	assert.Nil(t, db.Merge())
+	// written after the merge, kept in the files that were not merged
+	assert.Nil(t, users.Put([]byte("key-1"), []byte("latest")))
+	assert.Nil(t, db.Close())
+
+	// the merged files are installed on reopen and indexed by the hint file
+	reopened, err := NewDB(db.options)
+	assert.Nil(t, err)
+	defer reopened.Close()
+	assert.NotZero(t, reopened.mergedFileID)
+	_, err = reopened.Namespace("users").Get([]byte("key-0"))
+	assert.NotNil(t, err)
+	value, err := reopened.Namespace("users").Get([]byte("key-1"))
+	assert.Nil(t, err)
+	assert.Equal(t, "latest", string(value))
+	for i := 2; i < 20; i++ {
+		value, err := reopened.Namespace("users").Get([]byte(fmt.Sprintf("key-%d", i)))
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("new-%d", i), string(value))
+		value, err = reopened.Get([]byte(fmt.Sprintf("key-%d", i)))
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("default-%d", i), string(value))
+	}
+
+	// positions inside the merged files no longer match their records
+	tailer := reopened.Tail(&data.RecordPst{Fid: 0, Offset: 10})
+	defer tailer.Close()
+	_, _, err = tailer.Next(context.Background())
+	assert.Equal(t, ErrPositionCompacted, err)
+}
@@ -0,0 +1,200 @@
+package engine
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/sidneychang/no-db/db/data"
+)
+
+// snapshotChunkSize is the size of the buffer used to copy data files
+const snapshotChunkSize = 64 * 1024
+
+// SnapshotFile is the stable prefix of a data file captured by Snapshot.
+// Data files are append only, so the prefix does not change after capture.
+type SnapshotFile struct {
+	Fid  uint32
+	Size int64
+}
+
+// Snapshot syncs the data files and returns their current sizes.
+// Writing the captured files with WriteSnapshot reproduces the current state of the db.
+// The files are pinned until ReleaseSnapshot is called: Merge and Restore wait
+// for every snapshot to be released, so they cannot remove or replace the files being copied.
+func (db *DB) Snapshot() ([]SnapshotFile, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if db.activeFile == nil {
+		db.pinFiles()
+		return nil, nil
+	}
+	if err := db.activeFile.Sync(); err != nil {
+		return nil, err
+	}
+	var files []SnapshotFile
+	for fid, dataFile := range db.olderFiles {
+		size, err := dataFile.IoManager.Size()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, SnapshotFile{Fid: fid, Size: size})
+	}
+	files = append(files, SnapshotFile{Fid: db.activeFile.FileID, Size: db.activeFile.WriteOff})
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Fid < files[j].Fid
+	})
+	db.pinFiles()
+	return files, nil
+}
+
+// ReleaseSnapshot unpins the files captured by Snapshot once they have been written.
+func (db *DB) ReleaseSnapshot() {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.unpinFiles()
+}
+
+// pinFiles keeps the data files in place until the matching unpinFiles.
+// hold db.lock before accessing this method
+func (db *DB) pinFiles() {
+	db.pinned++
+}
+
+// unpinFiles releases a pin taken by pinFiles.
+// hold db.lock before accessing this method
+func (db *DB) unpinFiles() {
+	if db.pinned > 0 {
+		db.pinned--
+	}
+	if db.pinned == 0 {
+		db.unpinned.Broadcast()
+	}
+}
+
+// waitForUnpinned blocks until no snapshot or iterator pins the data files.
+// hold db.lock before accessing this method
+func (db *DB) waitForUnpinned() {
+	for db.pinned > 0 {
+		db.unpinned.Wait()
+	}
+}
+
+// WriteSnapshot writes the captured data files to w.
+// Each file is encoded as its id, its size and its content.
+// The snapshot must not have been released yet.
+func (db *DB) WriteSnapshot(w io.Writer, files []SnapshotFile) error {
+	header := make([]byte, 4+8)
+	buf := make([]byte, snapshotChunkSize)
+	for _, file := range files {
+		db.lock.RLock()
+		dataFile := db.dataFile(file.Fid)
+		db.lock.RUnlock()
+		if dataFile == nil {
+			return ErrPositionCompacted
+		}
+
+		binary.LittleEndian.PutUint32(header[:4], file.Fid)
+		binary.LittleEndian.PutUint64(header[4:], uint64(file.Size))
+		if _, err := w.Write(header); err != nil {
+			return err
+		}
+		for offset := int64(0); offset < file.Size; {
+			n := int64(len(buf))
+			if file.Size-offset < n {
+				n = file.Size - offset
+			}
+			if _, err := dataFile.IoManager.Read(buf[:n], offset); err != nil {
+				return err
+			}
+			if _, err := w.Write(buf[:n]); err != nil {
+				return err
+			}
+			offset += n
+		}
+	}
+	return nil
+}
+
+// Restore replaces all the data of the db with a snapshot written by WriteSnapshot,
+// then rebuilds the index from the restored data files.
+func (db *DB) Restore(r io.Reader) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.waitForUnpinned()
+
+	// close and remove the current data files
+	if db.activeFile != nil {
+		if err := db.activeFile.Close(); err != nil {
+			return err
+		}
+		db.olderFiles[db.activeFile.FileID] = db.activeFile
+	}
+	for fid, dataFile := range db.olderFiles {
+		if dataFile != db.activeFile {
+			if err := dataFile.Close(); err != nil {
+				return err
+			}
+		}
+		if err := os.Remove(data.GetDataFileName(db.options.DirPath, fid)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	// the restored files are indexed from their records, not by the hints of an earlier merge
+	for _, name := range []string{data.HintFileSuffix, data.MergeFinaFileSuffix} {
+		if err := os.Remove(filepath.Join(db.options.DirPath, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	db.activeFile = nil
+	db.olderFiles = make(map[uint32]*data.DataFile)
+	db.fileIds = nil
+	db.mergedFileID = 0
+
+	// write the data files of the snapshot
+	header := make([]byte, 4+8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		fid := binary.LittleEndian.Uint32(header[:4])
+		size := int64(binary.LittleEndian.Uint64(header[4:]))
+		if err := restoreDataFile(data.GetDataFileName(db.options.DirPath, fid), r, size); err != nil {
+			return err
+		}
+	}
+
+	// reload the data files and the index
+	db.resetNamespaceIndexes()
+	if err := db.loadDataFiles(); err != nil {
+		return err
+	}
+	if err := db.loadIndexFromDataFiles(); err != nil {
+		return err
+	}
+	db.notifyTailers()
+	return nil
+}
+
+func restoreDataFile(fileName string, r io.Reader, size int64) error {
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	n, err := io.CopyN(file, r, size)
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return errors.New("snapshot data file is truncated")
+	}
+	return file.Sync()
+}
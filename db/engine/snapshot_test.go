@@ -0,0 +1,103 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sidneychang/no-db/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot_DuringMerge(t *testing.T) {
+	db := newTailTestDB(t)
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("value-%d", i))))
+	}
+	files, err := db.Snapshot()
+	assert.Nil(t, err)
+
+	// the merge waits until the snapshot has been written
+	merged := make(chan error, 1)
+	go func() { merged <- db.Merge() }()
+	restored := make(chan error, 1)
+	target := newTailTestDB(t)
+	go func() {
+		var buf bytes.Buffer
+		if err := db.WriteSnapshot(&buf, files); err != nil {
+			restored <- err
+			return
+		}
+		// restoring the db itself also waits for the snapshot
+		restored <- target.Restore(&buf)
+	}()
+	assert.Nil(t, <-restored)
+	select {
+	case err := <-merged:
+		t.Fatalf("merge finished while a snapshot was open: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	db.ReleaseSnapshot()
+	assert.Nil(t, <-merged)
+
+	for i := 0; i < 50; i++ {
+		value, err := target.Get([]byte(fmt.Sprintf("key-%d", i)))
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("value-%d", i), string(value))
+	}
+}
+
+func TestSnapshot_RestoreWaitsForRelease(t *testing.T) {
+	db := newTailTestDB(t)
+	assert.Nil(t, db.Put([]byte("key"), []byte("value")))
+	files, err := db.Snapshot()
+	assert.Nil(t, err)
+	var buf bytes.Buffer
+	assert.Nil(t, db.WriteSnapshot(&buf, files))
+
+	restored := make(chan error, 1)
+	go func() { restored <- db.Restore(bytes.NewReader(buf.Bytes())) }()
+	select {
+	case err := <-restored:
+		t.Fatalf("restore replaced the files of an open snapshot: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	db.ReleaseSnapshot()
+	assert.Nil(t, <-restored)
+	value, err := db.Get([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, "value", string(value))
+}
+
+func TestIterator_PinsFiles(t *testing.T) {
+	db := newTailTestDB(t)
+	assert.Nil(t, db.Namespace("users").Put([]byte("key"), []byte("old")))
+	files, err := db.Snapshot()
+	assert.Nil(t, err)
+	var buf bytes.Buffer
+	assert.Nil(t, db.WriteSnapshot(&buf, files))
+	db.ReleaseSnapshot()
+	assert.Nil(t, db.Namespace("users").Put([]byte("key"), []byte("new")))
+
+	// a restore waits until the values seen by an open iterator have been read
+	it := db.Namespace("users").NewIterator(config.DefaultIteratorOptions)
+	restored := make(chan error, 1)
+	go func() { restored <- db.Restore(&buf) }()
+	time.Sleep(20 * time.Millisecond)
+	it.Rewind()
+	assert.True(t, it.Valid())
+	value, err := it.Value()
+	assert.Nil(t, err)
+	assert.Equal(t, "new", string(value))
+	select {
+	case err := <-restored:
+		t.Fatalf("restore replaced the files of an open iterator: %v", err)
+	default:
+	}
+	it.Close()
+	assert.Nil(t, <-restored)
+	value, err = db.Namespace("users").Get([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, "old", string(value))
+}
@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/sidneychang/no-db/db/data"
+)
+
+// Stats holds counters of the operations on a db since it was opened,
+// along with the current size of its indexes and log.
+type Stats struct {
+	Puts          uint64
+	Gets          uint64
+	Deletes       uint64
+	BytesWritten  uint64 // encoded size of the records appended to the log
+	FileRotations uint64 // number of times the active file was full and a new one was opened
+	Merges        uint64
+	MergeDuration time.Duration // total time spent in Merge
+	Keys          int           // number of keys in the indexes of all the namespaces
+	DataFiles     int           // number of data files, including the active one
+}
+
+// stats are the counters behind Stats, updated without holding the db lock
+type stats struct {
+	puts          atomic.Uint64
+	gets          atomic.Uint64
+	deletes       atomic.Uint64
+	bytesWritten  atomic.Uint64
+	fileRotations atomic.Uint64
+	merges        atomic.Uint64
+	mergeNanos    atomic.Int64
+}
+
+// Stats returns the counters of the db.
+func (db *DB) Stats() Stats {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	st := Stats{
+		Puts:          db.stats.puts.Load(),
+		Gets:          db.stats.gets.Load(),
+		Deletes:       db.stats.deletes.Load(),
+		BytesWritten:  db.stats.bytesWritten.Load(),
+		FileRotations: db.stats.fileRotations.Load(),
+		Merges:        db.stats.merges.Load(),
+		MergeDuration: time.Duration(db.stats.mergeNanos.Load()),
+		Keys:          db.index.Size(),
+		DataFiles:     len(db.olderFiles),
+	}
+	for _, ns := range db.namespaces {
+		st.Keys += ns.index.Size()
+	}
+	if db.activeFile != nil {
+		st.DataFiles++
+	}
+	return st
+}
+
+// LogLag returns the number of bytes appended to the log after the position
+// from, i.e. how far behind a replica that has applied the log up to from is.
+// Files removed by a merge are not counted.
+func (db *DB) LogLag(from *data.RecordPst) int64 {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	if db.activeFile == nil {
+		return 0
+	}
+	if from == nil {
+		from = &data.RecordPst{}
+	}
+	var lag int64
+	for fid, file := range db.olderFiles {
+		// older files are not written to, and WriteOff is only set on the
+		// active file when the db is opened
+		if size, err := file.IoManager.Size(); err == nil {
+			lag += bytesAfter(fid, size, from)
+		}
+	}
+	return lag + bytesAfter(db.activeFile.FileID, db.activeFile.WriteOff, from)
+}
+
+// bytesAfter returns the number of bytes of the file fid of the given size
+// that are after the position from
+func bytesAfter(fid uint32, size int64, from *data.RecordPst) int64 {
+	switch {
+	case fid > from.Fid:
+		return size
+	case fid == from.Fid:
+		return max(size-from.Offset, 0)
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,44 @@
+package engine
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/sidneychang/no-db/config"
+	"github.com/sidneychang/no-db/db/data"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Stats(t *testing.T) {
+	options := config.NewOptions(1, 1024, t.TempDir())
+	options.DataFileSize = 256
+	db, err := NewDB(*options)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("key-%d", i)), []byte("value")))
+	}
+	assert.Nil(t, db.Namespace("users").Put([]byte("alice"), []byte("1")))
+	_, err = db.Get([]byte("key-0"))
+	assert.Nil(t, err)
+	_, err = db.Get([]byte("missing"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Nil(t, db.Delete([]byte("key-1")))
+
+	st := db.Stats()
+	assert.Equal(t, uint64(21), st.Puts)
+	assert.Equal(t, uint64(2), st.Gets)
+	assert.Equal(t, uint64(1), st.Deletes)
+	assert.Equal(t, 20, st.Keys)
+	assert.Greater(t, st.FileRotations, uint64(0))
+	assert.Equal(t, int(st.FileRotations)+1, st.DataFiles)
+
+	// every byte written is behind a replica that has applied nothing
+	end := db.LogPosition()
+	assert.Equal(t, int64(st.BytesWritten), db.LogLag(nil))
+	assert.Equal(t, int64(0), db.LogLag(end))
+	assert.Nil(t, db.Put([]byte("key-0"), []byte("value")))
+	assert.Equal(t, int64(db.Stats().BytesWritten-st.BytesWritten), db.LogLag(end))
+	assert.Equal(t, db.LogLag(nil), db.LogLag(&data.RecordPst{}))
+}
@@ -0,0 +1,210 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/sidneychang/no-db/db/data"
+)
+
+var (
+	// ErrPositionCompacted is returned when a tail position points into a data
+	// file that no longer exists, e.g. because it was replaced by a merge.
+	ErrPositionCompacted = errors.New("log position has been compacted")
+	// ErrInvalidPosition is returned when a tail position lies beyond the end of its data file.
+	ErrInvalidPosition = errors.New("log position is beyond the end of the data file")
+	// ErrTailerClosed is returned by Next after the tailer has been closed.
+	ErrTailerClosed = errors.New("tailer is closed")
+	// ErrTailerBehind is returned by Merge when an open tailer has not yet read
+	// all the records of the files that would be compacted.
+	ErrTailerBehind = errors.New("merge would compact records not yet read by a tailer")
+)
+
+// Tailer reads the records of the data files in the order they were written,
+// following the active file as it grows and rotates.
+// It is the building block for change data capture and log shipping.
+type Tailer struct {
+	db     *DB
+	pst    data.RecordPst // position of the next record to read
+	closed bool
+	// compacted is set when the tailer was opened inside the files rewritten by a merge
+	compacted bool
+}
+
+// Tail opens a tailer positioned at from. A nil position starts at the beginning of the log.
+// The tailer must be closed when it is no longer used, otherwise it holds back Merge.
+// Closing the db closes its tailers.
+// Positions in the files rewritten by a merge, other than the beginning of
+// the log, are compacted.
+func (db *DB) Tail(from *data.RecordPst) *Tailer {
+	t := &Tailer{db: db}
+	if from != nil {
+		t.pst = *from
+	}
+	db.lock.Lock()
+	t.compacted = t.pst != (data.RecordPst{}) && t.pst.Fid < db.mergedFileID
+	db.tailers[t] = struct{}{}
+	db.lock.Unlock()
+	return t
+}
+
+// LogPosition returns the position right after the last record in the log,
+// i.e. where the next write will be appended.
+func (db *DB) LogPosition() *data.RecordPst {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	if db.activeFile == nil {
+		return &data.RecordPst{}
+	}
+	return &data.RecordPst{
+		Fid:    db.activeFile.FileID,
+		Offset: db.activeFile.WriteOff,
+	}
+}
+
+// Next returns the next record in the log along with its position, blocking
+// until one is written or ctx is done. The key of the returned record is the
+// user key, without the sequence number prefix.
+func (t *Tailer) Next(ctx context.Context) (*data.Record, *data.RecordPst, error) {
+	for {
+		record, pst, notify, err := t.read()
+		if err != nil || record != nil {
+			return record, pst, err
+		}
+		if notify == nil {
+			// moved on to the next data file
+			continue
+		}
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+}
+
+// read tries to read the record at the current position. When the end of the
+// log is reached it returns the channel to wait on for new records, and when
+// the end of an older file is reached it advances to the next file and returns nothing.
+func (t *Tailer) read() (*data.Record, *data.RecordPst, <-chan struct{}, error) {
+	db := t.db
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	if t.closed {
+		return nil, nil, nil, ErrTailerClosed
+	}
+	if t.compacted {
+		return nil, nil, nil, ErrPositionCompacted
+	}
+	if db.activeFile == nil || t.pst.Fid > db.activeFile.FileID {
+		return nil, nil, db.appendNotify, nil
+	}
+
+	dataFile := db.dataFile(t.pst.Fid)
+	if dataFile == nil {
+		return nil, nil, nil, ErrPositionCompacted
+	}
+	size, err := dataFile.IoManager.Size()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if t.pst.Offset > size {
+		return nil, nil, nil, ErrInvalidPosition
+	}
+
+	record, recordSize, err := dataFile.ReadRecord(t.pst.Offset)
+	if err == io.EOF {
+		if dataFile == db.activeFile {
+			return nil, nil, db.appendNotify, nil
+		}
+		t.pst = data.RecordPst{Fid: db.nextFileID(t.pst.Fid)}
+		return nil, nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	pst := &data.RecordPst{Fid: t.pst.Fid, Offset: t.pst.Offset}
+	t.pst.Offset += recordSize
+	realKey, _ := parseRecordKeyAndSeq(record.Key)
+	record.Key = realKey
+	return record, pst, nil, nil
+}
+
+// Position returns the position of the next record the tailer will read.
+func (t *Tailer) Position() *data.RecordPst {
+	t.db.lock.RLock()
+	defer t.db.lock.RUnlock()
+	pst := t.pst
+	return &pst
+}
+
+// Close releases the tailer. Pending and later calls to Next return ErrTailerClosed.
+func (t *Tailer) Close() {
+	t.db.lock.Lock()
+	defer t.db.lock.Unlock()
+	if t.closed {
+		return
+	}
+	t.closed = true
+	delete(t.db.tailers, t)
+	t.db.notifyTailers()
+}
+
+// notifyTailers wakes up the tailers waiting for new records.
+// hold a mutex before accessing this method
+func (db *DB) notifyTailers() {
+	close(db.appendNotify)
+	db.appendNotify = make(chan struct{})
+}
+
+// dataFile returns the data file with the given id, or nil if it does not exist.
+// hold a mutex before accessing this method
+func (db *DB) dataFile(fid uint32) *data.DataFile {
+	if db.activeFile != nil && fid == db.activeFile.FileID {
+		return db.activeFile
+	}
+	return db.olderFiles[fid]
+}
+
+// nextFileID returns the id of the data file following fid in the log.
+// hold a mutex before accessing this method
+func (db *DB) nextFileID(fid uint32) uint32 {
+	next := db.activeFile.FileID
+	for id := range db.olderFiles {
+		if id > fid && id < next {
+			next = id
+		}
+	}
+	return next
+}
+
+// checkTailersBeforeMerge makes sure no open tailer still has unread records
+// in the data files, which Merge is about to rotate and compact.
+// hold a mutex before accessing this method
+func (db *DB) checkTailersBeforeMerge() error {
+	active := db.activeFile
+	for t := range db.tailers {
+		if t.pst.Fid > active.FileID || (t.pst.Fid == active.FileID && t.pst.Offset >= active.WriteOff) {
+			continue
+		}
+		// a tailer at the end of the file before an empty active file has read everything
+		if active.WriteOff == 0 && t.pst.Fid < active.FileID && db.nextFileID(t.pst.Fid) == active.FileID {
+			dataFile := db.dataFile(t.pst.Fid)
+			if dataFile == nil {
+				continue
+			}
+			size, err := dataFile.IoManager.Size()
+			if err != nil {
+				return err
+			}
+			if t.pst.Offset >= size {
+				continue
+			}
+		}
+		return ErrTailerBehind
+	}
+	return nil
+}
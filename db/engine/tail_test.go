@@ -0,0 +1,128 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sidneychang/no-db/config"
+	"github.com/sidneychang/no-db/db/data"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTailTestDB(t *testing.T) *DB {
+	options := config.NewOptions(1, 1024, t.TempDir())
+	// small data files so that the tailer has to follow file rotation
+	options.DataFileSize = 256
+	db, err := NewDB(*options)
+	assert.Nil(t, err)
+	return db
+}
+
+func TestTailer_FollowsRotation(t *testing.T) {
+	db := newTailTestDB(t)
+
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("value-%d", i))))
+	}
+	assert.Nil(t, db.Delete([]byte("key-0")))
+	assert.Greater(t, len(db.olderFiles), 1)
+
+	tailer := db.Tail(nil)
+	defer tailer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for i := 0; i < 50; i++ {
+		record, _, err := tailer.Next(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, data.Normal, record.Type)
+		assert.Equal(t, fmt.Sprintf("key-%d", i), string(record.Key))
+		assert.Equal(t, fmt.Sprintf("value-%d", i), string(record.Value))
+	}
+	record, _, err := tailer.Next(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, data.Deleted, record.Type)
+	assert.Equal(t, "key-0", string(record.Key))
+	assert.Equal(t, db.LogPosition(), tailer.Position())
+}
+
+func TestTailer_WaitsForWrites(t *testing.T) {
+	db := newTailTestDB(t)
+	tailer := db.Tail(db.LogPosition())
+	defer tailer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	_, _, err := tailer.Next(ctx)
+	cancel()
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_ = db.Put([]byte("late"), []byte("value"))
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	record, pst, err := tailer.Next(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "late", string(record.Key))
+	assert.Equal(t, uint32(0), pst.Fid)
+
+	// resuming from a saved position yields the same record again
+	resumed := db.Tail(pst)
+	defer resumed.Close()
+	record, _, err = resumed.Next(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "late", string(record.Key))
+}
+
+func TestTailer_HoldsBackMerge(t *testing.T) {
+	db := newTailTestDB(t)
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("key-%d", i)), []byte("value")))
+	}
+
+	tailer := db.Tail(nil)
+	active, older := db.activeFile.FileID, len(db.olderFiles)
+	assert.Equal(t, ErrTailerBehind, db.Merge())
+	// a rejected merge does not rotate the active file
+	assert.Equal(t, active, db.activeFile.FileID)
+	assert.Equal(t, older, len(db.olderFiles))
+
+	// once the tailer has read everything the merge goes ahead
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for i := 0; i < 20; i++ {
+		_, _, err := tailer.Next(ctx)
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, db.Merge())
+	assert.Equal(t, active+1, db.activeFile.FileID)
+
+	tailer.Close()
+	_, _, err := tailer.Next(context.Background())
+	assert.Equal(t, ErrTailerClosed, err)
+}
+
+func TestTailer_ClosedWithDB(t *testing.T) {
+	db := newTailTestDB(t)
+	tailer := db.Tail(db.LogPosition())
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := tailer.Next(context.Background())
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	assert.Nil(t, db.Close())
+	select {
+	case err := <-done:
+		assert.Equal(t, ErrTailerClosed, err)
+	case <-time.After(time.Second):
+		t.Fatal("tailer blocked after the db was closed")
+	}
+	_, _, err := tailer.Next(context.Background())
+	assert.Equal(t, ErrTailerClosed, err)
+	tailer.Close()
+}
@@ -0,0 +1,23 @@
+package engine
+
+import (
+	"errors"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer records the spans of the engine with the global tracer provider,
+// they are dropped unless the process sets one up.
+var tracer = otel.Tracer("github.com/sidneychang/no-db/db/engine")
+
+// spanError marks the span as failed when err is not nil, a missing key is
+// not a failure, and returns err.
+func spanError(span trace.Span, err error) error {
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
@@ -2,7 +2,6 @@ package index
 
 import (
 	"bytes"
-	"fmt"
 	"sort"
 	"sync"
 
@@ -33,7 +32,6 @@ func (s *SkipList) Put(key []byte, pst *data.RecordPst) bool {
 func (s *SkipList) Get(key []byte) *data.RecordPst {
 	s.lock.RLock()
 	defer s.lock.RUnlock()
-	fmt.Println("searching key")
 	res := s.list.Find(key)
 	if res != nil {
 		return *res
@@ -66,8 +64,11 @@ type SkipListIterator struct {
 	values       []*Item
 }
 
+// NewSkipListIterator copies the entries of s into a new iterator.
+// hold s.lock before accessing this method: taking the read lock again here could
+// wait behind a writer queued after the caller's read lock and never return
 func NewSkipListIterator(s *SkipList, reverse bool) *SkipListIterator {
-	expectedSize := s.Size()
+	expectedSize := s.list.Len()
 
 	values := make([]*Item, 0, expectedSize)
 
@@ -3,6 +3,7 @@ package index
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/sidneychang/no-db/db/data"
 	"github.com/stretchr/testify/assert"
@@ -91,3 +92,37 @@ func TestSkipList_Iterator(t *testing.T) {
 		assert.NotNil(t, iter6.Key())
 	}
 }
+
+func TestSkipList_IteratorConcurrentPuts(t *testing.T) {
+	sk := NewSkipList()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 2000; i++ {
+			sk.Put([]byte(fmt.Sprintf("key-%d", i)), &data.RecordPst{Fid: 1, Offset: int64(i)})
+		}
+	}()
+
+	// creating iterators while writers queue up must never wait on the iterator's own read lock
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			it := sk.Iterator(false)
+			for it.Rewind(); it.Valid(); it.Next() {
+			}
+			it.Close()
+		}
+	}()
+	select {
+	case <-finished:
+	case <-time.After(10 * time.Second):
+		t.Fatal("iterating under concurrent puts deadlocked")
+	}
+	assert.Equal(t, 2000, sk.Size())
+}
@@ -0,0 +1,68 @@
+// Package logging builds the structured logger of the server, which is passed
+// on to the storage engine through config.Options.
+package logging
+
+import (
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// FormatJSON writes one JSON object per entry, for log pipelines.
+	FormatJSON = "json"
+	// FormatConsole writes human readable entries.
+	FormatConsole = "console"
+)
+
+// Config describes the entries a logger writes and how.
+type Config struct {
+	Level  string // debug, info, warn or error
+	Format string // FormatJSON or FormatConsole
+	// Sampling keeps the first Sampling entries with the same level and
+	// message every second and every Sampling-th after that, 0 keeps them all.
+	Sampling int
+}
+
+// New returns a logger writing to stderr.
+func New(config Config) (*zap.Logger, error) {
+	level, err := zapcore.ParseLevel(config.Level)
+	if err != nil {
+		return nil, err
+	}
+	var zapConfig zap.Config
+	switch config.Format {
+	case FormatJSON:
+		zapConfig = zap.NewProductionConfig()
+		zapConfig.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	case FormatConsole:
+		zapConfig = zap.NewDevelopmentConfig()
+		zapConfig.Development = false
+	default:
+		return nil, fmt.Errorf("unknown log format %q, expected json or console", config.Format)
+	}
+	zapConfig.Level = zap.NewAtomicLevelAt(level)
+	zapConfig.DisableStacktrace = level > zapcore.DebugLevel
+	zapConfig.Sampling = nil
+	logger, err := zapConfig.Build()
+	if err != nil {
+		return nil, err
+	}
+	if config.Sampling > 0 {
+		logger = logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			return zapcore.NewSamplerWithOptions(core, time.Second, config.Sampling, config.Sampling)
+		}))
+	}
+	return logger, nil
+}
+
+// Value returns a field holding value, or only its size when redact is set,
+// so that the data stored in the db does not end up in the logs.
+func Value(value string, redact bool) zap.Field {
+	if redact {
+		return zap.String("value", fmt.Sprintf("[redacted %d bytes]", len(value)))
+	}
+	return zap.String("value", value)
+}
@@ -0,0 +1,29 @@
+package logging
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestNew(t *testing.T) {
+	logger, err := New(Config{Level: "warn", Format: FormatJSON, Sampling: 10})
+	assert.Nil(t, err)
+	assert.False(t, logger.Core().Enabled(zapcore.InfoLevel))
+	assert.True(t, logger.Core().Enabled(zapcore.WarnLevel))
+
+	logger, err = New(Config{Level: "debug", Format: FormatConsole})
+	assert.Nil(t, err)
+	assert.True(t, logger.Core().Enabled(zapcore.DebugLevel))
+
+	_, err = New(Config{Level: "verbose", Format: FormatJSON})
+	assert.NotNil(t, err)
+	_, err = New(Config{Level: "info", Format: "xml"})
+	assert.NotNil(t, err)
+}
+
+func TestValue(t *testing.T) {
+	assert.Equal(t, "[redacted 6 bytes]", Value("secret", true).String)
+	assert.Equal(t, "secret", Value("secret", false).String)
+}
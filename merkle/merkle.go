@@ -0,0 +1,124 @@
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/fnv"
+)
+
+// MaxDepth bounds the number of leaves of a tree to 2^MaxDepth.
+const MaxDepth = 20
+
+var ErrInvalidTree = errors.New("invalid merkle tree")
+
+// Tree is a Merkle tree over a key space split into 2^depth hash buckets.
+// Nodes are stored in heap order: the children of node i are 2i+1 and 2i+2,
+// and the leaf of bucket b is node 2^depth-1+b.
+type Tree struct {
+	depth uint32
+	nodes [][]byte
+}
+
+// Bucket returns the bucket a key belongs to in a tree of the given depth.
+func Bucket(key []byte, depth uint32) uint32 {
+	if depth == 0 {
+		return 0
+	}
+	h := fnv.New64a()
+	_, _ = h.Write(key)
+	return uint32(h.Sum64() >> (64 - depth))
+}
+
+// Builder computes the leaf hashes of a tree from key/value pairs.
+// Pairs must be added in key order so that equal key spaces give equal trees.
+type Builder struct {
+	depth  uint32
+	leaves []hash.Hash
+}
+
+func NewBuilder(depth uint32) *Builder {
+	if depth > MaxDepth {
+		depth = MaxDepth
+	}
+	leaves := make([]hash.Hash, 1<<depth)
+	for i := range leaves {
+		leaves[i] = sha256.New()
+	}
+	return &Builder{depth: depth, leaves: leaves}
+}
+
+// Add adds a key/value pair to the leaf of its bucket.
+func (b *Builder) Add(key []byte, value []byte) {
+	leaf := b.leaves[Bucket(key, b.depth)]
+	var size [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(size[:], uint64(len(key)))
+	_, _ = leaf.Write(size[:n])
+	_, _ = leaf.Write(key)
+	n = binary.PutUvarint(size[:], uint64(len(value)))
+	_, _ = leaf.Write(size[:n])
+	_, _ = leaf.Write(value)
+}
+
+// Build hashes the leaves up to the root.
+func (b *Builder) Build() *Tree {
+	leafCount := 1 << b.depth
+	nodes := make([][]byte, 2*leafCount-1)
+	for i, leaf := range b.leaves {
+		nodes[leafCount-1+i] = leaf.Sum(nil)
+	}
+	for i := leafCount - 2; i >= 0; i-- {
+		h := sha256.New()
+		_, _ = h.Write(nodes[2*i+1])
+		_, _ = h.Write(nodes[2*i+2])
+		nodes[i] = h.Sum(nil)
+	}
+	return &Tree{depth: b.depth, nodes: nodes}
+}
+
+// FromNodes rebuilds a tree received from another node.
+func FromNodes(depth uint32, nodes [][]byte) (*Tree, error) {
+	if depth > MaxDepth || len(nodes) != 2*(1<<depth)-1 {
+		return nil, ErrInvalidTree
+	}
+	return &Tree{depth: depth, nodes: nodes}, nil
+}
+
+func (t *Tree) Depth() uint32 {
+	return t.depth
+}
+
+// Nodes returns all the node hashes in heap order.
+func (t *Tree) Nodes() [][]byte {
+	return t.nodes
+}
+
+func (t *Tree) Root() []byte {
+	return t.nodes[0]
+}
+
+// Diff returns the buckets whose content differs between two trees of the same depth,
+// only descending into the subtrees whose hashes differ.
+func Diff(a, b *Tree) ([]uint32, error) {
+	if a.depth != b.depth || len(a.nodes) != len(b.nodes) {
+		return nil, ErrInvalidTree
+	}
+	firstLeaf := (1 << a.depth) - 1
+	var buckets []uint32
+	var walk func(i int)
+	walk = func(i int) {
+		if bytes.Equal(a.nodes[i], b.nodes[i]) {
+			return
+		}
+		if i >= firstLeaf {
+			buckets = append(buckets, uint32(i-firstLeaf))
+			return
+		}
+		walk(2*i + 1)
+		walk(2*i + 2)
+	}
+	walk(0)
+	return buckets, nil
+}
@@ -0,0 +1,62 @@
+package merkle
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func buildTree(depth uint32, kv map[string]string, keys []string) *Tree {
+	b := NewBuilder(depth)
+	for _, k := range keys {
+		if v, ok := kv[k]; ok {
+			b.Add([]byte(k), []byte(v))
+		}
+	}
+	return b.Build()
+}
+
+func TestTree_Diff(t *testing.T) {
+	var keys []string
+	kv := make(map[string]string)
+	for i := 0; i < 1000; i++ {
+		k := fmt.Sprintf("key-%04d", i)
+		keys = append(keys, k)
+		kv[k] = fmt.Sprintf("value-%d", i)
+	}
+	a := buildTree(8, kv, keys)
+	b := buildTree(8, kv, keys)
+	assert.Equal(t, a.Root(), b.Root())
+	diff, err := Diff(a, b)
+	assert.Nil(t, err)
+	assert.Empty(t, diff)
+
+	// a changed value and a missing key are found in their buckets only
+	kv["key-0010"] = "changed"
+	delete(kv, "key-0500")
+	c := buildTree(8, kv, keys)
+	assert.NotEqual(t, a.Root(), c.Root())
+	diff, err = Diff(a, c)
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []uint32{Bucket([]byte("key-0010"), 8), Bucket([]byte("key-0500"), 8)}, diff)
+}
+
+func TestTree_FromNodes(t *testing.T) {
+	a := NewBuilder(4).Build()
+	b, err := FromNodes(4, a.Nodes())
+	assert.Nil(t, err)
+	assert.Equal(t, a.Root(), b.Root())
+
+	_, err = FromNodes(5, a.Nodes())
+	assert.Equal(t, ErrInvalidTree, err)
+	_, err = Diff(a, NewBuilder(3).Build())
+	assert.Equal(t, ErrInvalidTree, err)
+}
+
+func TestBucket(t *testing.T) {
+	assert.Equal(t, uint32(0), Bucket([]byte("key"), 0))
+	for i := 0; i < 100; i++ {
+		assert.Less(t, Bucket([]byte(fmt.Sprintf("key-%d", i)), 6), uint32(64))
+	}
+}
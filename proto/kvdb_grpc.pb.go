@@ -23,6 +23,7 @@ const (
 	KVDB_Get_FullMethodName         = "/proto.KVDB/Get"
 	KVDB_Delete_FullMethodName      = "/proto.KVDB/Delete"
 	KVDB_ListAllData_FullMethodName = "/proto.KVDB/ListAllData"
+	KVDB_ClusterInfo_FullMethodName = "/proto.KVDB/ClusterInfo"
 )
 
 // KVDBClient is the client API for KVDB service.
@@ -32,7 +33,9 @@ type KVDBClient interface {
 	Put(ctx context.Context, in *PutRequest, opts ...grpc.CallOption) (*Empty, error)
 	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error)
 	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*Empty, error)
-	ListAllData(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*ListAllDataResponse, error)
+	ListAllData(ctx context.Context, in *ListAllDataRequest, opts ...grpc.CallOption) (*ListAllDataResponse, error)
+	// ClusterInfo returns the topology this server last learned from the coordinator.
+	ClusterInfo(ctx context.Context, in *ClusterInfoRequest, opts ...grpc.CallOption) (*ClusterInfoResponse, error)
 }
 
 type kVDBClient struct {
@@ -73,7 +76,7 @@ func (c *kVDBClient) Delete(ctx context.Context, in *DeleteRequest, opts ...grpc
 	return out, nil
 }
 
-func (c *kVDBClient) ListAllData(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*ListAllDataResponse, error) {
+func (c *kVDBClient) ListAllData(ctx context.Context, in *ListAllDataRequest, opts ...grpc.CallOption) (*ListAllDataResponse, error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
 	out := new(ListAllDataResponse)
 	err := c.cc.Invoke(ctx, KVDB_ListAllData_FullMethodName, in, out, cOpts...)
@@ -83,6 +86,16 @@ func (c *kVDBClient) ListAllData(ctx context.Context, in *Empty, opts ...grpc.Ca
 	return out, nil
 }
 
+func (c *kVDBClient) ClusterInfo(ctx context.Context, in *ClusterInfoRequest, opts ...grpc.CallOption) (*ClusterInfoResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ClusterInfoResponse)
+	err := c.cc.Invoke(ctx, KVDB_ClusterInfo_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // KVDBServer is the server API for KVDB service.
 // All implementations must embed UnimplementedKVDBServer
 // for forward compatibility.
@@ -90,7 +103,9 @@ type KVDBServer interface {
 	Put(context.Context, *PutRequest) (*Empty, error)
 	Get(context.Context, *GetRequest) (*GetResponse, error)
 	Delete(context.Context, *DeleteRequest) (*Empty, error)
-	ListAllData(context.Context, *Empty) (*ListAllDataResponse, error)
+	ListAllData(context.Context, *ListAllDataRequest) (*ListAllDataResponse, error)
+	// ClusterInfo returns the topology this server last learned from the coordinator.
+	ClusterInfo(context.Context, *ClusterInfoRequest) (*ClusterInfoResponse, error)
 	mustEmbedUnimplementedKVDBServer()
 }
 
@@ -110,9 +125,12 @@ func (UnimplementedKVDBServer) Get(context.Context, *GetRequest) (*GetResponse,
 func (UnimplementedKVDBServer) Delete(context.Context, *DeleteRequest) (*Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
 }
-func (UnimplementedKVDBServer) ListAllData(context.Context, *Empty) (*ListAllDataResponse, error) {
+func (UnimplementedKVDBServer) ListAllData(context.Context, *ListAllDataRequest) (*ListAllDataResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ListAllData not implemented")
 }
+func (UnimplementedKVDBServer) ClusterInfo(context.Context, *ClusterInfoRequest) (*ClusterInfoResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ClusterInfo not implemented")
+}
 func (UnimplementedKVDBServer) mustEmbedUnimplementedKVDBServer() {}
 func (UnimplementedKVDBServer) testEmbeddedByValue()              {}
 
@@ -189,7 +207,7 @@ func _KVDB_Delete_Handler(srv interface{}, ctx context.Context, dec func(interfa
 }
 
 func _KVDB_ListAllData_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(Empty)
+	in := new(ListAllDataRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
@@ -201,7 +219,25 @@ func _KVDB_ListAllData_Handler(srv interface{}, ctx context.Context, dec func(in
 		FullMethod: KVDB_ListAllData_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(KVDBServer).ListAllData(ctx, req.(*Empty))
+		return srv.(KVDBServer).ListAllData(ctx, req.(*ListAllDataRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KVDB_ClusterInfo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClusterInfoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KVDBServer).ClusterInfo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KVDB_ClusterInfo_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KVDBServer).ClusterInfo(ctx, req.(*ClusterInfoRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
@@ -229,6 +265,557 @@ var KVDB_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "ListAllData",
 			Handler:    _KVDB_ListAllData_Handler,
 		},
+		{
+			MethodName: "ClusterInfo",
+			Handler:    _KVDB_ClusterInfo_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "proto/kvdb.proto",
+}
+
+const (
+	Replication_Pull_FullMethodName        = "/proto.Replication/Pull"
+	Replication_Ack_FullMethodName         = "/proto.Replication/Ack"
+	Replication_MerkleTree_FullMethodName  = "/proto.Replication/MerkleTree"
+	Replication_ScanBuckets_FullMethodName = "/proto.Replication/ScanBuckets"
+	Replication_Handoff_FullMethodName     = "/proto.Replication/Handoff"
+)
+
+// ReplicationClient is the client API for Replication service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Replication is served by the primary. Replicas pull the records of its
+// data files by position and acknowledge the position they have applied.
+// For anti-entropy, replicas compare Merkle trees of the key space with the
+// primary and scan the buckets that differ.
+//
+// Handoff moves the keys a shard no longer owns to their new owner after the
+// topology changes. The new owner opens the stream and acknowledges every
+// batch with the digest of the values it has stored; the old owner deletes a
+// batch only once the digests match.
+type ReplicationClient interface {
+	Pull(ctx context.Context, in *PullRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[LogRecord], error)
+	Ack(ctx context.Context, in *AckRequest, opts ...grpc.CallOption) (*Empty, error)
+	MerkleTree(ctx context.Context, in *MerkleTreeRequest, opts ...grpc.CallOption) (*MerkleTreeResponse, error)
+	ScanBuckets(ctx context.Context, in *ScanBucketsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[KeyValue], error)
+	Handoff(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[HandoffAck, HandoffBatch], error)
+}
+
+type replicationClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewReplicationClient(cc grpc.ClientConnInterface) ReplicationClient {
+	return &replicationClient{cc}
+}
+
+func (c *replicationClient) Pull(ctx context.Context, in *PullRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[LogRecord], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Replication_ServiceDesc.Streams[0], Replication_Pull_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[PullRequest, LogRecord]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Replication_PullClient = grpc.ServerStreamingClient[LogRecord]
+
+func (c *replicationClient) Ack(ctx context.Context, in *AckRequest, opts ...grpc.CallOption) (*Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, Replication_Ack_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *replicationClient) MerkleTree(ctx context.Context, in *MerkleTreeRequest, opts ...grpc.CallOption) (*MerkleTreeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(MerkleTreeResponse)
+	err := c.cc.Invoke(ctx, Replication_MerkleTree_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *replicationClient) ScanBuckets(ctx context.Context, in *ScanBucketsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[KeyValue], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Replication_ServiceDesc.Streams[1], Replication_ScanBuckets_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ScanBucketsRequest, KeyValue]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Replication_ScanBucketsClient = grpc.ServerStreamingClient[KeyValue]
+
+func (c *replicationClient) Handoff(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[HandoffAck, HandoffBatch], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Replication_ServiceDesc.Streams[2], Replication_Handoff_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[HandoffAck, HandoffBatch]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Replication_HandoffClient = grpc.BidiStreamingClient[HandoffAck, HandoffBatch]
+
+// ReplicationServer is the server API for Replication service.
+// All implementations must embed UnimplementedReplicationServer
+// for forward compatibility.
+//
+// Replication is served by the primary. Replicas pull the records of its
+// data files by position and acknowledge the position they have applied.
+// For anti-entropy, replicas compare Merkle trees of the key space with the
+// primary and scan the buckets that differ.
+//
+// Handoff moves the keys a shard no longer owns to their new owner after the
+// topology changes. The new owner opens the stream and acknowledges every
+// batch with the digest of the values it has stored; the old owner deletes a
+// batch only once the digests match.
+type ReplicationServer interface {
+	Pull(*PullRequest, grpc.ServerStreamingServer[LogRecord]) error
+	Ack(context.Context, *AckRequest) (*Empty, error)
+	MerkleTree(context.Context, *MerkleTreeRequest) (*MerkleTreeResponse, error)
+	ScanBuckets(*ScanBucketsRequest, grpc.ServerStreamingServer[KeyValue]) error
+	Handoff(grpc.BidiStreamingServer[HandoffAck, HandoffBatch]) error
+	mustEmbedUnimplementedReplicationServer()
+}
+
+// UnimplementedReplicationServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedReplicationServer struct{}
+
+func (UnimplementedReplicationServer) Pull(*PullRequest, grpc.ServerStreamingServer[LogRecord]) error {
+	return status.Errorf(codes.Unimplemented, "method Pull not implemented")
+}
+func (UnimplementedReplicationServer) Ack(context.Context, *AckRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Ack not implemented")
+}
+func (UnimplementedReplicationServer) MerkleTree(context.Context, *MerkleTreeRequest) (*MerkleTreeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MerkleTree not implemented")
+}
+func (UnimplementedReplicationServer) ScanBuckets(*ScanBucketsRequest, grpc.ServerStreamingServer[KeyValue]) error {
+	return status.Errorf(codes.Unimplemented, "method ScanBuckets not implemented")
+}
+func (UnimplementedReplicationServer) Handoff(grpc.BidiStreamingServer[HandoffAck, HandoffBatch]) error {
+	return status.Errorf(codes.Unimplemented, "method Handoff not implemented")
+}
+func (UnimplementedReplicationServer) mustEmbedUnimplementedReplicationServer() {}
+func (UnimplementedReplicationServer) testEmbeddedByValue()                     {}
+
+// UnsafeReplicationServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ReplicationServer will
+// result in compilation errors.
+type UnsafeReplicationServer interface {
+	mustEmbedUnimplementedReplicationServer()
+}
+
+func RegisterReplicationServer(s grpc.ServiceRegistrar, srv ReplicationServer) {
+	// If the following call panics, it indicates UnimplementedReplicationServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Replication_ServiceDesc, srv)
+}
+
+func _Replication_Pull_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(PullRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ReplicationServer).Pull(m, &grpc.GenericServerStream[PullRequest, LogRecord]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Replication_PullServer = grpc.ServerStreamingServer[LogRecord]
+
+func _Replication_Ack_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AckRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReplicationServer).Ack(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Replication_Ack_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReplicationServer).Ack(ctx, req.(*AckRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Replication_MerkleTree_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MerkleTreeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReplicationServer).MerkleTree(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Replication_MerkleTree_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReplicationServer).MerkleTree(ctx, req.(*MerkleTreeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Replication_ScanBuckets_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ScanBucketsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ReplicationServer).ScanBuckets(m, &grpc.GenericServerStream[ScanBucketsRequest, KeyValue]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Replication_ScanBucketsServer = grpc.ServerStreamingServer[KeyValue]
+
+func _Replication_Handoff_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ReplicationServer).Handoff(&grpc.GenericServerStream[HandoffAck, HandoffBatch]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Replication_HandoffServer = grpc.BidiStreamingServer[HandoffAck, HandoffBatch]
+
+// Replication_ServiceDesc is the grpc.ServiceDesc for Replication service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Replication_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "proto.Replication",
+	HandlerType: (*ReplicationServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Ack",
+			Handler:    _Replication_Ack_Handler,
+		},
+		{
+			MethodName: "MerkleTree",
+			Handler:    _Replication_MerkleTree_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Pull",
+			Handler:       _Replication_Pull_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ScanBuckets",
+			Handler:       _Replication_ScanBuckets_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Handoff",
+			Handler:       _Replication_Handoff_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "proto/kvdb.proto",
+}
+
+const (
+	Coordinator_AcquireLease_FullMethodName = "/proto.Coordinator/AcquireLease"
+	Coordinator_GetLease_FullMethodName     = "/proto.Coordinator/GetLease"
+	Coordinator_Register_FullMethodName     = "/proto.Coordinator/Register"
+	Coordinator_ClusterInfo_FullMethodName  = "/proto.Coordinator/ClusterInfo"
+	Coordinator_Decommission_FullMethodName = "/proto.Coordinator/Decommission"
+)
+
+// CoordinatorClient is the client API for Coordinator service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Coordinator grants per-shard primary leases. The primary keeps renewing its
+// lease, and a replica is promoted once the lease of its shard expires.
+//
+// The coordinator also keeps the cluster membership: every server registers
+// itself periodically, and a member that stops registering is dropped once
+// its ttl expires.
+type CoordinatorClient interface {
+	AcquireLease(ctx context.Context, in *LeaseRequest, opts ...grpc.CallOption) (*Lease, error)
+	GetLease(ctx context.Context, in *GetLeaseRequest, opts ...grpc.CallOption) (*Lease, error)
+	Register(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (*ClusterInfoResponse, error)
+	ClusterInfo(ctx context.Context, in *ClusterInfoRequest, opts ...grpc.CallOption) (*ClusterInfoResponse, error)
+	// Decommission takes a shard off the ring, so its keys are handed off to
+	// the other shards before it is stopped.
+	Decommission(ctx context.Context, in *DecommissionRequest, opts ...grpc.CallOption) (*ClusterInfoResponse, error)
+}
+
+type coordinatorClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewCoordinatorClient(cc grpc.ClientConnInterface) CoordinatorClient {
+	return &coordinatorClient{cc}
+}
+
+func (c *coordinatorClient) AcquireLease(ctx context.Context, in *LeaseRequest, opts ...grpc.CallOption) (*Lease, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Lease)
+	err := c.cc.Invoke(ctx, Coordinator_AcquireLease_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *coordinatorClient) GetLease(ctx context.Context, in *GetLeaseRequest, opts ...grpc.CallOption) (*Lease, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Lease)
+	err := c.cc.Invoke(ctx, Coordinator_GetLease_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *coordinatorClient) Register(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (*ClusterInfoResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ClusterInfoResponse)
+	err := c.cc.Invoke(ctx, Coordinator_Register_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *coordinatorClient) ClusterInfo(ctx context.Context, in *ClusterInfoRequest, opts ...grpc.CallOption) (*ClusterInfoResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ClusterInfoResponse)
+	err := c.cc.Invoke(ctx, Coordinator_ClusterInfo_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *coordinatorClient) Decommission(ctx context.Context, in *DecommissionRequest, opts ...grpc.CallOption) (*ClusterInfoResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ClusterInfoResponse)
+	err := c.cc.Invoke(ctx, Coordinator_Decommission_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CoordinatorServer is the server API for Coordinator service.
+// All implementations must embed UnimplementedCoordinatorServer
+// for forward compatibility.
+//
+// Coordinator grants per-shard primary leases. The primary keeps renewing its
+// lease, and a replica is promoted once the lease of its shard expires.
+//
+// The coordinator also keeps the cluster membership: every server registers
+// itself periodically, and a member that stops registering is dropped once
+// its ttl expires.
+type CoordinatorServer interface {
+	AcquireLease(context.Context, *LeaseRequest) (*Lease, error)
+	GetLease(context.Context, *GetLeaseRequest) (*Lease, error)
+	Register(context.Context, *RegisterRequest) (*ClusterInfoResponse, error)
+	ClusterInfo(context.Context, *ClusterInfoRequest) (*ClusterInfoResponse, error)
+	// Decommission takes a shard off the ring, so its keys are handed off to
+	// the other shards before it is stopped.
+	Decommission(context.Context, *DecommissionRequest) (*ClusterInfoResponse, error)
+	mustEmbedUnimplementedCoordinatorServer()
+}
+
+// UnimplementedCoordinatorServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedCoordinatorServer struct{}
+
+func (UnimplementedCoordinatorServer) AcquireLease(context.Context, *LeaseRequest) (*Lease, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AcquireLease not implemented")
+}
+func (UnimplementedCoordinatorServer) GetLease(context.Context, *GetLeaseRequest) (*Lease, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetLease not implemented")
+}
+func (UnimplementedCoordinatorServer) Register(context.Context, *RegisterRequest) (*ClusterInfoResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Register not implemented")
+}
+func (UnimplementedCoordinatorServer) ClusterInfo(context.Context, *ClusterInfoRequest) (*ClusterInfoResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ClusterInfo not implemented")
+}
+func (UnimplementedCoordinatorServer) Decommission(context.Context, *DecommissionRequest) (*ClusterInfoResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Decommission not implemented")
+}
+func (UnimplementedCoordinatorServer) mustEmbedUnimplementedCoordinatorServer() {}
+func (UnimplementedCoordinatorServer) testEmbeddedByValue()                     {}
+
+// UnsafeCoordinatorServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to CoordinatorServer will
+// result in compilation errors.
+type UnsafeCoordinatorServer interface {
+	mustEmbedUnimplementedCoordinatorServer()
+}
+
+func RegisterCoordinatorServer(s grpc.ServiceRegistrar, srv CoordinatorServer) {
+	// If the following call panics, it indicates UnimplementedCoordinatorServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Coordinator_ServiceDesc, srv)
+}
+
+func _Coordinator_AcquireLease_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LeaseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoordinatorServer).AcquireLease(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Coordinator_AcquireLease_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoordinatorServer).AcquireLease(ctx, req.(*LeaseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Coordinator_GetLease_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetLeaseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoordinatorServer).GetLease(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Coordinator_GetLease_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoordinatorServer).GetLease(ctx, req.(*GetLeaseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Coordinator_Register_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoordinatorServer).Register(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Coordinator_Register_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoordinatorServer).Register(ctx, req.(*RegisterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Coordinator_ClusterInfo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClusterInfoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoordinatorServer).ClusterInfo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Coordinator_ClusterInfo_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoordinatorServer).ClusterInfo(ctx, req.(*ClusterInfoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Coordinator_Decommission_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DecommissionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoordinatorServer).Decommission(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Coordinator_Decommission_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoordinatorServer).Decommission(ctx, req.(*DecommissionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Coordinator_ServiceDesc is the grpc.ServiceDesc for Coordinator service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Coordinator_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "proto.Coordinator",
+	HandlerType: (*CoordinatorServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "AcquireLease",
+			Handler:    _Coordinator_AcquireLease_Handler,
+		},
+		{
+			MethodName: "GetLease",
+			Handler:    _Coordinator_GetLease_Handler,
+		},
+		{
+			MethodName: "Register",
+			Handler:    _Coordinator_Register_Handler,
+		},
+		{
+			MethodName: "ClusterInfo",
+			Handler:    _Coordinator_ClusterInfo_Handler,
+		},
+		{
+			MethodName: "Decommission",
+			Handler:    _Coordinator_Decommission_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/kvdb.proto",
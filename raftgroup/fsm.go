@@ -0,0 +1,74 @@
+package raftgroup
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/hashicorp/raft"
+	"github.com/sidneychang/no-db/db/data"
+	"github.com/sidneychang/no-db/db/engine"
+	pb "github.com/sidneychang/no-db/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+var _ raft.FSM = (*FSM)(nil)
+
+// FSM applies the committed raft log entries to an engine.DB.
+// Each log entry is a pb.LogRecord describing a put or a delete.
+type FSM struct {
+	db *engine.DB
+}
+
+func NewFSM(db *engine.DB) *FSM {
+	return &FSM{db: db}
+}
+
+// Apply applies a committed log entry, returning the error of the write, if any.
+func (f *FSM) Apply(entry *raft.Log) interface{} {
+	record := &pb.LogRecord{}
+	if err := proto.Unmarshal(entry.Data, record); err != nil {
+		return err
+	}
+	switch data.RecordType(record.Type) {
+	case data.Normal:
+		return f.db.Namespace(record.Namespace).Put(record.Key, record.Value)
+	case data.Deleted:
+		return f.db.Namespace(record.Namespace).Delete(record.Key)
+	default:
+		return fmt.Errorf("unknown record type %d", record.Type)
+	}
+}
+
+// Snapshot captures the data files as they are after the last applied entry.
+// They are append only, so they can be copied while later entries are applied.
+func (f *FSM) Snapshot() (raft.FSMSnapshot, error) {
+	files, err := f.db.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &fsmSnapshot{db: f.db, files: files}, nil
+}
+
+// Restore replaces the content of the db with the data files of a snapshot.
+func (f *FSM) Restore(snapshot io.ReadCloser) error {
+	defer snapshot.Close()
+	return f.db.Restore(snapshot)
+}
+
+type fsmSnapshot struct {
+	db    *engine.DB
+	files []engine.SnapshotFile
+}
+
+func (s *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
+	if err := s.db.WriteSnapshot(sink, s.files); err != nil {
+		_ = sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+// Release lets the db merge or restore its data files again.
+func (s *fsmSnapshot) Release() {
+	s.db.ReleaseSnapshot()
+}
@@ -0,0 +1,99 @@
+package raftgroup
+
+import (
+	"io"
+	"log"
+
+	"github.com/hashicorp/go-hclog"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// zapLogger writes the logs of raft, which logs through hclog, to a zap
+// logger. Trace entries are logged at debug level, and the level is set on
+// the zap logger rather than through SetLevel.
+type zapLogger struct {
+	root   *zap.Logger
+	name   string
+	args   []interface{}
+	logger *zap.SugaredLogger
+}
+
+func newZapLogger(root *zap.Logger, name string, args []interface{}) *zapLogger {
+	logger := root
+	if name != "" {
+		logger = logger.Named(name)
+	}
+	return &zapLogger{root: root, name: name, args: args, logger: logger.Sugar().With(args...)}
+}
+
+func (l *zapLogger) Log(level hclog.Level, msg string, args ...interface{}) {
+	switch level {
+	case hclog.Trace, hclog.Debug:
+		l.logger.Debugw(msg, args...)
+	case hclog.Warn:
+		l.logger.Warnw(msg, args...)
+	case hclog.Error:
+		l.logger.Errorw(msg, args...)
+	default:
+		l.logger.Infow(msg, args...)
+	}
+}
+
+func (l *zapLogger) Trace(msg string, args ...interface{}) { l.Log(hclog.Trace, msg, args...) }
+func (l *zapLogger) Debug(msg string, args ...interface{}) { l.Log(hclog.Debug, msg, args...) }
+func (l *zapLogger) Info(msg string, args ...interface{})  { l.Log(hclog.Info, msg, args...) }
+func (l *zapLogger) Warn(msg string, args ...interface{})  { l.Log(hclog.Warn, msg, args...) }
+func (l *zapLogger) Error(msg string, args ...interface{}) { l.Log(hclog.Error, msg, args...) }
+
+func (l *zapLogger) enabled(level zapcore.Level) bool { return l.root.Core().Enabled(level) }
+
+func (l *zapLogger) IsTrace() bool { return l.enabled(zapcore.DebugLevel) }
+func (l *zapLogger) IsDebug() bool { return l.enabled(zapcore.DebugLevel) }
+func (l *zapLogger) IsInfo() bool  { return l.enabled(zapcore.InfoLevel) }
+func (l *zapLogger) IsWarn() bool  { return l.enabled(zapcore.WarnLevel) }
+func (l *zapLogger) IsError() bool { return l.enabled(zapcore.ErrorLevel) }
+
+func (l *zapLogger) ImpliedArgs() []interface{} { return l.args }
+
+func (l *zapLogger) With(args ...interface{}) hclog.Logger {
+	return newZapLogger(l.root, l.name, append(l.args[:len(l.args):len(l.args)], args...))
+}
+
+func (l *zapLogger) Name() string { return l.name }
+
+func (l *zapLogger) Named(name string) hclog.Logger {
+	if l.name != "" {
+		name = l.name + "." + name
+	}
+	return newZapLogger(l.root, name, l.args)
+}
+
+func (l *zapLogger) ResetNamed(name string) hclog.Logger {
+	return newZapLogger(l.root, name, l.args)
+}
+
+func (l *zapLogger) SetLevel(hclog.Level) {}
+
+func (l *zapLogger) GetLevel() hclog.Level {
+	switch {
+	case l.IsDebug():
+		return hclog.Debug
+	case l.IsInfo():
+		return hclog.Info
+	case l.IsWarn():
+		return hclog.Warn
+	case l.IsError():
+		return hclog.Error
+	default:
+		return hclog.Off
+	}
+}
+
+func (l *zapLogger) StandardLogger(*hclog.StandardLoggerOptions) *log.Logger {
+	return zap.NewStdLog(l.logger.Desugar())
+}
+
+func (l *zapLogger) StandardWriter(opts *hclog.StandardLoggerOptions) io.Writer {
+	return l.StandardLogger(opts).Writer()
+}
@@ -0,0 +1,217 @@
+package raftgroup
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+	"github.com/sidneychang/no-db/db/engine"
+	pb "github.com/sidneychang/no-db/proto"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	defaultApplyTimeout  = 5 * time.Second
+	transportMaxPool     = 3
+	transportTimeout     = 10 * time.Second
+	retainSnapshotCount  = 2
+	raftLogStoreFileName = "raft.db"
+	defaultRaftDirSuffix = "raft"
+)
+
+// ErrNotLeader is returned when a write is proposed on a node that is not the leader.
+var ErrNotLeader = errors.New("node is not the raft leader")
+
+// Config is the configuration of a raft group member.
+type Config struct {
+	// ID identifies the node in the group. The server uses its gRPC address,
+	// so that the leader id can be handed to clients.
+	ID string
+	// BindAddr is the address the raft transport listens on.
+	BindAddr string
+	// Advertise is the raft address announced to the other members. Default is the bound address.
+	Advertise string
+	// Dir is where the raft log and snapshots are stored.
+	Dir string
+	// Raft overrides the default raft configuration, e.g. for faster timeouts in tests.
+	Raft *raft.Config
+	// Logger receives the logs of raft, its transport and snapshots, nil discards them.
+	Logger *zap.Logger
+}
+
+// Peer is a voting member of a raft group.
+type Peer struct {
+	ID   string
+	Addr string
+}
+
+// Node is a member of a raft group replicating writes to its engine.DB.
+type Node struct {
+	raft      *raft.Raft
+	transport *raft.NetworkTransport
+	logStore  *raftboltdb.BoltStore
+}
+
+// NewNode starts a raft group member using db as its state machine.
+func NewNode(cfg Config, db *engine.DB) (*Node, error) {
+	raftConfig := raft.DefaultConfig()
+	if cfg.Raft != nil {
+		raftConfig = cfg.Raft
+	}
+	raftConfig.LocalID = raft.ServerID(cfg.ID)
+	zl := cfg.Logger
+	if zl == nil {
+		zl = zap.NewNop()
+	}
+	logger := newZapLogger(zl, "raft", nil)
+	if raftConfig.Logger == nil {
+		raftConfig.Logger = logger
+	}
+
+	if err := os.MkdirAll(cfg.Dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	var advertise net.Addr
+	if cfg.Advertise != "" {
+		addr, err := net.ResolveTCPAddr("tcp", cfg.Advertise)
+		if err != nil {
+			return nil, err
+		}
+		advertise = addr
+	}
+	transport, err := raft.NewTCPTransportWithLogger(cfg.BindAddr, advertise, transportMaxPool, transportTimeout, logger.Named("transport"))
+	if err != nil {
+		return nil, err
+	}
+
+	logStore, err := raftboltdb.NewBoltStore(filepath.Join(cfg.Dir, raftLogStoreFileName))
+	if err != nil {
+		_ = transport.Close()
+		return nil, err
+	}
+	snapshots, err := raft.NewFileSnapshotStoreWithLogger(cfg.Dir, retainSnapshotCount, logger.Named("snapshot"))
+	if err != nil {
+		_ = transport.Close()
+		_ = logStore.Close()
+		return nil, err
+	}
+
+	r, err := raft.NewRaft(raftConfig, NewFSM(db), logStore, logStore, snapshots, transport)
+	if err != nil {
+		_ = transport.Close()
+		_ = logStore.Close()
+		return nil, err
+	}
+	return &Node{raft: r, transport: transport, logStore: logStore}, nil
+}
+
+// DefaultDir returns the directory used for the raft state of a db stored in dirPath.
+func DefaultDir(dirPath string) string {
+	return filepath.Join(dirPath, defaultRaftDirSuffix)
+}
+
+// Addr returns the address of the raft transport.
+func (n *Node) Addr() string {
+	return string(n.transport.LocalAddr())
+}
+
+// Bootstrap creates a new group made of peers. It must be called on a single
+// member the first time the group starts, and is a no-op once the group has state.
+func (n *Node) Bootstrap(peers []Peer) error {
+	configuration := raft.Configuration{}
+	for _, peer := range peers {
+		configuration.Servers = append(configuration.Servers, raft.Server{
+			ID:      raft.ServerID(peer.ID),
+			Address: raft.ServerAddress(peer.Addr),
+		})
+	}
+	err := n.raft.BootstrapCluster(configuration).Error()
+	if errors.Is(err, raft.ErrCantBootstrap) {
+		return nil
+	}
+	return err
+}
+
+// Join adds a voting member to the group. It must be called on the leader.
+func (n *Node) Join(peer Peer) error {
+	return n.raft.AddVoter(raft.ServerID(peer.ID), raft.ServerAddress(peer.Addr), 0, defaultApplyTimeout).Error()
+}
+
+// Apply proposes a put or a delete and returns once it is committed by a
+// majority of the group and applied to the local db.
+func (n *Node) Apply(record *pb.LogRecord) error {
+	if n.raft.State() != raft.Leader {
+		return ErrNotLeader
+	}
+	buf, err := proto.Marshal(record)
+	if err != nil {
+		return err
+	}
+	future := n.raft.Apply(buf, defaultApplyTimeout)
+	if err := future.Error(); err != nil {
+		if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipLost) {
+			return ErrNotLeader
+		}
+		return err
+	}
+	if err, ok := future.Response().(error); ok && err != nil {
+		return err
+	}
+	return nil
+}
+
+// IsLeader reports whether the node is currently the leader of its group.
+func (n *Node) IsLeader() bool {
+	return n.raft.State() == raft.Leader
+}
+
+// Leader returns the id of the current leader, or an empty string if there is none.
+func (n *Node) Leader() string {
+	_, id := n.raft.LeaderWithID()
+	return string(id)
+}
+
+// State returns the raft state of the node, e.g. Leader or Follower.
+func (n *Node) State() string {
+	return n.raft.State().String()
+}
+
+// Snapshot takes a snapshot of the db and compacts the raft log.
+func (n *Node) Snapshot() error {
+	return n.raft.Snapshot().Error()
+}
+
+// Shutdown stops the node and releases its transport and log store.
+func (n *Node) Shutdown() error {
+	if err := n.raft.Shutdown().Error(); err != nil {
+		return err
+	}
+	if err := n.transport.Close(); err != nil {
+		return err
+	}
+	return n.logStore.Close()
+}
+
+// ParsePeers parses a comma separated list of id=addr pairs.
+func ParsePeers(peers string) ([]Peer, error) {
+	var result []Peer
+	for _, item := range strings.Split(peers, ",") {
+		if item == "" {
+			continue
+		}
+		id, addr, ok := strings.Cut(item, "=")
+		if !ok || id == "" || addr == "" {
+			return nil, fmt.Errorf("invalid raft peer %q, expected id=addr", item)
+		}
+		result = append(result, Peer{ID: id, Addr: addr})
+	}
+	return result, nil
+}
@@ -0,0 +1,175 @@
+package raftgroup
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/sidneychang/no-db/config"
+	"github.com/sidneychang/no-db/db/data"
+	"github.com/sidneychang/no-db/db/engine"
+	pb "github.com/sidneychang/no-db/proto"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+type testMember struct {
+	id   string
+	db   *engine.DB
+	node *Node
+}
+
+func newTestMember(t *testing.T, id string) *testMember {
+	return newTestMemberWithLogger(t, id, nil)
+}
+
+func newTestMemberWithLogger(t *testing.T, id string, logger *zap.Logger) *testMember {
+	dir := t.TempDir()
+	db, err := engine.NewDB(*config.NewOptions(1, 1024, dir))
+	assert.Nil(t, err)
+
+	raftConfig := raft.DefaultConfig()
+	raftConfig.HeartbeatTimeout = 100 * time.Millisecond
+	raftConfig.ElectionTimeout = 100 * time.Millisecond
+	raftConfig.LeaderLeaseTimeout = 50 * time.Millisecond
+	raftConfig.CommitTimeout = 5 * time.Millisecond
+	// keep no trailing logs so that new members have to install a snapshot
+	raftConfig.TrailingLogs = 0
+	raftConfig.LogLevel = "ERROR"
+
+	node, err := NewNode(Config{
+		ID:       id,
+		BindAddr: "127.0.0.1:0",
+		Dir:      filepath.Join(dir, "raft"),
+		Raft:     raftConfig,
+		Logger:   logger,
+	}, db)
+	assert.Nil(t, err)
+	return &testMember{id: id, db: db, node: node}
+}
+
+func waitForLeader(t *testing.T, members []*testMember) *testMember {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, m := range members {
+			if m.node.IsLeader() {
+				return m
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("no leader elected")
+	return nil
+}
+
+func waitForValue(t *testing.T, db *engine.DB, key, value string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if v, err := db.Get([]byte(key)); err == nil && string(v) == value {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("key %s did not reach value %s", key, value)
+}
+
+func put(key, value string) *pb.LogRecord {
+	return &pb.LogRecord{Key: []byte(key), Value: []byte(value), Type: uint32(data.Normal)}
+}
+
+func TestRaftGroup_Failover(t *testing.T) {
+	var members []*testMember
+	var peers []Peer
+	for i := 0; i < 3; i++ {
+		m := newTestMember(t, fmt.Sprintf("node-%d", i))
+		members = append(members, m)
+		peers = append(peers, Peer{ID: m.id, Addr: m.node.Addr()})
+	}
+	defer func() {
+		for _, m := range members {
+			_ = m.node.Shutdown()
+		}
+	}()
+	assert.Nil(t, members[0].node.Bootstrap(peers))
+
+	leader := waitForLeader(t, members)
+	for _, m := range members {
+		if m != leader {
+			assert.Equal(t, ErrNotLeader, m.node.Apply(put("a", "1")))
+		}
+	}
+	assert.Nil(t, leader.node.Apply(put("a", "1")))
+	assert.Nil(t, leader.node.Apply(&pb.LogRecord{Key: []byte("a"), Type: uint32(data.Deleted)}))
+	assert.Nil(t, leader.node.Apply(put("b", "2")))
+	for _, m := range members {
+		waitForValue(t, m.db, "b", "2")
+		_, err := m.db.Get([]byte("a"))
+		assert.NotNil(t, err)
+	}
+
+	// the remaining members elect a new leader and keep accepting writes
+	assert.Nil(t, leader.node.Shutdown())
+	var remaining []*testMember
+	for _, m := range members {
+		if m != leader {
+			remaining = append(remaining, m)
+		}
+	}
+	newLeader := waitForLeader(t, remaining)
+	assert.Equal(t, newLeader.id, remaining[0].node.Leader())
+	assert.Nil(t, newLeader.node.Apply(put("c", "3")))
+	for _, m := range remaining {
+		waitForValue(t, m.db, "c", "3")
+	}
+}
+
+func TestRaftGroup_SnapshotInstall(t *testing.T) {
+	first := newTestMember(t, "node-0")
+	defer first.node.Shutdown()
+	assert.Nil(t, first.node.Bootstrap([]Peer{{ID: first.id, Addr: first.node.Addr()}}))
+	waitForLeader(t, []*testMember{first})
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, first.node.Apply(put(fmt.Sprintf("key-%d", i), fmt.Sprintf("value-%d", i))))
+	}
+	assert.Nil(t, first.node.Snapshot())
+
+	// the log has been compacted, so the new member is brought up to date
+	// by installing the data files of the snapshot
+	second := newTestMember(t, "node-1")
+	defer second.node.Shutdown()
+	assert.Nil(t, second.db.Put([]byte("stale"), []byte("value")))
+	assert.Nil(t, first.node.Join(Peer{ID: second.id, Addr: second.node.Addr()}))
+
+	waitForValue(t, second.db, "key-99", "value-99")
+	_, err := second.db.Get([]byte("stale"))
+	assert.NotNil(t, err)
+
+	assert.Nil(t, first.node.Apply(put("after", "snapshot")))
+	waitForValue(t, second.db, "after", "snapshot")
+}
+
+func TestRaftGroup_Logger(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	member := newTestMemberWithLogger(t, "node-0", zap.New(core))
+	defer member.node.Shutdown()
+	assert.Nil(t, member.node.Bootstrap([]Peer{{ID: member.id, Addr: member.node.Addr()}}))
+	waitForLeader(t, []*testMember{member})
+	assert.Nil(t, member.node.Apply(put("key", "value")))
+	assert.Nil(t, member.node.Snapshot())
+
+	// raft and its snapshot store log through the logger, with their fields
+	named := func(name string) *observer.ObservedLogs {
+		return logs.Filter(func(entry observer.LoggedEntry) bool { return entry.LoggerName == name })
+	}
+	assert.NotZero(t, named("raft").FilterMessage("entering leader state").Len())
+	snapshots := named("raft.snapshot").All()
+	if assert.NotEmpty(t, snapshots) {
+		assert.Contains(t, snapshots[0].ContextMap(), "path")
+	}
+	assert.Zero(t, logs.FilterLevelExact(zapcore.DebugLevel).Len())
+}
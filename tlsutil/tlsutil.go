@@ -0,0 +1,260 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+var (
+	ErrNoCertificate = errors.New("a certificate and its key are required")
+	ErrInvalidCA     = errors.New("no certificates found in the CA file")
+	ErrNoServerName  = errors.New("no server name to verify the server certificate against")
+)
+
+// Reloader holds a certificate and a CA pool loaded from PEM files.
+// Connections always use the latest loaded files, so certificates can be
+// rotated without restarting the process.
+type Reloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+	modTime time.Time
+}
+
+// NewReloader loads the certificate and key files, and the CA file if given.
+// Either the certificate and key or the CA file may be empty.
+func NewReloader(certFile, keyFile, caFile string) (*Reloader, error) {
+	if (certFile == "") != (keyFile == "") {
+		return nil, ErrNoCertificate
+	}
+	r := &Reloader{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload loads the files again. The previous files stay in use on error.
+func (r *Reloader) Reload() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+	var cert *tls.Certificate
+	if r.certFile != "" {
+		c, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err != nil {
+			return err
+		}
+		cert = &c
+	}
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		content, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(content) {
+			return ErrInvalidCA
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert, r.pool, r.modTime = cert, pool, modTime
+	return nil
+}
+
+// Watch reloads the files every interval when they have changed, until ctx is done.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		modTime, err := r.latestModTime()
+		if err == nil {
+			r.mu.RLock()
+			changed := modTime.After(r.modTime)
+			r.mu.RUnlock()
+			if !changed {
+				continue
+			}
+			err = r.Reload()
+		}
+		if err != nil && onError != nil {
+			onError(err)
+		}
+	}
+}
+
+func (r *Reloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{r.certFile, r.keyFile, r.caFile} {
+		if name == "" {
+			continue
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (r *Reloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, r.pool
+}
+
+// ServerConfig returns the TLS configuration of a server. When a CA file is
+// configured, clients must present a certificate signed by it (mutual TLS).
+func (r *Reloader) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := r.current()
+			if cert == nil {
+				return nil, ErrNoCertificate
+			}
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+				NextProtos:   []string{"h2"},
+			}
+			if pool != nil {
+				config.ClientCAs = pool
+				config.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return config, nil
+		},
+	}
+}
+
+// ClientConfig returns the TLS configuration of a client. The server is verified
+// against the CA file if configured, otherwise against the system roots, and the
+// certificate is presented to servers that ask for one. The server certificate
+// must be valid for serverName; with a CA file the handshake fails when no
+// server name is known.
+func (r *Reloader) ClientConfig(serverName string) *tls.Config {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			if cert == nil {
+				return &tls.Certificate{}, nil
+			}
+			return cert, nil
+		},
+	}
+	if r.caFile != "" {
+		// the default verification only supports a fixed pool, so the server
+		// is verified against the latest pool in VerifyConnection instead
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			return r.verifyServer(state, serverName)
+		}
+	}
+	return config
+}
+
+// verifyServer verifies the server certificate against the latest CA pool.
+// The name comes from the configuration rather than the connection state,
+// which has no server name when dialing an IP address, in which case the
+// hostname would not be checked at all.
+func (r *Reloader) verifyServer(state tls.ConnectionState, serverName string) error {
+	if serverName == "" {
+		serverName = state.ServerName
+	}
+	if serverName == "" {
+		return ErrNoServerName
+	}
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("server presented no certificate")
+	}
+	_, pool := r.current()
+	opts := x509.VerifyOptions{
+		DNSName:       serverName,
+		Roots:         pool,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range state.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := state.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// ServerCredentials returns gRPC server credentials using ServerConfig.
+func (r *Reloader) ServerCredentials() credentials.TransportCredentials {
+	return credentials.NewTLS(r.ServerConfig())
+}
+
+// ClientCredentials returns gRPC client credentials using ClientConfig.
+// An empty serverName verifies the host of the dialed address.
+func (r *Reloader) ClientCredentials(serverName string) credentials.TransportCredentials {
+	return &clientCredentials{r: r, serverName: serverName}
+}
+
+// clientCredentials builds the TLS configuration of each connection for the
+// host it dials, so that the server is verified against it.
+type clientCredentials struct {
+	r          *Reloader
+	serverName string
+}
+
+func (c *clientCredentials) tls(serverName string) credentials.TransportCredentials {
+	return credentials.NewTLS(c.r.ClientConfig(serverName))
+}
+
+func (c *clientCredentials) ClientHandshake(ctx context.Context, authority string, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	serverName := c.serverName
+	if serverName == "" {
+		host, _, err := net.SplitHostPort(authority)
+		if err != nil {
+			host = authority
+		}
+		serverName = host
+	}
+	return c.tls(serverName).ClientHandshake(ctx, authority, rawConn)
+}
+
+func (c *clientCredentials) ServerHandshake(rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return c.tls(c.serverName).ServerHandshake(rawConn)
+}
+
+func (c *clientCredentials) Info() credentials.ProtocolInfo {
+	return c.tls(c.serverName).Info()
+}
+
+func (c *clientCredentials) Clone() credentials.TransportCredentials {
+	clone := *c
+	return &clone
+}
+
+// OverrideServerName is deprecated in gRPC and only kept to implement the interface.
+func (c *clientCredentials) OverrideServerName(serverName string) error {
+	c.serverName = serverName
+	return nil
+}
@@ -0,0 +1,222 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue writes a certificate for hosts signed by the CA and returns the cert
+// and key files, the certificate is for localhost when no host is given
+func (ca *testCA) issue(t *testing.T, dir string, name string, serial int64, hosts ...string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	if len(hosts) == 0 {
+		hosts = []string{"localhost", "127.0.0.1"}
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	assert.Nil(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}))
+	return certFile, keyFile
+}
+
+var writes int
+
+// writeFile replaces a file and moves its modification time forward, so that
+// Watch sees the change even within the timestamp resolution of the file system
+func writeFile(t *testing.T, name string, content []byte) {
+	assert.Nil(t, os.WriteFile(name, content, 0600))
+	writes++
+	future := time.Now().Add(time.Duration(writes) * time.Minute)
+	assert.Nil(t, os.Chtimes(name, future, future))
+}
+
+func startServer(t *testing.T, r *Reloader) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	server := grpc.NewServer(grpc.Creds(r.ServerCredentials()))
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return listener.Addr().String()
+}
+
+func check(r *Reloader, addr string) error {
+	return checkServerName(r, addr, "")
+}
+
+func checkServerName(r *Reloader, addr string, serverName string) error {
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(r.ClientCredentials(serverName)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	return err
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, "ca")
+	caFile := filepath.Join(dir, "ca.crt")
+	writeFile(t, caFile, ca.pem)
+
+	serverCert, serverKey := ca.issue(t, dir, "server", 2)
+	server, err := NewReloader(serverCert, serverKey, caFile)
+	assert.Nil(t, err)
+	addr := startServer(t, server)
+
+	clientCert, clientKey := ca.issue(t, dir, "client", 3)
+	client, err := NewReloader(clientCert, clientKey, caFile)
+	assert.Nil(t, err)
+	assert.Nil(t, check(client, addr))
+
+	// clients without a certificate, or with one from another CA, are rejected
+	anonymous, err := NewReloader("", "", caFile)
+	assert.Nil(t, err)
+	assert.NotNil(t, check(anonymous, addr))
+
+	otherDir := t.TempDir()
+	other := newTestCA(t, "other")
+	otherCert, otherKey := other.issue(t, otherDir, "client", 4)
+	untrusted, err := NewReloader(otherCert, otherKey, caFile)
+	assert.Nil(t, err)
+	assert.NotNil(t, check(untrusted, addr))
+
+	_, err = NewReloader(clientCert, "", caFile)
+	assert.Equal(t, ErrNoCertificate, err)
+	_, err = NewReloader(clientCert, clientKey, clientKey)
+	assert.Equal(t, ErrInvalidCA, err)
+}
+
+func TestClientCredentials_VerifiesServerName(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, "ca")
+	caFile := filepath.Join(dir, "ca.crt")
+	writeFile(t, caFile, ca.pem)
+	serverCert, serverKey := ca.issue(t, dir, "server", 2, "db.example.com")
+	server, err := NewReloader(serverCert, serverKey, "")
+	assert.Nil(t, err)
+	addr := startServer(t, server)
+	client, err := NewReloader("", "", caFile)
+	assert.Nil(t, err)
+
+	// a certificate signed by the CA but issued for another host is rejected,
+	// also when dialing by IP address
+	assert.NotNil(t, check(client, addr))
+	_, port, err := net.SplitHostPort(addr)
+	assert.Nil(t, err)
+	assert.NotNil(t, check(client, net.JoinHostPort("localhost", port)))
+	assert.Nil(t, checkServerName(client, addr, "db.example.com"))
+
+	// without a name to verify the certificate against the handshake fails
+	_, err = tls.Dial("tcp", addr, client.ClientConfig(""))
+	assert.ErrorIs(t, err, ErrNoServerName)
+}
+
+func TestReloader_HotReload(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, "ca")
+	caFile := filepath.Join(dir, "ca.crt")
+	writeFile(t, caFile, ca.pem)
+	serverCert, serverKey := ca.issue(t, dir, "server", 2)
+	server, err := NewReloader(serverCert, serverKey, "")
+	assert.Nil(t, err)
+	addr := startServer(t, server)
+
+	clientDir := t.TempDir()
+	clientCA := filepath.Join(clientDir, "ca.crt")
+	writeFile(t, clientCA, ca.pem)
+	client, err := NewReloader("", "", clientCA)
+	assert.Nil(t, err)
+	assert.Nil(t, check(client, addr))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Watch(ctx, 10*time.Millisecond, nil)
+	go client.Watch(ctx, 10*time.Millisecond, nil)
+
+	// the server moves to a certificate of a new CA without restarting
+	rotated := newTestCA(t, "rotated")
+	rotated.issue(t, dir, "server", 5)
+	assert.Eventually(t, func() bool {
+		cert, _ := server.current()
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		return err == nil && leaf.SerialNumber.Int64() == 5
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.NotNil(t, check(client, addr))
+
+	// and the client trusts it once its CA file is updated
+	writeFile(t, clientCA, rotated.pem)
+	assert.Eventually(t, func() bool {
+		return check(client, addr) == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	conn, err := tls.Dial("tcp", addr, client.ClientConfig("localhost"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64())
+	conn.Close()
+}
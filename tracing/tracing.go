@@ -0,0 +1,104 @@
+// Package tracing sets up OpenTelemetry tracing for the client and the server.
+// Spans are propagated between processes in gRPC metadata using the W3C trace
+// context format, so a put can be followed from the client through the server,
+// its forwarding and replication, down to the storage engine.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc/filters"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"google.golang.org/grpc"
+)
+
+const (
+	// ExporterNone disables tracing, spans are still propagated to other processes.
+	ExporterNone = ""
+	// ExporterStdout prints the spans to stdout.
+	ExporterStdout = "stdout"
+	// ExporterOTLP sends the spans to an OTLP collector over gRPC.
+	ExporterOTLP = "otlp"
+)
+
+// Config describes where the spans of a process go.
+type Config struct {
+	Service  string  // service.name of the spans
+	Exporter string  // ExporterNone, ExporterStdout or ExporterOTLP
+	Endpoint string  // host:port of the OTLP collector, default localhost:4317 or OTEL_EXPORTER_OTLP_ENDPOINT
+	Sample   float64 // fraction of the traces started by this process that are recorded, 0 records all of them
+}
+
+// Setup installs the global propagator and, unless the exporter is
+// ExporterNone, a global tracer provider exporting the spans. The returned
+// function flushes the pending spans and must be called before exiting.
+func Setup(ctx context.Context, config Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch config.Exporter {
+	case ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterOTLP:
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithInsecure()}
+		if config.Endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(config.Endpoint))
+		}
+		exporter, err = otlptracegrpc.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q, expected stdout or otlp", config.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+		resource.WithAttributes(semconv.ServiceName(config.Service)))
+	if err != nil {
+		return nil, err
+	}
+	sampler := sdktrace.AlwaysSample()
+	if config.Sample > 0 && config.Sample < 1 {
+		sampler = sdktrace.TraceIDRatioBased(config.Sample)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		// a process records a trace started elsewhere when the caller does
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)))
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// untraced filters out the RPCs that run all the time in the background and
+// would drown the requests in the traces: health checks and replication acks.
+var untraced = otelgrpc.WithFilter(filters.Not(filters.Any(
+	filters.HealthCheck(),
+	filters.FullMethodName("/proto.Replication/Ack"),
+)))
+
+// ServerOption makes a gRPC server continue the traces of incoming requests.
+func ServerOption() grpc.ServerOption {
+	return grpc.StatsHandler(otelgrpc.NewServerHandler(untraced))
+}
+
+// DialOption makes a gRPC connection trace its requests and propagate the
+// span of the caller to the server.
+func DialOption() grpc.DialOption {
+	return grpc.WithStatsHandler(otelgrpc.NewClientHandler(untraced))
+}
@@ -0,0 +1,21 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetup(t *testing.T) {
+	shutdown, err := Setup(context.Background(), Config{Service: "test"})
+	assert.Nil(t, err)
+	assert.Nil(t, shutdown(context.Background()))
+
+	_, err = Setup(context.Background(), Config{Service: "test", Exporter: "jaeger"})
+	assert.NotNil(t, err)
+
+	shutdown, err = Setup(context.Background(), Config{Service: "test", Exporter: ExporterStdout, Sample: 0.5})
+	assert.Nil(t, err)
+	assert.Nil(t, shutdown(context.Background()))
+}